@@ -8,13 +8,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"slices"
 	"strings"
 	"time"
 
+	"github.com/PlakarKorp/kloset/events"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
-	"github.com/PlakarKorp/plakar/utils"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -24,12 +23,16 @@ type Packet struct {
 	ExitCode int
 	Eof      bool
 	Err      string
+	Job      int64
 }
 
 type Client struct {
 	conn net.Conn
 	enc  *msgpack.Encoder
 	dec  *msgpack.Decoder
+
+	// Agent is the answer of the agent to our Hello.
+	Agent *Hello
 }
 
 var (
@@ -41,6 +44,10 @@ func ExecuteRPC(ctx *appcontext.AppContext, name []string, cmd subcommands.Subco
 	if err != nil {
 		return 1, err
 	}
+	return execute(ctx, client, name, cmd, storeConfig)
+}
+
+func execute(ctx *appcontext.AppContext, client *Client, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int, error) {
 	defer client.Close()
 
 	go func() {
@@ -54,6 +61,36 @@ func ExecuteRPC(ctx *appcontext.AppContext, name []string, cmd subcommands.Subco
 	return 0, nil
 }
 
+// DetachRPC starts a command in the agent, or in remote if not nil,
+// without waiting for it to complete, and returns the ID of its job.  A
+// zero ID means the command completed before it could be detached.
+func DetachRPC(ctx *appcontext.AppContext, remote *Remote, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int64, int, error) {
+	ignoreVersion := cmd.GetFlags()&subcommands.IgnoreVersion != 0
+
+	var client *Client
+	var err error
+	if remote != nil {
+		client, err = NewRemoteClient(remote, ignoreVersion)
+	} else {
+		client, err = NewClient(filepath.Join(ctx.CacheDir, "agent.sock"), ignoreVersion)
+	}
+	if err != nil {
+		return 0, 1, err
+	}
+	defer client.Close()
+
+	if !client.Agent.Supports(CapDetach) {
+		return 0, 1, fmt.Errorf("the agent does not support detaching commands")
+	}
+
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+
+	return client.SendDetachedCommand(ctx, name, cmd, storeConfig)
+}
+
 func NewClient(socketPath string, ignoreVersion bool) (*Client, error) {
 	var lockfile *os.File
 	var spawned bool
@@ -66,16 +103,32 @@ func NewClient(socketPath string, ignoreVersion bool) (*Client, error) {
 	}()
 
 	var (
-		attempt int
-		conn    net.Conn
-		err     error
+		attempt    int
+		conn       net.Conn
+		err        error
+		restarting bool
 	)
 
 	for {
 		conn, err = net.Dial("unix", socketPath)
 		if err == nil {
-			// connected successfully!
-			break
+			c := &Client{
+				conn: conn,
+				enc:  msgpack.NewEncoder(conn),
+				dec:  msgpack.NewDecoder(conn),
+			}
+			err = c.handshake(ignoreVersion)
+			if err == nil {
+				return c, nil
+			}
+			conn.Close()
+			if errors.Is(err, ErrAgentRestarting) {
+				restarting = true
+			} else if !restarting || errors.Is(err, ErrWrongVersion) {
+				return nil, err
+			}
+			// The agent stops listening while it drains
+			// its jobs, then we can start a new one.
 		}
 
 		attempt++
@@ -119,51 +172,52 @@ func NewClient(socketPath string, ignoreVersion bool) (*Client, error) {
 
 		time.Sleep(5 * time.Millisecond)
 	}
-
-	encoder := msgpack.NewEncoder(conn)
-	decoder := msgpack.NewDecoder(conn)
-
-	c := &Client{
-		conn: conn,
-		enc:  encoder,
-		dec:  decoder,
-	}
-
-	if err := c.handshake(ignoreVersion); err != nil {
-		return nil, err
-	}
-
-	return c, nil
 }
 
+// handshake negotiates the protocol with the agent.  Unless ignoreVersion is
+// set, it fails if the agent doesn't speak any of our protocol versions.
 func (c *Client) handshake(ignoreVersion bool) error {
-	ourvers := []byte(utils.GetVersion())
-
-	if err := c.enc.Encode(ourvers); err != nil {
+	ours, err := EncodeHello(NewHello())
+	if err != nil {
+		return err
+	}
+	if err := c.enc.Encode(ours); err != nil {
 		return err
 	}
 
-	var agentvers []byte
-	if err := c.dec.Decode(&agentvers); err != nil {
+	var reply []byte
+	if err := c.dec.Decode(&reply); err != nil {
 		return err
 	}
 
-	if !ignoreVersion && !slices.Equal(ourvers, agentvers) {
-		return fmt.Errorf("%w (%v)", ErrWrongVersion, string(agentvers))
+	c.Agent, err = DecodeHello(reply)
+	if err != nil {
+		// the agent predates the negotiation and sent its version
+		c.Agent = &Hello{Version: string(reply)}
 	}
 
-	return nil
+	if ignoreVersion || !c.Agent.Incompatible() {
+		return nil
+	}
+	if c.Agent.Restarting {
+		return fmt.Errorf("%w (%v)", ErrAgentRestarting, c.Agent.Version)
+	}
+	return fmt.Errorf("%w (%v)", ErrWrongVersion, c.Agent.Version)
 }
 
-func (c *Client) SendCommand(ctx *appcontext.AppContext, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int, error) {
+func (c *Client) send(ctx *appcontext.AppContext, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) error {
 	if cmd.GetFlags()&subcommands.AgentSupport == 0 {
-		return 1, fmt.Errorf("command %v doesn't support execution through agent", strings.Join(name, " "))
+		return fmt.Errorf("command %v doesn't support execution through agent", strings.Join(name, " "))
 	}
 
 	cmd.SetLogInfo(ctx.GetLogger().EnabledInfo)
 	cmd.SetLogTraces(ctx.GetLogger().EnabledTracing)
 
-	if err := subcommands.EncodeRPC(c.enc, name, cmd, storeConfig); err != nil {
+	return subcommands.EncodeRPC(c.enc, name, cmd, storeConfig)
+}
+
+func (c *Client) SendCommand(ctx *appcontext.AppContext, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int, error) {
+	if err := c.send(ctx, name, cmd, storeConfig); err != nil {
 		return 1, err
 	}
 
@@ -198,6 +252,12 @@ func (c *Client) SendCommand(ctx *appcontext.AppContext, name []string, cmd subc
 			fmt.Printf("%s", string(response.Data))
 		case "stderr":
 			fmt.Fprintf(os.Stderr, "%s", string(response.Data))
+		case "event":
+			// pass the events on as if the command ran here,
+			// skipping the ones newer than us.
+			if event, err := events.Deserialize(response.Data); err == nil {
+				ctx.Events().Send(event)
+			}
 		case "exit":
 			var err error
 			if response.Err != "" {
@@ -209,6 +269,54 @@ func (c *Client) SendCommand(ctx *appcontext.AppContext, name []string, cmd subc
 	return 0, nil
 }
 
+// SendDetachedCommand sends a command and asks the agent to detach it,
+// printing its output until it is.  Detached commands have no standard
+// input.
+func (c *Client) SendDetachedCommand(ctx *appcontext.AppContext, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int64, int, error) {
+	if err := c.send(ctx, name, cmd, storeConfig); err != nil {
+		return 0, 1, err
+	}
+	if err := c.enc.Encode(&Packet{Type: "detach"}); err != nil {
+		return 0, 1, fmt.Errorf("failed to detach: %w", err)
+	}
+
+	var response Packet
+	for {
+		if err := c.dec.Decode(&response); err != nil {
+			if err == io.EOF {
+				return 0, 0, nil
+			}
+			if err := ctx.Err(); err != nil {
+				return 0, 1, err
+			}
+			return 0, 1, fmt.Errorf("failed to decode response: %w", err)
+		}
+		switch response.Type {
+		case "stdin":
+			pkt := &Packet{
+				Type: "stdin",
+				Eof:  true,
+				Err:  io.EOF.Error(),
+			}
+			if err := c.enc.Encode(pkt); err != nil {
+				return 0, 1, fmt.Errorf("failed to send stdin: %w", err)
+			}
+		case "stdout":
+			fmt.Printf("%s", string(response.Data))
+		case "stderr":
+			fmt.Fprintf(os.Stderr, "%s", string(response.Data))
+		case "detached":
+			return response.Job, 0, nil
+		case "exit":
+			var err error
+			if response.Err != "" {
+				err = fmt.Errorf("%s", response.Err)
+			}
+			return 0, response.ExitCode, err
+		}
+	}
+}
+
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
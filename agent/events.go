@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"github.com/PlakarKorp/kloset/events"
+)
+
+// Flush is sent to the events listeners of a command once it is over.
+// Since they receive the events one at a time, it returns once they are
+// done with the previous ones.
+type Flush struct{}
+
+// Forwarded tells whether the agent sends event to its clients.  The events
+// about each object and chunk are too many to be worth it.
+func Forwarded(event events.Event) bool {
+	switch event.(type) {
+	case events.Object, events.ObjectOK, events.Chunk, events.ChunkOK, Flush:
+		return false
+	default:
+		return true
+	}
+}
+
+// EventPacket returns the "event" packet carrying event.
+func EventPacket(event events.Event) (Packet, error) {
+	data, err := events.Serialize(event)
+	if err != nil {
+		return Packet{}, err
+	}
+	return Packet{Type: "event", Data: data}, nil
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventPacket(t *testing.T) {
+	var id objects.MAC
+	event := events.FileErrorEvent(id, "/a", "permission denied")
+	require.True(t, Forwarded(event))
+	require.False(t, Forwarded(events.ChunkOKEvent(id, id)))
+	require.False(t, Forwarded(Flush{}))
+
+	packet, err := EventPacket(event)
+	require.NoError(t, err)
+	require.Equal(t, "event", packet.Type)
+
+	decoded, err := events.Deserialize(packet.Data)
+	require.NoError(t, err)
+	require.Equal(t, event.Pathname, decoded.(events.FileError).Pathname)
+	require.Equal(t, event.Message, decoded.(events.FileError).Message)
+
+	_, err = EventPacket(Flush{})
+	require.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ProtocolVersion is the version of the protocol spoken between clients and
+// agents, bumped on incompatible changes only.  MinProtocolVersion is the
+// oldest version still spoken.  Version 0 is the protocol of the agents
+// predating the negotiation, which only talk with the exact same version of
+// plakar.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// The optional features of the protocol, which clients only use when the
+// agent supports them.
+const (
+	// CapDetach is the support for the "detach" packet.
+	CapDetach = "detach"
+
+	// CapEvents is the support for the "event" packets, carrying the
+	// events of the command.
+	CapEvents = "events"
+)
+
+// Capabilities are the optional features of the protocol supported by this
+// version of plakar.
+var Capabilities = []string{CapDetach, CapEvents}
+
+var ErrAgentRestarting = errors.New("agent is restarting to upgrade")
+
+// Hello is the first message of clients and agents.  Clients send the range
+// of protocol versions and the capabilities they support, and agents answer
+// with the highest version and the capabilities both support, or with a zero
+// Protocol if there is no such version.
+type Hello struct {
+	Version      string
+	Protocol     int
+	MinProtocol  int
+	Capabilities []string
+
+	// Restarting is set by agents too old for a client, which stop
+	// accepting commands and restart once their jobs are over.
+	Restarting bool
+}
+
+// NewHello returns the Hello of this version of plakar.
+func NewHello() *Hello {
+	return &Hello{
+		Version:      utils.GetVersion(),
+		Protocol:     ProtocolVersion,
+		MinProtocol:  MinProtocolVersion,
+		Capabilities: Capabilities,
+	}
+}
+
+// Supports tells whether capability was negotiated.
+func (h *Hello) Supports(capability string) bool {
+	return slices.Contains(h.Capabilities, capability)
+}
+
+// Incompatible tells whether the protocols don't overlap.
+func (h *Hello) Incompatible() bool {
+	return h.Protocol == 0
+}
+
+// Negotiate returns the answer of an agent greeted with hello.
+func Negotiate(hello *Hello) *Hello {
+	ours := NewHello()
+	reply := &Hello{
+		Version:     ours.Version,
+		Protocol:    min(hello.Protocol, ours.Protocol),
+		MinProtocol: ours.MinProtocol,
+	}
+	if reply.Protocol < max(hello.MinProtocol, ours.MinProtocol) {
+		reply.Protocol = 0
+		return reply
+	}
+	for _, capability := range hello.Capabilities {
+		if ours.Supports(capability) {
+			reply.Capabilities = append(reply.Capabilities, capability)
+		}
+	}
+	return reply
+}
+
+// EncodeHello encodes hello as the bytes in which the peers predating the
+// negotiation send their version, so that they still understand each other
+// well enough to report the mismatch.
+func EncodeHello(hello *Hello) ([]byte, error) {
+	return msgpack.Marshal(hello)
+}
+
+// DecodeHello decodes the Hello received from a peer, or fails if the peer
+// predates the negotiation, in which case data is its version.
+func DecodeHello(data []byte) (*Hello, error) {
+	var hello Hello
+	if err := msgpack.Unmarshal(data, &hello); err != nil {
+		return nil, err
+	}
+	if hello.Version == "" {
+		return nil, fmt.Errorf("invalid hello")
+	}
+	return &hello, nil
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	reply := Negotiate(NewHello())
+	require.False(t, reply.Incompatible())
+	require.Equal(t, ProtocolVersion, reply.Protocol)
+	require.Equal(t, utils.GetVersion(), reply.Version)
+	require.True(t, reply.Supports(CapDetach))
+
+	// a newer client still speaking our protocol
+	reply = Negotiate(&Hello{
+		Version:      "v9.0.0",
+		Protocol:     ProtocolVersion + 1,
+		MinProtocol:  ProtocolVersion,
+		Capabilities: []string{"teleport", CapDetach},
+	})
+	require.Equal(t, ProtocolVersion, reply.Protocol)
+	require.Equal(t, []string{CapDetach}, reply.Capabilities)
+	require.False(t, reply.Supports("teleport"))
+
+	// a client too recent for us
+	reply = Negotiate(&Hello{
+		Version:     "v9.0.0",
+		Protocol:    ProtocolVersion + 2,
+		MinProtocol: ProtocolVersion + 1,
+	})
+	require.True(t, reply.Incompatible())
+
+	// a client too old for us
+	reply = Negotiate(&Hello{
+		Version:     "v0.1.0",
+		Protocol:    MinProtocolVersion - 1,
+		MinProtocol: MinProtocolVersion - 1,
+	})
+	require.True(t, reply.Incompatible())
+}
+
+func TestDecodeHello(t *testing.T) {
+	data, err := EncodeHello(NewHello())
+	require.NoError(t, err)
+
+	hello, err := DecodeHello(data)
+	require.NoError(t, err)
+	require.Equal(t, NewHello(), hello)
+
+	// peers predating the negotiation send their version
+	_, err = DecodeHello([]byte("v1.0.4"))
+	require.Error(t, err)
+}
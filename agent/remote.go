@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Remote is an agent listening on TCP, reached over mutually
+// authenticated TLS.
+type Remote struct {
+	Addr      string
+	TLSConfig *tls.Config
+}
+
+// RemoteDir returns the directory in configDir holding the certificate,
+// key and certificate authority used to reach remote agents.
+func RemoteDir(configDir string) string {
+	return filepath.Join(configDir, "agent")
+}
+
+// NewRemote returns the remote agent at addr, authenticating with the
+// client.crt and client.key of dir and checking the agent certificate
+// against its ca.crt.
+func NewRemote(addr, dir string) (*Remote, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid agent address %q: %w", addr, err)
+	}
+
+	config, err := ClientTLSConfig(filepath.Join(dir, "client.crt"),
+		filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{Addr: addr, TLSConfig: config}, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificate found", caFile)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig returns the TLS configuration of an agent serving with
+// the given certificate and key, and only accepting clients whose
+// certificate is signed by the certificate authority of caFile.
+func ServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the agent certificate: %w", err)
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client certificate authority: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientTLSConfig returns the TLS configuration of a client authenticating
+// with the given certificate and key, and only trusting agents whose
+// certificate is signed by the certificate authority of caFile.
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the agent certificate authority: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// NewRemoteClient connects to a remote agent.  Unlike the local agent, it
+// is never spawned on demand.
+func NewRemoteClient(remote *Remote, ignoreVersion bool) (*Client, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", remote.Addr, remote.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the agent at %s: %w", remote.Addr, err)
+	}
+
+	c := &Client{
+		conn: conn,
+		enc:  msgpack.NewEncoder(conn),
+		dec:  msgpack.NewDecoder(conn),
+	}
+	if err := c.handshake(ignoreVersion); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ExecuteRemoteRPC runs a command in a remote agent, which opens the
+// repository of storeConfig on its side.
+func ExecuteRemoteRPC(ctx *appcontext.AppContext, remote *Remote, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int, error) {
+	client, err := NewRemoteClient(remote, cmd.GetFlags()&subcommands.IgnoreVersion != 0)
+	if err != nil {
+		return 1, err
+	}
+	return execute(ctx, client, name, cmd, storeConfig)
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a certificate named after cn and its key in dir, signed
+// by parent or self-signed if parent is nil.
+func writeCert(t *testing.T, dir, name, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return cert, key
+}
+
+func TestRemoteTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", "ca", nil, nil)
+	writeCert(t, dir, "server", "agent", ca, caKey)
+	writeCert(t, dir, "client", "alice", ca, caKey)
+
+	// a client certified by another authority
+	otherDir := t.TempDir()
+	other, otherKey := writeCert(t, otherDir, "ca", "other", nil, nil)
+	writeCert(t, otherDir, "client", "mallory", other, otherKey)
+	data, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(otherDir, "ca.crt"), data, 0600))
+
+	serverConfig, err := ServerTLSConfig(filepath.Join(dir, "server.crt"),
+		filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// handshake returns the client name seen by the server, and the
+	// error of the client.
+	handshake := func(config *tls.Config) (string, error) {
+		done := make(chan string, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				done <- ""
+				return
+			}
+			defer conn.Close()
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err != nil {
+				done <- ""
+				return
+			}
+			done <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+			// wait for the client to be done
+			conn.Read(make([]byte, 1))
+		}()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+		if err == nil {
+			// with TLS 1.3, the server verifies the client after
+			// the client is done with the handshake.
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, err = conn.Read(make([]byte, 1))
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				err = nil
+			}
+			conn.Close()
+		}
+		return <-done, err
+	}
+
+	remote, err := NewRemote(listener.Addr().String(), dir)
+	require.NoError(t, err)
+	name, err := handshake(remote.TLSConfig)
+	require.NoError(t, err)
+	require.Equal(t, "alice", name)
+
+	remote, err = NewRemote(listener.Addr().String(), otherDir)
+	require.NoError(t, err)
+	name, err = handshake(remote.TLSConfig)
+	require.Error(t, err)
+	require.Empty(t, name)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	name, err = handshake(&tls.Config{RootCAs: pool})
+	require.Error(t, err)
+	require.Empty(t, name)
+
+	_, err = NewRemote("localhost", dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/PlakarKorp/plakar/history"
+)
+
+func (ui *uiserver) historyList(w http.ResponseWriter, r *http.Request) error {
+	offset, err := QueryParamToUint32(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+	limit, err := QueryParamToUint32(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	filter := history.Filter{
+		Job:    r.URL.Query().Get("job"),
+		Type:   r.URL.Query().Get("type"),
+		Status: r.URL.Query().Get("status"),
+	}
+	if err := filter.Validate(); err != nil {
+		return parameterError("status", InvalidArgument, err)
+	}
+	if filter.Since, err = QueryParamToTime(r, "since"); err != nil {
+		return err
+	}
+	if filter.Before, err = QueryParamToTime(r, "before"); err != nil {
+		return err
+	}
+
+	db, err := history.Open(history.Dir(ui.ctx.CacheDir))
+	if err != nil {
+		return err
+	}
+
+	// one more to tell whether there is a next page
+	entries, err := db.List(&filter, int(offset), int(limit)+1)
+	if err != nil {
+		return err
+	}
+
+	items := ItemsPage[*history.Entry]{
+		HasNext: len(entries) > int(limit),
+		Items:   entries,
+	}
+	if items.HasNext {
+		items.Items = entries[:limit]
+	}
+	if items.Items == nil {
+		items.Items = []*history.Entry{}
+	}
+	return json.NewEncoder(w).Encode(items)
+}
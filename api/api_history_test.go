@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryList(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	ctx.CacheDir = t.TempDir()
+
+	db, err := history.Open(history.Dir(ctx.CacheDir))
+	require.NoError(t, err)
+	start := time.Now().Add(-time.Hour)
+	for i, status := range []reporting.TaskStatus{reporting.StatusOK, reporting.StatusFailed, reporting.StatusOK} {
+		require.NoError(t, db.Add(&history.Entry{
+			ID:        string(rune('a' + i)),
+			Job:       "nightly",
+			Type:      "backup",
+			Status:    status,
+			StartTime: start.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	ui := &uiserver{ctx: ctx}
+	get := func(query string) (int, ItemsPage[*history.Entry]) {
+		req := httptest.NewRequest("GET", "/api/history?"+query, nil)
+		w := httptest.NewRecorder()
+		JSONAPIView(ui.historyList).ServeHTTP(w, req)
+
+		var page ItemsPage[*history.Entry]
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		}
+		return w.Code, page
+	}
+
+	code, page := get("status=ok")
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, page.HasNext)
+	require.Len(t, page.Items, 2)
+	require.Equal(t, "c", page.Items[0].ID)
+
+	code, page = get("limit=1&offset=1")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, page.HasNext)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, "b", page.Items[0].ID)
+
+	code, page = get("job=weekly")
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, page.Items)
+	require.Empty(t, page.Items)
+
+	code, _ = get("status=done")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("since=yesterday")
+	require.Equal(t, http.StatusBadRequest, code)
+}
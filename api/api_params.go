@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/objects"
@@ -105,3 +106,13 @@ func QueryParamToSortKeys(r *http.Request, param, def string) ([]string, error)
 
 	return sortKeys, nil
 }
+
+// QueryParamToTime parses a date in any of the formats accepted on the
+// command line, such as RFC3339 or a duration in the past.
+func QueryParamToTime(r *http.Request, param string) (time.Time, error) {
+	t, err := utils.ParseTimeFlag(r.URL.Query().Get(param))
+	if err != nil {
+		return time.Time{}, parameterError(param, InvalidArgument, err)
+	}
+	return t, nil
+}
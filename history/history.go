@@ -0,0 +1,344 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/google/uuid"
+)
+
+// Retention is how long the runs are kept in the history.
+const Retention = 365 * 24 * time.Hour
+
+// monthLayout names the file holding the runs started in a month.
+const monthLayout = "2006-01"
+
+// Entry is a run of a task.
+type Entry struct {
+	ID         string               `json:"id"`
+	Job        string               `json:"job"`
+	Name       string               `json:"name"`
+	Type       string               `json:"type"`
+	Status     reporting.TaskStatus `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	Repository string               `json:"repository,omitempty"`
+	Snapshot   string               `json:"snapshot,omitempty"`
+	StartTime  time.Time            `json:"start_time"`
+	EndTime    time.Time            `json:"end_time"`
+
+	// RepositorySize and RepositorySnapshots are only known after the
+	// tasks that change them.
+	RepositorySize      int64 `json:"repository_size,omitempty"`
+	RepositorySnapshots int   `json:"repository_snapshots,omitempty"`
+
+	Backup      *reporting.ReportBackup      `json:"backup,omitempty"`
+	Check       *reporting.ReportCheck       `json:"check,omitempty"`
+	Sync        *reporting.ReportSync        `json:"sync,omitempty"`
+	Maintenance *reporting.ReportMaintenance `json:"maintenance,omitempty"`
+	Prune       *reporting.ReportPrune       `json:"prune,omitempty"`
+	Monitor     *reporting.ReportMonitor     `json:"monitor,omitempty"`
+}
+
+// NewEntry returns the entry of a task of job whose report is over.
+func NewEntry(report *reporting.Report, job string) *Entry {
+	entry := &Entry{
+		ID:          uuid.NewString(),
+		Job:         job,
+		Name:        report.Task.Name,
+		Type:        report.Task.Type,
+		Status:      report.Task.Status,
+		Error:       report.Task.ErrorMessage,
+		StartTime:   report.Task.StartTime,
+		EndTime:     report.Task.StartTime.Add(report.Task.Duration),
+		Backup:      report.Backup,
+		Check:       report.Check,
+		Sync:        report.Sync,
+		Maintenance: report.Maintenance,
+		Prune:       report.Prune,
+		Monitor:     report.Monitor,
+	}
+	if report.Repository != nil {
+		entry.Repository = report.Repository.Name
+		entry.RepositorySize = report.Repository.Size
+		entry.RepositorySnapshots = report.Repository.Snapshots
+	}
+	if report.Snapshot != nil {
+		entry.Snapshot = fmt.Sprintf("%x", report.Snapshot.Identifier)
+		if entry.Job == "" {
+			entry.Job = report.Snapshot.Job
+		}
+	}
+	return entry
+}
+
+// Filter selects entries.  Zero fields match everything, and the status is
+// one of ok, warning or failure.
+type Filter struct {
+	Job    string
+	Type   string
+	Status string
+	Since  time.Time
+	Before time.Time
+}
+
+func (f *Filter) Validate() error {
+	if f.Status == "" {
+		return nil
+	}
+	switch reporting.TaskStatus(strings.ToUpper(f.Status)) {
+	case reporting.StatusOK, reporting.StatusWarning, reporting.StatusFailed:
+		return nil
+	default:
+		return fmt.Errorf("invalid status %q; must be one of: ok, warning, failure", f.Status)
+	}
+}
+
+// Match tells whether the entry passes the filter.
+func (f *Filter) Match(entry *Entry) bool {
+	if f.Job != "" && entry.Job != f.Job {
+		return false
+	}
+	if f.Type != "" && entry.Type != f.Type {
+		return false
+	}
+	if f.Status != "" && reporting.TaskStatus(strings.ToUpper(f.Status)) != entry.Status {
+		return false
+	}
+	if !f.Since.IsZero() && entry.StartTime.Before(f.Since) {
+		return false
+	}
+	if !f.Before.IsZero() && !entry.StartTime.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// DB is the history of the tasks run on this host.  Runs are appended as
+// JSON lines to a file per month, which keeps concurrent writers from
+// stepping on each other and expiring them cheap.
+type DB struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Dir returns the directory of the history in cacheDir.
+func Dir(cacheDir string) string {
+	return filepath.Join(cacheDir, "history")
+}
+
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DB{dir: dir}, nil
+}
+
+// Add appends an entry to the history.
+func (db *DB) Add(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	filename := filepath.Join(db.dir, entry.StartTime.UTC().Format(monthLayout)+".jsonl")
+	fp, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// months returns the months of the history, most recent first.
+func (db *DB) months() ([]time.Time, error) {
+	dirents, err := os.ReadDir(db.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var months []time.Time
+	for _, dirent := range dirents {
+		name, ok := strings.CutSuffix(dirent.Name(), ".jsonl")
+		if !ok {
+			continue
+		}
+		month, err := time.Parse(monthLayout, name)
+		if err != nil {
+			continue
+		}
+		months = append(months, month)
+	}
+	slices.SortFunc(months, func(a, b time.Time) int { return b.Compare(a) })
+	return months, nil
+}
+
+func (db *DB) load(month time.Time) ([]*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(db.dir, month.Format(monthLayout)+".jsonl"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		entry := &Entry{}
+		// a line may be cut short if its writer crashed
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// List returns the entries matching filter, most recent first, skipping
+// offset of them and returning at most limit if it is not zero.
+func (db *DB) List(filter *Filter, offset, limit int) ([]*Entry, error) {
+	months, err := db.months()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*Entry
+	for _, month := range months {
+		if !filter.Since.IsZero() && !month.AddDate(0, 1, 0).After(filter.Since) {
+			break
+		}
+		if !filter.Before.IsZero() && !month.Before(filter.Before) {
+			continue
+		}
+
+		entries, err := db.load(month)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortStableFunc(entries, func(a, b *Entry) int { return b.StartTime.Compare(a.StartTime) })
+		for _, entry := range entries {
+			if !filter.Match(entry) {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			ret = append(ret, entry)
+			if limit != 0 && len(ret) == limit {
+				return ret, nil
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Cursor is a position in the history, the offset reached in the file of
+// each month.
+type Cursor map[string]int64
+
+// Read returns the entries added to the history after cursor, in the order
+// they were added, along with the cursor past them.  A line still being
+// written is left for the next read.
+func (db *DB) Read(cursor Cursor) ([]*Entry, Cursor, error) {
+	months, err := db.months()
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	var ret []*Entry
+	next := make(Cursor)
+	for _, month := range slices.Backward(months) {
+		name := month.Format(monthLayout)
+		offset := cursor[name]
+
+		fp, err := os.Open(filepath.Join(db.dir, name+".jsonl"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, cursor, err
+		}
+		data, err := readFrom(fp, offset)
+		fp.Close()
+		if err != nil {
+			return nil, cursor, err
+		}
+
+		for {
+			line, rest, ok := bytes.Cut(data, []byte("\n"))
+			if !ok {
+				break
+			}
+			data = rest
+			offset += int64(len(line)) + 1
+
+			entry := &Entry{}
+			if err := json.Unmarshal(line, entry); err != nil {
+				continue
+			}
+			ret = append(ret, entry)
+		}
+		next[name] = offset
+	}
+	return ret, next, nil
+}
+
+func readFrom(fp *os.File, offset int64) ([]byte, error) {
+	if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(fp)
+}
+
+// Prune removes the months of the history that are over for longer than
+// retention.
+func (db *DB) Prune(now time.Time, retention time.Duration) error {
+	months, err := db.months()
+	if err != nil {
+		return err
+	}
+	for _, month := range months {
+		if now.Sub(month.AddDate(0, 1, 0)) > retention {
+			if err := os.Remove(filepath.Join(db.dir, month.Format(monthLayout)+".jsonl")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Record adds the run of a task whose report is over to the history in
+// cacheDir, expiring the runs older than Retention.
+func Record(cacheDir string, report *reporting.Report, job string) error {
+	if report.Task == nil || report.Task.Status == "" {
+		return nil
+	}
+
+	db, err := Open(Dir(cacheDir))
+	if err != nil {
+		return err
+	}
+	if err := db.Add(NewEntry(report, job)); err != nil {
+		return err
+	}
+	return db.Prune(time.Now(), Retention)
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	db, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	start := time.Date(2026, 9, 30, 23, 0, 0, 0, time.UTC)
+	for i, status := range []reporting.TaskStatus{reporting.StatusOK, reporting.StatusFailed, reporting.StatusOK, reporting.StatusWarning} {
+		job := "nightly"
+		if i%2 == 1 {
+			job = "weekly"
+		}
+		require.NoError(t, db.Add(&Entry{
+			ID:        string(rune('a' + i)),
+			Job:       job,
+			Type:      "backup",
+			Status:    status,
+			StartTime: start.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	ids := func(entries []*Entry) (ret []string) {
+		for _, entry := range entries {
+			ret = append(ret, entry.ID)
+		}
+		return ret
+	}
+
+	entries, err := db.List(&Filter{}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"d", "c", "b", "a"}, ids(entries))
+
+	entries, err = db.List(&Filter{Job: "nightly"}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a"}, ids(entries))
+
+	entries, err = db.List(&Filter{Status: "ok"}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a"}, ids(entries))
+
+	entries, err = db.List(&Filter{Since: start.Add(time.Hour), Before: start.Add(3 * time.Hour)}, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b"}, ids(entries))
+
+	entries, err = db.List(&Filter{}, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b"}, ids(entries))
+
+	require.Error(t, (&Filter{Status: "done"}).Validate())
+}
+
+func TestHistoryTruncated(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	require.NoError(t, err)
+
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Add(&Entry{ID: "a", StartTime: start}))
+
+	fp, err := os.OpenFile(filepath.Join(dir, "2026-10.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = fp.WriteString(`{"id":"b","sta`)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	entries, err := db.List(&Filter{}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a", entries[0].ID)
+}
+
+func TestHistoryPrune(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Add(&Entry{ID: "a", StartTime: time.Date(2025, 8, 15, 0, 0, 0, 0, time.UTC)}))
+	require.NoError(t, db.Add(&Entry{ID: "b", StartTime: time.Date(2025, 10, 15, 0, 0, 0, 0, time.UTC)}))
+
+	require.NoError(t, db.Prune(time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), Retention))
+
+	entries, err := db.List(&Filter{}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "b", entries[0].ID)
+}
+
+func TestHistoryRead(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	require.NoError(t, err)
+
+	september := time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)
+	october := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Add(&Entry{ID: "a", StartTime: september}))
+	require.NoError(t, db.Add(&Entry{ID: "b", StartTime: october}))
+
+	entries, cursor, err := db.Read(nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "a", entries[0].ID)
+	require.Equal(t, "b", entries[1].ID)
+
+	entries, cursor, err = db.Read(cursor)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// a line being written is left for the next read
+	fp, err := os.OpenFile(filepath.Join(dir, "2026-10.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = fp.WriteString(`{"id":"c",`)
+	require.NoError(t, err)
+
+	entries, cursor, err = db.Read(cursor)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = fp.WriteString(`"start_time":"2026-10-02T00:00:00Z"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	entries, _, err = db.Read(cursor)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "c", entries[0].ID)
+}
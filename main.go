@@ -26,6 +26,7 @@ import (
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cookies"
 	"github.com/PlakarKorp/plakar/plugins"
+	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
 	"github.com/PlakarKorp/plakar/utils"
@@ -45,15 +46,18 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/digest"
 	_ "github.com/PlakarKorp/plakar/subcommands/dup"
 	_ "github.com/PlakarKorp/plakar/subcommands/help"
+	_ "github.com/PlakarKorp/plakar/subcommands/history"
 	_ "github.com/PlakarKorp/plakar/subcommands/info"
 	_ "github.com/PlakarKorp/plakar/subcommands/locate"
 	_ "github.com/PlakarKorp/plakar/subcommands/login"
 	_ "github.com/PlakarKorp/plakar/subcommands/ls"
 	_ "github.com/PlakarKorp/plakar/subcommands/maintenance"
+	_ "github.com/PlakarKorp/plakar/subcommands/monitor"
 	_ "github.com/PlakarKorp/plakar/subcommands/mount"
 	_ "github.com/PlakarKorp/plakar/subcommands/pkg"
 	_ "github.com/PlakarKorp/plakar/subcommands/prune"
 	_ "github.com/PlakarKorp/plakar/subcommands/ptar"
+	_ "github.com/PlakarKorp/plakar/subcommands/report"
 	_ "github.com/PlakarKorp/plakar/subcommands/restore"
 	_ "github.com/PlakarKorp/plakar/subcommands/rm"
 	_ "github.com/PlakarKorp/plakar/subcommands/scheduler"
@@ -119,6 +123,9 @@ func entryPoint() int {
 	var opt_quiet bool
 	var opt_keyfile string
 	var opt_agentless bool
+	var opt_detach bool
+	var opt_agentAddr string
+	var opt_jsonEvents bool
 	var opt_enableSecurityCheck bool
 	var opt_disableSecurityCheck bool
 
@@ -131,6 +138,9 @@ func entryPoint() int {
 	flag.BoolVar(&opt_quiet, "quiet", false, "no output except errors")
 	flag.StringVar(&opt_keyfile, "keyfile", "", "use passphrase from key file when prompted")
 	flag.BoolVar(&opt_agentless, "no-agent", false, "run without agent")
+	flag.BoolVar(&opt_detach, "detach", false, "run the command in the agent without waiting for it")
+	flag.StringVar(&opt_agentAddr, "agent", "", "run the command in the remote agent at host:port")
+	flag.BoolVar(&opt_jsonEvents, "json-events", false, "write the events of the command to stderr as JSON lines")
 	flag.BoolVar(&opt_enableSecurityCheck, "enable-security-check", false, "enable update check")
 	flag.BoolVar(&opt_disableSecurityCheck, "disable-security-check", false, "disable update check")
 
@@ -159,8 +169,21 @@ func entryPoint() int {
 	ctx.Client = "plakar/" + utils.GetVersion()
 	ctx.CWD = cwd
 
+	var remote *agent.Remote
+	if opt_agentAddr != "" {
+		if opt_agentless {
+			fmt.Fprintf(os.Stderr, "%s: -agent and -no-agent are mutually exclusive\n", flag.CommandLine.Name())
+			return 1
+		}
+		remote, err = agent.NewRemote(opt_agentAddr, agent.RemoteDir(ctx.ConfigDir))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+			return 1
+		}
+	}
+
 	_, envAgentLess := os.LookupEnv("PLAKAR_AGENTLESS")
-	if envAgentLess || runtime.GOOS == "windows" {
+	if remote == nil && (envAgentLess || runtime.GOOS == "windows") {
 		opt_agentless = true
 	}
 
@@ -319,6 +342,12 @@ func entryPoint() int {
 		return 1
 	}
 
+	if remote != nil && cmd.GetFlags()&subcommands.AgentSupport == 0 {
+		fmt.Fprintf(os.Stderr, "%s: -agent: the %s command does not run in an agent\n",
+			flag.CommandLine.Name(), strings.Join(name, " "))
+		return 1
+	}
+
 	// try to get the passphrase from env and store config so that it's
 	// available to subcommands like create.
 	passphrase, err := getPassphraseFromEnv(ctx, storeConfig)
@@ -339,6 +368,12 @@ func entryPoint() int {
 				flag.CommandLine.Name(), strings.Join(name, " "))
 		}
 		// store and repo can stay nil
+	} else if remote != nil && cmd.GetFlags()&subcommands.AgentSupport != 0 {
+		// the repository is opened by the remote agent, which has no
+		// way to prompt for the passphrase.
+		if ctx.KeyFromFile != "" {
+			storeConfig["passphrase"] = ctx.KeyFromFile
+		}
 	} else if cmd.GetFlags()&subcommands.BeforeRepositoryWithStorage != 0 {
 		repo, err = repository.Inexistent(ctx.GetInner(), storeConfig)
 		if err != nil {
@@ -405,13 +440,36 @@ func entryPoint() int {
 
 	var status int
 
+	// The events are the same whether the command runs here or in the
+	// agent, which forwards them.
+	stopEvents := func() {}
+	if opt_jsonEvents {
+		stopEvents = reporting.PrintEvents(ctx.Events(), os.Stderr)
+	}
+
 	runWithoutAgent := opt_agentless || cmd.GetFlags()&subcommands.AgentSupport == 0
-	if runWithoutAgent {
+	if runWithoutAgent && opt_detach {
+		status, err = 1, fmt.Errorf("-detach requires the command to run in the agent")
+	} else if runWithoutAgent {
 		status, err = task.RunCommand(ctx, cmd, repo, "@agentless")
+	} else if opt_detach {
+		var job int64
+		job, status, err = agent.DetachRPC(ctx, remote, name, cmd, storeConfig)
+		if job != 0 {
+			attach := "plakar agent attach"
+			if remote != nil {
+				attach = "plakar -agent " + remote.Addr + " agent attach"
+			}
+			fmt.Fprintf(os.Stderr, "%s: job %d detached, use \"%s %d\" to follow it\n",
+				flag.CommandLine.Name(), job, attach, job)
+		}
+	} else if remote != nil {
+		status, err = agent.ExecuteRemoteRPC(ctx, remote, name, cmd, storeConfig)
 	} else {
 		status, err = agent.ExecuteRPC(ctx, name, cmd, storeConfig)
 	}
 
+	stopEvents()
 	t1 := time.Since(t0)
 
 	if err != nil {
@@ -0,0 +1,324 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the buckets of the
+// task durations histogram.
+var DurationBuckets = []float64{1, 10, 60, 300, 900, 1800, 3600, 7200, 21600, 43200}
+
+var (
+	taskRuns = prometheus.NewDesc("plakar_task_runs_total",
+		"Number of runs of a task, by status.",
+		[]string{"type", "job", "status"}, nil)
+	taskLastRun = prometheus.NewDesc("plakar_task_last_run_timestamp_seconds",
+		"Time the last run of a task ended.",
+		[]string{"type", "job"}, nil)
+	taskLastSuccess = prometheus.NewDesc("plakar_task_last_success_timestamp_seconds",
+		"Time the last successful run of a task ended, warnings included.",
+		[]string{"type", "job"}, nil)
+	taskLastRunSuccess = prometheus.NewDesc("plakar_task_last_run_success",
+		"Whether the last run of a task succeeded, warnings included.",
+		[]string{"type", "job"}, nil)
+	taskDuration = prometheus.NewDesc("plakar_task_duration_seconds",
+		"Duration of the runs of a task.",
+		[]string{"type", "job"}, nil)
+
+	backupBytesScanned = prometheus.NewDesc("plakar_backup_bytes_scanned_total",
+		"Bytes scanned by the backups of a job.",
+		[]string{"job"}, nil)
+	backupBytesWritten = prometheus.NewDesc("plakar_backup_bytes_written_total",
+		"Bytes written to the repository by the backups of a job.",
+		[]string{"job"}, nil)
+	backupDedupRatio = prometheus.NewDesc("plakar_backup_dedup_ratio",
+		"Bytes scanned per byte written by the last successful backup of a job.",
+		[]string{"job"}, nil)
+	checkFailures = prometheus.NewDesc("plakar_check_failures",
+		"Resources found missing or corrupted by the last check of a job.",
+		[]string{"job"}, nil)
+
+	repositorySize = prometheus.NewDesc("plakar_repository_size_bytes",
+		"Size of a repository, as last computed after a task that changed it.",
+		[]string{"repository"}, nil)
+	repositorySnapshots = prometheus.NewDesc("plakar_repository_snapshots",
+		"Snapshots in a repository, as last counted after a task that changed it.",
+		[]string{"repository"}, nil)
+
+	schedulerTasks = prometheus.NewDesc("plakar_scheduler_tasks",
+		"Tasks of the scheduler, by state.",
+		[]string{"state"}, nil)
+)
+
+type taskKey struct {
+	typ string
+	job string
+}
+
+type taskStats struct {
+	runs        map[reporting.TaskStatus]uint64
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastOK      bool
+
+	durationCount   uint64
+	durationSum     float64
+	durationBuckets map[float64]uint64
+
+	bytesScanned uint64
+	bytesWritten uint64
+	dedupRatio   float64
+	failures     uint64
+}
+
+type repositoryStats struct {
+	updated   time.Time
+	size      int64
+	snapshots int
+}
+
+// HistoryCollector exposes the metrics of the runs recorded in the history,
+// only reading the ones added since the previous scrape.
+type HistoryCollector struct {
+	db *history.DB
+
+	mu           sync.Mutex
+	cursor       history.Cursor
+	tasks        map[taskKey]*taskStats
+	repositories map[string]*repositoryStats
+}
+
+func NewHistoryCollector(db *history.DB) *HistoryCollector {
+	return &HistoryCollector{
+		db:           db,
+		cursor:       make(history.Cursor),
+		tasks:        make(map[taskKey]*taskStats),
+		repositories: make(map[string]*repositoryStats),
+	}
+}
+
+func (c *HistoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- taskRuns
+	ch <- taskLastRun
+	ch <- taskLastSuccess
+	ch <- taskLastRunSuccess
+	ch <- taskDuration
+	ch <- backupBytesScanned
+	ch <- backupBytesWritten
+	ch <- backupDedupRatio
+	ch <- checkFailures
+	ch <- repositorySize
+	ch <- repositorySnapshots
+}
+
+func (c *HistoryCollector) add(entry *history.Entry) {
+	key := taskKey{typ: entry.Type, job: entry.Job}
+	stats, ok := c.tasks[key]
+	if !ok {
+		stats = &taskStats{
+			runs:            make(map[reporting.TaskStatus]uint64),
+			durationBuckets: make(map[float64]uint64),
+		}
+		for _, bound := range DurationBuckets {
+			stats.durationBuckets[bound] = 0
+		}
+		c.tasks[key] = stats
+	}
+
+	success := entry.Status == reporting.StatusOK || entry.Status == reporting.StatusWarning
+	stats.runs[entry.Status]++
+	if !entry.EndTime.Before(stats.lastRun) {
+		stats.lastRun = entry.EndTime
+		stats.lastOK = success
+		if entry.Backup != nil && success {
+			stats.dedupRatio = entry.Backup.DedupRatio
+		}
+		if entry.Check != nil {
+			stats.failures = entry.Check.FailureCount
+		}
+	}
+	if success && entry.EndTime.After(stats.lastSuccess) {
+		stats.lastSuccess = entry.EndTime
+	}
+
+	duration := entry.EndTime.Sub(entry.StartTime).Seconds()
+	stats.durationCount++
+	stats.durationSum += duration
+	for _, bound := range DurationBuckets {
+		if duration <= bound {
+			stats.durationBuckets[bound]++
+		}
+	}
+
+	if entry.Backup != nil {
+		stats.bytesScanned += entry.Backup.BytesScanned
+		stats.bytesWritten += entry.Backup.BytesWritten
+	}
+
+	if entry.Repository != "" && entry.RepositorySize != 0 {
+		repo, ok := c.repositories[entry.Repository]
+		if !ok {
+			repo = &repositoryStats{}
+			c.repositories[entry.Repository] = repo
+		}
+		if !entry.EndTime.Before(repo.updated) {
+			repo.updated = entry.EndTime
+			repo.size = entry.RepositorySize
+			repo.snapshots = entry.RepositorySnapshots
+		}
+	}
+}
+
+func (c *HistoryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, cursor, err := c.db.Read(c.cursor)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(taskRuns, err)
+		return
+	}
+	c.cursor = cursor
+	for _, entry := range entries {
+		c.add(entry)
+	}
+
+	timestamp := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+
+	for key, stats := range c.tasks {
+		for status, n := range stats.runs {
+			ch <- prometheus.MustNewConstMetric(taskRuns, prometheus.CounterValue,
+				float64(n), key.typ, key.job, strings.ToLower(string(status)))
+		}
+		ch <- prometheus.MustNewConstMetric(taskLastRun, prometheus.GaugeValue,
+			timestamp(stats.lastRun), key.typ, key.job)
+		ch <- prometheus.MustNewConstMetric(taskLastSuccess, prometheus.GaugeValue,
+			timestamp(stats.lastSuccess), key.typ, key.job)
+		lastOK := 0.0
+		if stats.lastOK {
+			lastOK = 1
+		}
+		ch <- prometheus.MustNewConstMetric(taskLastRunSuccess, prometheus.GaugeValue,
+			lastOK, key.typ, key.job)
+		ch <- prometheus.MustNewConstHistogram(taskDuration,
+			stats.durationCount, stats.durationSum, maps.Clone(stats.durationBuckets), key.typ, key.job)
+
+		switch key.typ {
+		case "backup":
+			ch <- prometheus.MustNewConstMetric(backupBytesScanned, prometheus.CounterValue,
+				float64(stats.bytesScanned), key.job)
+			ch <- prometheus.MustNewConstMetric(backupBytesWritten, prometheus.CounterValue,
+				float64(stats.bytesWritten), key.job)
+			ch <- prometheus.MustNewConstMetric(backupDedupRatio, prometheus.GaugeValue,
+				stats.dedupRatio, key.job)
+		case "check":
+			ch <- prometheus.MustNewConstMetric(checkFailures, prometheus.GaugeValue,
+				float64(stats.failures), key.job)
+		}
+	}
+
+	for name, repo := range c.repositories {
+		ch <- prometheus.MustNewConstMetric(repositorySize, prometheus.GaugeValue,
+			float64(repo.size), name)
+		ch <- prometheus.MustNewConstMetric(repositorySnapshots, prometheus.GaugeValue,
+			float64(repo.snapshots), name)
+	}
+}
+
+// SchedulerCollector exposes the state of the tasks of a scheduler.
+type SchedulerCollector struct {
+	tasks func() []scheduler.TaskStatus
+}
+
+// NewSchedulerCollector returns a collector of the tasks returned by
+// tasks, which returns nil when no scheduler is running.
+func NewSchedulerCollector(tasks func() []scheduler.TaskStatus) *SchedulerCollector {
+	return &SchedulerCollector{tasks: tasks}
+}
+
+func (c *SchedulerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- schedulerTasks
+}
+
+func (c *SchedulerCollector) Collect(ch chan<- prometheus.Metric) {
+	states := map[scheduler.TaskState]int{
+		scheduler.TaskIdle:    0,
+		scheduler.TaskQueued:  0,
+		scheduler.TaskRunning: 0,
+		scheduler.TaskPaused:  0,
+	}
+	for _, task := range c.tasks() {
+		states[task.State]++
+	}
+	for state, n := range states {
+		ch <- prometheus.MustNewConstMetric(schedulerTasks, prometheus.GaugeValue,
+			float64(n), string(state))
+	}
+}
+
+// Handler returns the handler of the /metrics endpoint exposing the
+// metrics of collectors.
+func Handler(collectors ...prometheus.Collector) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	for _, collector := range collectors {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
+
+// ListenAndServe serves the metrics of collectors at /metrics on addr
+// until ctx is done.
+func ListenAndServe(ctx context.Context, addr string, collectors ...prometheus.Collector) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, listener, collectors...)
+}
+
+// Serve serves the metrics of collectors at /metrics on listener until ctx
+// is done, and closes it.
+func Serve(ctx context.Context, listener net.Listener, collectors ...prometheus.Collector) error {
+	handler, err := Handler(collectors...)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handler)
+	server := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, collector *HistoryCollector, tasks []scheduler.TaskStatus) string {
+	handler, err := Handler(collector, NewSchedulerCollector(func() []scheduler.TaskStatus { return tasks }))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, w.Code)
+
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHistoryCollector(t *testing.T) {
+	db, err := history.Open(t.TempDir())
+	require.NoError(t, err)
+
+	start := time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Add(&history.Entry{
+		Job:                 "nightly",
+		Type:                "backup",
+		Status:              reporting.StatusOK,
+		Repository:          "/var/backups",
+		RepositorySize:      4096,
+		RepositorySnapshots: 3,
+		StartTime:           start,
+		EndTime:             start.Add(30 * time.Second),
+		Backup:              &reporting.ReportBackup{BytesScanned: 300, BytesWritten: 100, DedupRatio: 3},
+	}))
+
+	collector := NewHistoryCollector(db)
+	tasks := []scheduler.TaskStatus{{State: scheduler.TaskIdle}, {State: scheduler.TaskRunning}}
+	body := scrape(t, collector, tasks)
+	require.Contains(t, body, `plakar_task_runs_total{job="nightly",status="ok",type="backup"} 1`)
+	require.Contains(t, body, `plakar_task_last_success_timestamp_seconds{job="nightly",type="backup"} 1.79211603e+09`)
+	require.Contains(t, body, `plakar_task_last_run_success{job="nightly",type="backup"} 1`)
+	require.Contains(t, body, `plakar_task_duration_seconds_bucket{job="nightly",type="backup",le="60"} 1`)
+	require.Contains(t, body, `plakar_task_duration_seconds_bucket{job="nightly",type="backup",le="10"} 0`)
+	require.Contains(t, body, `plakar_backup_bytes_written_total{job="nightly"} 100`)
+	require.Contains(t, body, `plakar_backup_dedup_ratio{job="nightly"} 3`)
+	require.Contains(t, body, `plakar_repository_size_bytes{repository="/var/backups"} 4096`)
+	require.Contains(t, body, `plakar_repository_snapshots{repository="/var/backups"} 3`)
+	require.Contains(t, body, `plakar_scheduler_tasks{state="running"} 1`)
+	require.Contains(t, body, `plakar_scheduler_tasks{state="paused"} 0`)
+
+	// only the runs added since are read on the next scrape
+	start = start.Add(24 * time.Hour)
+	require.NoError(t, db.Add(&history.Entry{
+		Job:       "nightly",
+		Type:      "backup",
+		Status:    reporting.StatusFailed,
+		StartTime: start,
+		EndTime:   start.Add(time.Second),
+		Backup:    &reporting.ReportBackup{BytesScanned: 10},
+	}))
+	require.NoError(t, db.Add(&history.Entry{
+		Job:       "nightly",
+		Type:      "check",
+		Status:    reporting.StatusFailed,
+		StartTime: start,
+		EndTime:   start.Add(time.Second),
+		Check:     &reporting.ReportCheck{FailureCount: 2},
+	}))
+
+	body = scrape(t, collector, nil)
+	require.Contains(t, body, `plakar_task_runs_total{job="nightly",status="ok",type="backup"} 1`)
+	require.Contains(t, body, `plakar_task_runs_total{job="nightly",status="failure",type="backup"} 1`)
+	require.Contains(t, body, `plakar_task_last_success_timestamp_seconds{job="nightly",type="backup"} 1.79211603e+09`)
+	require.Contains(t, body, `plakar_task_last_run_success{job="nightly",type="backup"} 0`)
+	require.Contains(t, body, `plakar_backup_bytes_scanned_total{job="nightly"} 310`)
+	require.Contains(t, body, `plakar_backup_dedup_ratio{job="nightly"} 3`)
+	require.Contains(t, body, `plakar_check_failures{job="nightly"} 2`)
+	require.Contains(t, body, `plakar_scheduler_tasks{state="running"} 0`)
+}
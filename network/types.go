@@ -1,10 +1,20 @@
 package network
 
 import (
+	"time"
+
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/google/uuid"
 )
 
+// The versions of the protocol between the clients and plakar server.
+// Version 1 carries the objects inside JSON bodies, and version 2 streams
+// them under "/v2/", with the errors as status codes.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+)
+
 type Request struct {
 	Uuid    uuid.UUID
 	Type    string
@@ -27,6 +37,10 @@ type ReqOpen struct {
 type ResOpen struct {
 	Configuration []byte
 	Err           string
+
+	// Protocols are the versions of the protocol spoken by the server,
+	// missing from the servers only speaking ProtocolV1.
+	Protocols []int `json:",omitempty"`
 }
 
 // states
@@ -137,3 +151,25 @@ type ReqDeleteLock struct {
 type ResDeleteLock struct {
 	Err string
 }
+
+// usage
+type ReqGetUsage struct{}
+type ResGetUsage struct {
+	Size         int64
+	Packfiles    int64
+	MaxSize      int64
+	MaxPackfiles int64
+	Clients      []ClientUsage
+	Err          string
+}
+
+// ClientUsage is what a client wrote since its usage was last reset, or
+// it first wrote.
+type ClientUsage struct {
+	Name         string
+	Size         int64
+	Packfiles    int64
+	MaxSize      int64
+	MaxPackfiles int64
+	Since        time.Time
+}
@@ -0,0 +1,265 @@
+package reporting
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+)
+
+// MaxReportErrors caps the number of errors and failures listed in a
+// report, their count remaining exact.
+const MaxReportErrors = 100
+
+// Collector gathers the statistics of a task from the events it emits.
+type Collector struct {
+	start time.Time
+
+	mu           sync.Mutex
+	snapshots    int
+	files        uint64
+	directories  uint64
+	objects      uint64
+	chunks       uint64
+	size         uint64
+	errorCount   uint64
+	errors       []ReportError
+	failureCount uint64
+	failures     []ReportCheckFailure
+}
+
+// NewCollector starts collecting the events of receiver until it is
+// closed.
+func NewCollector(receiver *events.Receiver) *Collector {
+	c := &Collector{start: time.Now()}
+	ch := receiver.Listen()
+	go func() {
+		for event := range ch {
+			c.handle(event)
+		}
+	}()
+	return c
+}
+
+func (c *Collector) handle(event interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch event := event.(type) {
+	case events.Done:
+		c.snapshots++
+
+	case events.FileOK:
+		c.files++
+		c.size += uint64(event.Size)
+	case events.DirectoryOK:
+		c.directories++
+	case events.ObjectOK:
+		c.objects++
+	case events.ChunkOK:
+		c.chunks++
+
+	case events.PathError:
+		c.addError(event.Pathname, event.Message)
+	case events.DirectoryError:
+		c.addError(event.Pathname, event.Message)
+	case events.FileError:
+		c.addError(event.Pathname, event.Message)
+
+	case events.DirectoryMissing:
+		c.addFailure("directory", "missing", event.Pathname)
+	case events.FileMissing:
+		c.addFailure("file", "missing", event.Pathname)
+	case events.ObjectMissing:
+		c.addFailure("object", "missing", fmt.Sprintf("%x", event.MAC))
+	case events.ChunkMissing:
+		c.addFailure("chunk", "missing", fmt.Sprintf("%x", event.MAC))
+
+	case events.DirectoryCorrupted:
+		c.addFailure("directory", "corrupted", event.Pathname)
+	case events.FileCorrupted:
+		c.addFailure("file", "corrupted", event.Pathname)
+	case events.ObjectCorrupted:
+		c.addFailure("object", "corrupted", fmt.Sprintf("%x", event.MAC))
+	case events.ChunkCorrupted:
+		c.addFailure("chunk", "corrupted", fmt.Sprintf("%x", event.MAC))
+	}
+}
+
+func (c *Collector) addError(path, message string) {
+	c.errorCount++
+	if len(c.errors) < MaxReportErrors {
+		c.errors = append(c.errors, ReportError{Path: path, Message: message})
+	}
+}
+
+func (c *Collector) addFailure(typ, status, resource string) {
+	c.failureCount++
+	if len(c.failures) < MaxReportErrors {
+		c.failures = append(c.failures, ReportCheckFailure{Type: typ, Status: status, Resource: resource})
+	}
+}
+
+// throughput returns the rate of size bytes over d, in bytes per second.
+func throughput(size uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / d.Seconds()
+}
+
+// Progress returns the files, directories and bytes of the files processed
+// so far, along with the errors encountered.
+func (c *Collector) Progress() (files, directories, size, errorCount uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.files, c.directories, c.size, c.errorCount
+}
+
+// Backup returns the backup statistics collected so far.
+func (c *Collector) Backup() *ReportBackup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &ReportBackup{
+		Files:        c.files,
+		Directories:  c.directories,
+		BytesScanned: c.size,
+		Throughput:   throughput(c.size, time.Since(c.start)),
+		ErrorCount:   c.errorCount,
+		Errors:       c.errors,
+	}
+}
+
+// Check returns the check statistics collected so far.
+func (c *Collector) Check() *ReportCheck {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &ReportCheck{
+		Snapshots:    c.snapshots,
+		Files:        c.files,
+		Directories:  c.directories,
+		Objects:      c.objects,
+		Chunks:       c.chunks,
+		FailureCount: c.failureCount,
+		Failures:     c.failures,
+		ErrorCount:   c.errorCount,
+		Errors:       c.errors,
+	}
+}
+
+// Sync returns the sync statistics collected so far.
+func (c *Collector) Sync() *ReportSync {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &ReportSync{
+		Files:        c.files,
+		BytesScanned: c.size,
+		Throughput:   throughput(c.size, time.Since(c.start)),
+		ErrorCount:   c.errorCount,
+		Errors:       c.errors,
+	}
+}
+
+// summarize totals the files, directories and size of the sources of a
+// snapshot.
+func summarize(hdr *header.Header) (files, directories, size uint64) {
+	for i := range hdr.Sources {
+		summary := &hdr.Sources[i].Summary
+		files += summary.Directory.Files + summary.Below.Files
+		directories += summary.Directory.Directories + summary.Below.Directories
+		size += summary.Directory.Size + summary.Below.Size
+	}
+	return files, directories, size
+}
+
+// WithBackup records the statistics of a backup having written
+// bytesWritten bytes.  The totals of the snapshot, if any, take precedence
+// over the collected ones which may include those of a post-backup check.
+func (report *Report) WithBackup(collector *Collector, bytesWritten uint64) {
+	backup := collector.Backup()
+	if report.Snapshot != nil {
+		backup.Files, backup.Directories, backup.BytesScanned = summarize(&report.Snapshot.Header)
+		backup.Throughput = throughput(backup.BytesScanned, report.Snapshot.Duration)
+		backup.Delta = report.delta()
+	}
+	backup.BytesWritten = bytesWritten
+	if bytesWritten != 0 {
+		backup.DedupRatio = float64(backup.BytesScanned) / float64(bytesWritten)
+	}
+	report.Backup = backup
+}
+
+// delta compares the snapshot of the report with the previous one of the
+// same job, returning nil if there is none.
+func (report *Report) delta() *ReportDelta {
+	if report.repo == nil {
+		return nil
+	}
+	hdr := &report.Snapshot.Header
+
+	opts := locate.NewDefaultLocateOptions(locate.WithJob(hdr.Job), locate.WithBefore(hdr.Timestamp))
+	ids, err := locate.LocateSnapshotIDs(report.repo, opts)
+	if err != nil {
+		report.logger.Warn("failed to locate the previous snapshot: %s", err)
+		return nil
+	}
+	for _, id := range ids {
+		if id == hdr.Identifier {
+			continue
+		}
+		prev, _, err := snapshot.GetSnapshot(report.repo, id)
+		if err != nil {
+			report.logger.Warn("failed to load the previous snapshot: %s", err)
+			return nil
+		}
+
+		files, _, size := summarize(hdr)
+		prevFiles, _, prevSize := summarize(prev)
+		return &ReportDelta{
+			PreviousSnapshot:  fmt.Sprintf("%x", prev.Identifier),
+			PreviousTimestamp: prev.Timestamp,
+			Files:             int64(files) - int64(prevFiles),
+			Size:              int64(size) - int64(prevSize),
+		}
+	}
+	return nil
+}
+
+// WithCheck records the statistics of a check.
+func (report *Report) WithCheck(collector *Collector) {
+	report.Check = collector.Check()
+}
+
+// WithSync records the statistics of a sync of synchronized snapshots,
+// failed ones having been skipped.
+func (report *Report) WithSync(collector *Collector, synchronized, failed int) {
+	report.Sync = collector.Sync()
+	report.Sync.Synchronized = synchronized
+	report.Sync.Failed = failed
+}
+
+// WithMaintenance records what a maintenance marked and removed.
+func (report *Report) WithMaintenance(coloured, orphaned, blobs, packfiles int) {
+	report.Maintenance = &ReportMaintenance{
+		ColouredPackfiles: coloured,
+		OrphanedPackfiles: orphaned,
+		RemovedBlobs:      blobs,
+		RemovedPackfiles:  packfiles,
+	}
+}
+
+// WithMonitor records the anomalies found in the snapshots of jobs.
+func (report *Report) WithMonitor(jobs int, anomalies []ReportAnomaly) {
+	report.Monitor = &ReportMonitor{
+		Jobs:      jobs,
+		Anomalies: anomalies,
+	}
+}
@@ -0,0 +1,117 @@
+package reporting
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorBackup(t *testing.T) {
+	receiver := events.New()
+	collector := NewCollector(receiver)
+
+	var id objects.MAC
+	receiver.Send(events.StartEvent())
+	receiver.Send(events.DirectoryOKEvent(id, "/"))
+	receiver.Send(events.FileOKEvent(id, "/a", 10))
+	receiver.Send(events.FileOKEvent(id, "/b", 20))
+	receiver.Send(events.FileErrorEvent(id, "/c", "permission denied"))
+	receiver.Send(events.PathErrorEvent(id, "/d", "no such file or directory"))
+	// the collector handled everything before once it receives this one
+	receiver.Send(events.DoneEvent())
+	receiver.Close()
+
+	backup := collector.Backup()
+	require.Equal(t, uint64(2), backup.Files)
+	require.Equal(t, uint64(1), backup.Directories)
+	require.Equal(t, uint64(30), backup.BytesScanned)
+	require.Equal(t, uint64(2), backup.ErrorCount)
+	require.Equal(t, []ReportError{
+		{Path: "/c", Message: "permission denied"},
+		{Path: "/d", Message: "no such file or directory"},
+	}, backup.Errors)
+
+	report := &Report{}
+	report.WithBackup(collector, 10)
+	require.Equal(t, uint64(10), report.Backup.BytesWritten)
+	require.Equal(t, 3.0, report.Backup.DedupRatio)
+	require.Nil(t, report.Backup.Delta)
+}
+
+func TestCollectorCheck(t *testing.T) {
+	collector := &Collector{}
+
+	var id, mac objects.MAC
+	mac[0] = 0xab
+	collector.handle(events.FileOKEvent(id, "/a", 10))
+	collector.handle(events.ObjectOKEvent(id, mac))
+	collector.handle(events.ChunkOKEvent(id, mac))
+	collector.handle(events.FileMissingEvent(id, "/b"))
+	collector.handle(events.ObjectCorruptedEvent(id, mac))
+	collector.handle(events.DoneEvent())
+
+	report := &Report{}
+	report.WithCheck(collector)
+	require.Equal(t, 1, report.Check.Snapshots)
+	require.Equal(t, uint64(1), report.Check.Files)
+	require.Equal(t, uint64(1), report.Check.Objects)
+	require.Equal(t, uint64(1), report.Check.Chunks)
+	require.Equal(t, uint64(2), report.Check.FailureCount)
+	require.Equal(t, []ReportCheckFailure{
+		{Type: "file", Status: "missing", Resource: "/b"},
+		{Type: "object", Status: "corrupted", Resource: fmt.Sprintf("%x", mac)},
+	}, report.Check.Failures)
+}
+
+func TestCollectorErrorsCap(t *testing.T) {
+	collector := &Collector{}
+
+	var id objects.MAC
+	for i := range MaxReportErrors + 10 {
+		collector.handle(events.FileErrorEvent(id, fmt.Sprintf("/%d", i), "failed"))
+	}
+
+	backup := collector.Backup()
+	require.Equal(t, uint64(MaxReportErrors+10), backup.ErrorCount)
+	require.Len(t, backup.Errors, MaxReportErrors)
+}
+
+func TestReportDelta(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	first := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+	})
+	defer first.Close()
+	second := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+		ptesting.NewMockFile("subdir/bar.txt", 0644, "hello bar!"),
+	})
+	defer second.Close()
+
+	reporter := NewReporter(ctx)
+	defer reporter.StopAndWait()
+
+	report := reporter.NewReport()
+	defer report.Publish()
+	report.SetIgnore()
+	report.TaskStart("backup", "test")
+	report.WithRepositoryName("test")
+	report.WithRepository(repo)
+	report.WithSnapshot(second)
+	report.WithBackup(&Collector{}, 0)
+
+	require.NotNil(t, report.Backup.Delta)
+	require.Equal(t, fmt.Sprintf("%x", first.Header.Identifier), report.Backup.Delta.PreviousSnapshot)
+	require.Equal(t, int64(1), report.Backup.Delta.Files)
+	require.Equal(t, int64(10), report.Backup.Delta.Size)
+}
@@ -0,0 +1,205 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+const CONFIG_VERSION = "v1.0.0"
+
+// Config is the content of the reporting.yml file, which sets up the local
+// emitters reports are sent to.
+type Config struct {
+	Version  string          `yaml:"version"`
+	Emitters []EmitterConfig `yaml:"emitters"`
+}
+
+// EmitterConfig describes an emitter.  Only the fields of its type apply.
+type EmitterConfig struct {
+	Name   string `yaml:"name"`
+	Type   string `yaml:"type"`
+	Filter Filter `yaml:"filter"`
+
+	// file
+	Path string `yaml:"path,omitempty"`
+
+	// webhook
+	URL             string            `yaml:"url,omitempty"`
+	Method          string            `yaml:"method,omitempty"`
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	Secret          string            `yaml:"secret,omitempty"`
+	SignatureHeader string            `yaml:"signature_header,omitempty"`
+
+	// smtp
+	Address  string   `yaml:"address,omitempty"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	From     string   `yaml:"from,omitempty"`
+	To       []string `yaml:"to,omitempty"`
+	Subject  string   `yaml:"subject,omitempty"`
+
+	// webhook and smtp
+	Body string `yaml:"body,omitempty"`
+
+	// syslog, also using address
+	Network string `yaml:"network,omitempty"`
+	Tag     string `yaml:"tag,omitempty"`
+
+	// exec
+	Command string        `yaml:"command,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Filter selects the reports sent to an emitter.  An empty list matches
+// everything, and repositories may be glob patterns.
+type Filter struct {
+	Status       []string `yaml:"status,omitempty"`
+	Types        []string `yaml:"types,omitempty"`
+	Repositories []string `yaml:"repositories,omitempty"`
+}
+
+func (f *Filter) validate() error {
+	for _, status := range f.Status {
+		switch TaskStatus(strings.ToUpper(status)) {
+		case StatusOK, StatusWarning, StatusFailed:
+		default:
+			return fmt.Errorf("invalid status %q; must be one of: ok, warning, failure", status)
+		}
+	}
+	for _, pattern := range f.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match tells whether the report passes the filter.
+func (f *Filter) Match(report *Report) bool {
+	if len(f.Status) != 0 {
+		if report.Task == nil || !slices.ContainsFunc(f.Status, func(status string) bool {
+			return TaskStatus(strings.ToUpper(status)) == report.Task.Status
+		}) {
+			return false
+		}
+	}
+	if len(f.Types) != 0 {
+		if report.Task == nil || !slices.Contains(f.Types, report.Task.Type) {
+			return false
+		}
+	}
+	if len(f.Repositories) != 0 {
+		if report.Repository == nil || !slices.ContainsFunc(f.Repositories, func(pattern string) bool {
+			ok, _ := path.Match(pattern, report.Repository.Name)
+			return ok
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// filteredEmitter only passes the reports matching its filter along.
+type filteredEmitter struct {
+	Emitter
+	name   string
+	filter Filter
+}
+
+func (emitter *filteredEmitter) Emit(ctx context.Context, report *Report) error {
+	if !emitter.filter.Match(report) {
+		return nil
+	}
+	return emitter.Emitter.Emit(ctx, report)
+}
+
+// LoadConfigFile reads the reporting configuration, a missing file meaning
+// no local emitter.
+func LoadConfigFile(filename string) (*Config, error) {
+	cfg := &Config{Version: CONFIG_VERSION}
+
+	rd, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	defer rd.Close()
+
+	dec := yaml.NewDecoder(rd)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// ConfigPath returns the path of the reporting configuration in configDir.
+func ConfigPath(configDir string) string {
+	return filepath.Join(configDir, "reporting.yml")
+}
+
+// NewEmitters returns the emitters of the configuration.  Their names,
+// which the report spool tracks deliveries by, must be unique.
+func (cfg *Config) NewEmitters() ([]Emitter, error) {
+	names := make([]string, len(cfg.Emitters))
+	seen := make(map[string]bool)
+	for i, c := range cfg.Emitters {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("%s#%d", c.Type, i)
+		}
+		if seen[name] || name == ServiceEmitterName {
+			return nil, fmt.Errorf("emitter %s: duplicate name", name)
+		}
+		seen[name] = true
+		names[i] = name
+
+		if err := c.Filter.validate(); err != nil {
+			return nil, fmt.Errorf("emitter %s: %w", name, err)
+		}
+	}
+
+	var ret []Emitter
+	for i, c := range cfg.Emitters {
+		emitter, err := c.newEmitter()
+		if err != nil {
+			closeEmitters(ret)
+			return nil, fmt.Errorf("emitter %s: %w", names[i], err)
+		}
+		ret = append(ret, &filteredEmitter{
+			Emitter: emitter,
+			name:    names[i],
+			filter:  c.Filter,
+		})
+	}
+	return ret, nil
+}
+
+func (c *EmitterConfig) newEmitter() (Emitter, error) {
+	switch c.Type {
+	case "file":
+		return NewFileEmitter(c.Path)
+	case "webhook":
+		return NewWebhookEmitter(c.URL, c.Method, c.Headers, c.Body, c.Secret, c.SignatureHeader)
+	case "smtp":
+		return NewSMTPEmitter(c.Address, c.Username, c.Password, c.From, c.To, c.Subject, c.Body)
+	case "syslog":
+		return NewSyslogEmitter(c.Network, c.Address, c.Tag)
+	case "exec":
+		return NewExecEmitter(c.Command, c.Timeout)
+	case "":
+		return nil, fmt.Errorf("missing type")
+	default:
+		return nil, fmt.Errorf("unknown type %q; must be one of: file, webhook, smtp, syslog, exec", c.Type)
+	}
+}
@@ -0,0 +1,278 @@
+package reporting
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testReport(status TaskStatus) *Report {
+	return &Report{
+		Timestamp: time.Now(),
+		Task: &ReportTask{
+			Type:         "backup",
+			Name:         "nightly/backup",
+			Status:       status,
+			ErrorMessage: "no space left on device",
+		},
+		Repository: &ReportRepository{Name: "/var/backups"},
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := LoadConfigFile(ConfigPath(dir))
+	require.NoError(t, err)
+	require.Empty(t, cfg.Emitters)
+
+	require.NoError(t, os.WriteFile(ConfigPath(dir), []byte(`
+version: v1.0.0
+emitters:
+  - name: audit
+    type: file
+    path: `+filepath.Join(dir, "reports.jsonl")+`
+  - name: oncall
+    type: exec
+    command: "true"
+    timeout: 30s
+    filter:
+      status: [failure, warning]
+      types: [backup]
+      repositories: ["/var/*"]
+`), 0600))
+
+	cfg, err = LoadConfigFile(ConfigPath(dir))
+	require.NoError(t, err)
+	require.Len(t, cfg.Emitters, 2)
+	require.Equal(t, 30*time.Second, cfg.Emitters[1].Timeout)
+
+	emitters, err := cfg.NewEmitters()
+	require.NoError(t, err)
+	require.Len(t, emitters, 2)
+
+	cfg.Emitters[1].Name = "audit"
+	_, err = cfg.NewEmitters()
+	require.Error(t, err)
+	cfg.Emitters[1].Name = "oncall"
+
+	cfg.Emitters[1].Filter.Status = []string{"broken"}
+	_, err = cfg.NewEmitters()
+	require.Error(t, err)
+
+	cfg.Emitters[1].Type = "pager"
+	_, err = cfg.NewEmitters()
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(ConfigPath(dir), []byte("emitters:\n  - type: file\n    pth: /tmp\n"), 0600))
+	_, err = LoadConfigFile(ConfigPath(dir))
+	require.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	filter := Filter{
+		Status:       []string{"failure"},
+		Types:        []string{"backup", "check"},
+		Repositories: []string{"/var/*"},
+	}
+	require.True(t, filter.Match(testReport(StatusFailed)))
+	require.False(t, filter.Match(testReport(StatusOK)))
+
+	report := testReport(StatusFailed)
+	report.Task.Type = "restore"
+	require.False(t, filter.Match(report))
+
+	report = testReport(StatusFailed)
+	report.Repository.Name = "sftp://backup.example.org/plakar"
+	require.False(t, filter.Match(report))
+
+	require.True(t, (&Filter{}).Match(testReport(StatusOK)))
+}
+
+func TestFileEmitter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+	emitter, err := NewFileEmitter(path)
+	require.NoError(t, err)
+
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &report))
+	require.Equal(t, StatusFailed, report.Task.Status)
+}
+
+func TestWebhookEmitter(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Header, body}
+	}))
+	defer server.Close()
+
+	emitter, err := NewWebhookEmitter(server.URL, "", map[string]string{"Content-Type": "text/plain"},
+		"{{.Task.Name}} {{.Task.Status}}", "s3cr3t", "")
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+
+	req := <-requests
+	require.Equal(t, "nightly/backup FAILURE", string(req.body))
+	require.Equal(t, "text/plain", req.header.Get("Content-Type"))
+	require.Equal(t, Sign([]byte("s3cr3t"), req.body), req.header.Get(DefaultSignatureHeader))
+
+	// without a template the report is sent as JSON
+	emitter, err = NewWebhookEmitter(server.URL, "", nil, "", "", "")
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+
+	req = <-requests
+	var report Report
+	require.NoError(t, json.Unmarshal(req.body, &report))
+	require.Equal(t, StatusOK, report.Task.Status)
+	require.Empty(t, req.header.Get(DefaultSignatureHeader))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	emitter, err = NewWebhookEmitter(failing.URL, "", nil, "", "", "")
+	require.NoError(t, err)
+	require.Error(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+}
+
+// smtpServer is a bare SMTP server accepting one message, which it sends
+// on the returned channel.
+func smtpServer(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rd := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					messages <- data.String()
+					reply("250 OK")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestSMTPEmitter(t *testing.T) {
+	addr, messages := smtpServer(t)
+
+	emitter, err := NewSMTPEmitter(addr, "", "", "plakar@example.org", []string{"ops@example.org"}, "", "")
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+
+	msg := <-messages
+	require.Contains(t, msg, "Subject: plakar: backup nightly/backup: FAILURE\r\n")
+	require.Contains(t, msg, "To: ops@example.org\r\n")
+	require.Contains(t, msg, "no space left on device")
+	require.Contains(t, msg, "repository: /var/backups")
+
+	_, err = NewSMTPEmitter(addr, "", "", "plakar@example.org", nil, "", "")
+	require.Error(t, err)
+
+	// the subject can't add headers
+	addr, messages = smtpServer(t)
+	emitter, err = NewSMTPEmitter(addr, "", "", "plakar@example.org", []string{"ops@example.org"}, "{{ .Task.ErrorMessage }}", "")
+	require.NoError(t, err)
+	report := testReport(StatusFailed)
+	report.Task.ErrorMessage = "failed\r\nBcc: evil@example.org"
+	require.NoError(t, emitter.Emit(context.Background(), report))
+	require.Contains(t, <-messages, "Subject: failed  Bcc: evil@example.org\r\n")
+
+	// a server that never answers doesn't hold the delivery
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	emitter, err = NewSMTPEmitter(ln.Addr().String(), "", "", "plakar@example.org", []string{"ops@example.org"}, "", "")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.Error(t, emitter.Emit(ctx, testReport(StatusFailed)))
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestExecEmitter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+
+	out := filepath.Join(t.TempDir(), "report")
+	emitter, err := NewExecEmitter(`cat > "`+out+`" && test "$PLAKAR_REPORT_STATUS" = FAILURE`, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, "nightly/backup", report.Task.Name)
+
+	require.Error(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+}
@@ -0,0 +1,90 @@
+package reporting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+)
+
+// Event is the JSON form of the events emitted by the tasks.  The fields
+// not carried by an event are left out.
+type Event struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Snapshot  string    `json:"snapshot,omitempty"`
+	Pathname  string    `json:"pathname,omitempty"`
+	MAC       string    `json:"mac,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// NewEvent returns the JSON form of event, or nil if it is not one of the
+// kloset events.
+func NewEvent(event events.Event) *Event {
+	v := reflect.ValueOf(event)
+	if v.Kind() != reflect.Struct || v.Type().PkgPath() != reflect.TypeOf(events.Start{}).PkgPath() {
+		return nil
+	}
+
+	ret := &Event{Type: v.Type().Name()}
+	if f := v.FieldByName("Timestamp"); f.IsValid() {
+		ret.Timestamp, _ = f.Interface().(time.Time)
+	}
+	if f := v.FieldByName("SnapshotID"); f.IsValid() {
+		ret.Snapshot = fmt.Sprintf("%x", f.Interface())
+	}
+	if f := v.FieldByName("MAC"); f.IsValid() {
+		ret.MAC = fmt.Sprintf("%x", f.Interface())
+	}
+	if f := v.FieldByName("Pathname"); f.IsValid() {
+		ret.Pathname = f.String()
+	}
+	if f := v.FieldByName("Size"); f.IsValid() && f.CanInt() {
+		ret.Size = f.Int()
+	}
+	if f := v.FieldByName("Message"); f.IsValid() {
+		ret.Message = f.String()
+	}
+	return ret
+}
+
+type stopEvent struct{}
+
+// PrintEvents writes the events of receiver to w as JSON lines, leaving
+// out the ones about each object and chunk, until the returned function
+// is called.
+func PrintEvents(receiver *events.Receiver, w io.Writer) (stop func()) {
+	ch := receiver.Listen()
+	done := make(chan struct{})
+	go func() {
+		enc := json.NewEncoder(w)
+		printing := true
+		for event := range ch {
+			switch event.(type) {
+			case stopEvent:
+				if printing {
+					printing = false
+					close(done)
+				}
+			case events.Object, events.ObjectOK, events.Chunk, events.ChunkOK:
+			default:
+				if jsonEvent := NewEvent(event); printing && jsonEvent != nil {
+					enc.Encode(jsonEvent)
+				}
+			}
+		}
+		// the receiver was closed first
+		if printing {
+			close(done)
+		}
+	}()
+
+	return func() {
+		receiver.Send(stopEvent{})
+		<-done
+	}
+}
@@ -0,0 +1,62 @@
+package reporting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEvent(t *testing.T) {
+	id := objects.MAC{1, 2, 3}
+
+	fileOK := events.FileOKEvent(id, "/a", 10)
+	event := NewEvent(fileOK)
+	require.Equal(t, &Event{
+		Type:      "FileOK",
+		Timestamp: fileOK.Timestamp,
+		Snapshot:  fmt.Sprintf("%x", id),
+		Pathname:  "/a",
+		Size:      10,
+	}, event)
+
+	chunk := events.ChunkCorruptedEvent(id, objects.MAC{4})
+	event = NewEvent(chunk)
+	require.Equal(t, "ChunkCorrupted", event.Type)
+	require.Equal(t, fmt.Sprintf("%x", objects.MAC{4}), event.MAC)
+	require.Empty(t, event.Pathname)
+
+	require.Nil(t, NewEvent(struct{}{}))
+	require.Nil(t, NewEvent("FileOK"))
+}
+
+func TestPrintEvents(t *testing.T) {
+	receiver := events.New()
+	var buf bytes.Buffer
+	stop := PrintEvents(receiver, &buf)
+
+	var id objects.MAC
+	receiver.Send(events.StartEvent())
+	receiver.Send(events.FileOKEvent(id, "/a", 10))
+	receiver.Send(events.ChunkOKEvent(id, objects.MAC{1}))
+	receiver.Send(events.FileErrorEvent(id, "/b", "permission denied"))
+	receiver.Send(events.DoneEvent())
+	stop()
+
+	// the events sent once stopped are left out
+	receiver.Send(events.StartEvent())
+	receiver.Close()
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event Event
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		types = append(types, event.Type)
+	}
+	require.Equal(t, []string{"Start", "FileOK", "FileError", "Done"}, types)
+}
@@ -0,0 +1,81 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// ExecEmitter runs a shell command with the report as JSON on its standard
+// input.
+type ExecEmitter struct {
+	command string
+	timeout time.Duration
+}
+
+func NewExecEmitter(command string, timeout time.Duration) (*ExecEmitter, error) {
+	if command == "" {
+		return nil, fmt.Errorf("missing command")
+	}
+	return &ExecEmitter{command: command, timeout: timeout}, nil
+}
+
+func (emitter *ExecEmitter) env(report *Report) []string {
+	var env []string
+	if task := report.Task; task != nil {
+		env = append(env,
+			"PLAKAR_REPORT_TYPE="+task.Type,
+			"PLAKAR_REPORT_NAME="+task.Name,
+			"PLAKAR_REPORT_STATUS="+string(task.Status),
+			"PLAKAR_REPORT_ERROR="+task.ErrorMessage)
+	}
+	if report.Repository != nil {
+		env = append(env, "PLAKAR_REPORT_REPOSITORY="+report.Repository.Name)
+	}
+	return env
+}
+
+func (emitter *ExecEmitter) Emit(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %s", err)
+	}
+
+	if emitter.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, emitter.timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", emitter.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", emitter.command)
+	}
+	cmd.Env = append(os.Environ(), emitter.env(report)...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.WaitDelay = time.Second
+
+	out, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", emitter.timeout)
+	}
+	if err != nil {
+		if len(out) != 0 {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		}
+		return err
+	}
+	return nil
+}
+
+func (emitter *ExecEmitter) Close() error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileEmitter appends reports to a file, one JSON object per line.
+type FileEmitter struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileEmitter(path string) (*FileEmitter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	return &FileEmitter{path: path}, nil
+}
+
+func (emitter *FileEmitter) Emit(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %s", err)
+	}
+
+	emitter.mu.Lock()
+	defer emitter.mu.Unlock()
+
+	fp, err := os.OpenFile(emitter.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(append(data, '\n')); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func (emitter *FileEmitter) Close() error {
+	return nil
+}
@@ -43,3 +43,7 @@ func (emitter *HttpEmitter) Emit(ctx context.Context, report *Report) error {
 	}
 	return fmt.Errorf("request failed with status %s", res.Status)
 }
+
+func (emitter *HttpEmitter) Close() error {
+	return nil
+}
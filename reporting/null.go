@@ -8,3 +8,7 @@ type NullEmitter struct {
 func (emitter *NullEmitter) Emit(ctx context.Context, report *Report) error {
 	return nil
 }
+
+func (emitter *NullEmitter) Close() error {
+	return nil
+}
@@ -25,6 +25,11 @@ type ReportSnapshot struct {
 type ReportRepository struct {
 	Name    string                `json:"name"`
 	Storage storage.Configuration `json:"storage"`
+
+	// Size and Snapshots are only known after the tasks that change
+	// them.
+	Size      int64 `json:"size,omitempty"`
+	Snapshots int   `json:"snapshots,omitempty"`
 }
 
 type ReportTask struct {
@@ -35,6 +40,118 @@ type ReportTask struct {
 	Status       TaskStatus    `json:"status"`
 	ErrorCode    TaskErrorCode `json:"error_code"`
 	ErrorMessage string        `json:"error_message"`
+
+	// Attempts lists the runs of a task that is retried on failure.
+	Attempts []ReportAttempt `json:"attempts,omitempty"`
+}
+
+type ReportAttempt struct {
+	Attempt      int           `json:"attempt"`
+	StartTime    time.Time     `json:"start_time"`
+	Duration     time.Duration `json:"duration"`
+	Status       TaskStatus    `json:"status"`
+	ErrorMessage string        `json:"error_message"`
+}
+
+// ReportPrune lists what a prune kept and deleted, or would have in a dry
+// run.
+type ReportPrune struct {
+	DryRun    bool                  `json:"dry_run"`
+	Kept      int                   `json:"kept"`
+	Deleted   int                   `json:"deleted"`
+	Snapshots []ReportPruneSnapshot `json:"snapshots,omitempty"`
+}
+
+type ReportPruneSnapshot struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+}
+
+// ReportError is an error on a single path that did not fail the task.
+type ReportError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ReportDelta compares a snapshot with the previous one of the same job.
+type ReportDelta struct {
+	PreviousSnapshot  string    `json:"previous_snapshot"`
+	PreviousTimestamp time.Time `json:"previous_timestamp"`
+	Files             int64     `json:"files"`
+	Size              int64     `json:"size"`
+}
+
+// ReportBackup holds the statistics of a backup.  The throughput is in
+// bytes scanned per second and the dedup ratio is the number of bytes
+// scanned per byte written.
+type ReportBackup struct {
+	Files        uint64        `json:"files"`
+	Directories  uint64        `json:"directories"`
+	BytesScanned uint64        `json:"bytes_scanned"`
+	BytesWritten uint64        `json:"bytes_written"`
+	DedupRatio   float64       `json:"dedup_ratio"`
+	Throughput   float64       `json:"throughput"`
+	ErrorCount   uint64        `json:"error_count"`
+	Errors       []ReportError `json:"errors,omitempty"`
+	Delta        *ReportDelta  `json:"delta,omitempty"`
+}
+
+// ReportCheckFailure is a resource found missing or corrupted by a check,
+// designated by its path or, for objects and chunks, by its MAC.
+type ReportCheckFailure struct {
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Resource string `json:"resource"`
+}
+
+// ReportCheck holds the statistics of a check.
+type ReportCheck struct {
+	Snapshots    int                  `json:"snapshots"`
+	Files        uint64               `json:"files"`
+	Directories  uint64               `json:"directories"`
+	Objects      uint64               `json:"objects"`
+	Chunks       uint64               `json:"chunks"`
+	FailureCount uint64               `json:"failure_count"`
+	Failures     []ReportCheckFailure `json:"failures,omitempty"`
+	ErrorCount   uint64               `json:"error_count"`
+	Errors       []ReportError        `json:"errors,omitempty"`
+}
+
+// ReportSync holds the statistics of a sync.
+type ReportSync struct {
+	Synchronized int           `json:"synchronized"`
+	Failed       int           `json:"failed"`
+	Files        uint64        `json:"files"`
+	BytesScanned uint64        `json:"bytes_scanned"`
+	Throughput   float64       `json:"throughput"`
+	ErrorCount   uint64        `json:"error_count"`
+	Errors       []ReportError `json:"errors,omitempty"`
+}
+
+// ReportMaintenance holds what a maintenance marked and removed.
+type ReportMaintenance struct {
+	ColouredPackfiles int `json:"coloured_packfiles"`
+	OrphanedPackfiles int `json:"orphaned_packfiles"`
+	RemovedBlobs      int `json:"removed_blobs"`
+	RemovedPackfiles  int `json:"removed_packfiles"`
+}
+
+// ReportAnomaly is something unusual about the snapshots of a job, such as
+// none being taken for too long or one being much smaller than the
+// previous one.
+type ReportAnomaly struct {
+	Job      string `json:"job"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+}
+
+// ReportMonitor lists the anomalies found by a monitor.
+type ReportMonitor struct {
+	Jobs      int             `json:"jobs"`
+	Anomalies []ReportAnomaly `json:"anomalies,omitempty"`
 }
 
 type Report struct {
@@ -42,6 +159,13 @@ type Report struct {
 	Task       *ReportTask       `json:"report_task,omitempty"`
 	Repository *ReportRepository `json:"report_repository,omitempty"`
 	Snapshot   *ReportSnapshot   `json:"report_snapshot,omitempty"`
+	Prune      *ReportPrune      `json:"report_prune,omitempty"`
+
+	Backup      *ReportBackup      `json:"report_backup,omitempty"`
+	Check       *ReportCheck       `json:"report_check,omitempty"`
+	Sync        *ReportSync        `json:"report_sync,omitempty"`
+	Maintenance *ReportMaintenance `json:"report_maintenance,omitempty"`
+	Monitor     *ReportMonitor     `json:"report_monitor,omitempty"`
 
 	repo     *repository.Repository `json:"-"`
 	logger   *logging.Logger        `json:"-"`
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,18 +17,45 @@ import (
 
 const PLAKAR_API_URL = "https://api.plakar.io/v1/reporting/reports"
 
+// ServiceEmitterName is the name of the emitter of the alerting service.
+const ServiceEmitterName = "plakar"
+
 type Emitter interface {
 	Emit(ctx context.Context, report *Report) error
+
+	// Close releases the resources of the emitter once it is replaced
+	// or the reporter stops.
+	Close() error
 }
 
+// DeliveryTimeout bounds an attempt at sending a report to an emitter.
+const DeliveryTimeout = time.Minute
+
 type Reporter struct {
 	ctx             *appcontext.AppContext
 	reportCount     atomic.Int32
 	reports         chan *Report
 	stop            chan any
 	done            chan any
-	emitter         Emitter
+	spool           *Spool
+	wg              sync.WaitGroup
+	mu              sync.Mutex
+	workers         map[string]*worker
+	emittersMu      sync.Mutex
+	emitters        map[string]Emitter
 	emitter_timeout time.Time
+
+	// the emitters of reporting.yml, kept until it changes.
+	localEmitters []Emitter
+	localConfig   os.FileInfo
+}
+
+// worker delivers the spooled reports of an emitter, so that a slow or
+// unreachable one does not hold the others back.
+type worker struct {
+	name string
+	wake chan struct{}
+	stop chan struct{}
 }
 
 func NewReporter(ctx *appcontext.AppContext) *Reporter {
@@ -36,6 +64,16 @@ func NewReporter(ctx *appcontext.AppContext) *Reporter {
 		reports: make(chan *Report, 100),
 		stop:    make(chan any),
 		done:    make(chan any),
+		workers: make(map[string]*worker),
+	}
+
+	if ctx.CacheDir != "" {
+		spool, err := OpenSpool(SpoolDir(ctx.CacheDir))
+		if err != nil {
+			ctx.GetLogger().Warn("failed to open the report spool, reports will not be retried: %s", err)
+		} else {
+			r.spool = spool
+		}
 	}
 
 	go func() {
@@ -59,59 +97,294 @@ func NewReporter(ctx *appcontext.AppContext) *Reporter {
 			r.reportCount.Add(-1)
 		}
 		close(r.reports)
+
+		// give the workers a last chance to deliver them
+		r.mu.Lock()
+		for _, w := range r.workers {
+			close(w.stop)
+		}
+		r.mu.Unlock()
+		r.wg.Wait()
 		close(r.done)
 	}()
 
 	return r
 }
 
+// Process spools the report for its emitters and wakes their workers up,
+// or sends it right away if there is no spool.  The reports no emitter
+// wants are not spooled, and the spool expires the delivered reports as
+// it grows.
 func (reporter *Reporter) Process(report *Report) {
 	if report.ignore {
 		return
 	}
 
-	attempts := 3
-	backoffUnit := time.Minute
-	for i := range attempts {
-		err := reporter.getEmitter().Emit(reporter.ctx, report)
-		if err == nil {
-			return
+	emitters := reporter.getEmitters()
+	if reporter.spool == nil {
+		for _, emitter := range emitters {
+			reporter.emit(emitter, report)
+		}
+		return
+	}
+
+	var names []string
+	for name, emitter := range emitters {
+		if filtered, ok := emitter.(*filteredEmitter); ok && !filtered.filter.Match(report) {
+			continue
 		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return
+	}
+	if _, err := reporter.spool.Put(report, names); err != nil {
+		reporter.ctx.GetLogger().Error("%s", err)
+		return
+	}
+	if err := reporter.spool.Prune(SpoolRetention); err != nil {
+		reporter.ctx.GetLogger().Warn("failed to prune the report spool: %s", err)
+	}
+	reporter.startWorkers()
+	for _, name := range names {
+		reporter.wakeWorker(name)
+	}
+}
+
+func (reporter *Reporter) emit(emitter Emitter, report *Report) {
+	ctx, cancel := context.WithTimeout(reporter.ctx, DeliveryTimeout)
+	defer cancel()
+	if err := emitter.Emit(ctx, report); err != nil {
 		reporter.ctx.GetLogger().Warn("failed to emit report: %s", err)
-		time.Sleep(backoffUnit << i)
 	}
-	reporter.ctx.GetLogger().Error("failed to emit report after %d attempts", attempts)
+}
+
+// DeliverSpooled starts delivering the reports left in the spool by earlier
+// runs, rather than waiting for a new report to be processed.
+func (reporter *Reporter) DeliverSpooled() {
+	if reporter.spool == nil {
+		return
+	}
+	if err := reporter.spool.Prune(SpoolRetention); err != nil {
+		reporter.ctx.GetLogger().Warn("failed to prune the report spool: %s", err)
+	}
+	reporter.startWorkers()
+}
+
+// startWorkers starts a worker for each emitter that has none.
+func (reporter *Reporter) startWorkers() {
+	emitters := reporter.getEmitters()
+
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+
+	for name := range emitters {
+		if _, ok := reporter.workers[name]; ok {
+			continue
+		}
+		w := &worker{
+			name: name,
+			wake: make(chan struct{}, 1),
+			stop: make(chan struct{}),
+		}
+		reporter.workers[name] = w
+		reporter.wg.Add(1)
+		go reporter.work(w)
+	}
+}
+
+func (reporter *Reporter) wakeWorker(name string) {
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+
+	if w, ok := reporter.workers[name]; ok {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// work delivers the reports of an emitter as they are due, checking the
+// spool at least every minute for those queued by other processes, and
+// makes a last pass once stopped.
+func (reporter *Reporter) work(w *worker) {
+	defer reporter.wg.Done()
+
+	for {
+		next := reporter.deliver(w.name)
+
+		wait := time.Minute
+		if !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+		select {
+		case <-w.wake:
+		case <-time.After(wait):
+		case <-reporter.ctx.Done():
+			return
+		case <-w.stop:
+			reporter.deliver(w.name)
+			return
+		}
+	}
+}
+
+// deliver sends the due reports of an emitter, oldest first, and returns
+// when the next one is due.  It stops at the first failure, the emitter
+// being likely to fail the others as well.
+func (reporter *Reporter) deliver(name string) time.Time {
+	emitter, ok := reporter.getEmitters()[name]
+	if !ok {
+		return time.Time{}
+	}
+
+	deliveries, next, err := reporter.spool.due(name, time.Now())
+	if err != nil {
+		reporter.ctx.GetLogger().Warn("failed to read the report spool: %s", err)
+		return next
+	}
+
+	for _, d := range deliveries {
+		claimed, ok := reporter.spool.claim(d)
+		if !ok {
+			continue
+		}
+
+		report, err := reporter.spool.Load(d.ID)
+		if err != nil {
+			reporter.ctx.GetLogger().Warn("dropping report %s: %s", d.ID, err)
+			os.Remove(claimed)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(reporter.ctx, DeliveryTimeout)
+		err = emitter.Emit(ctx, report)
+		cancel()
+
+		if err := reporter.spool.release(claimed, d, err); err != nil {
+			reporter.ctx.GetLogger().Warn("failed to update the report spool: %s", err)
+		}
+		if err != nil {
+			if d.Attempts >= DeliveryAttempts {
+				reporter.ctx.GetLogger().Error("failed to emit report %s to %s after %d attempts: %s",
+					d.ID, name, d.Attempts, err)
+			} else {
+				reporter.ctx.GetLogger().Warn("failed to emit report %s to %s: %s", d.ID, name, err)
+			}
+			if next.IsZero() || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+			break
+		}
+	}
+	return next
 }
 
 func (reporter *Reporter) StopAndWait() {
 	close(reporter.stop)
 	<-reporter.done
+
+	reporter.emittersMu.Lock()
+	defer reporter.emittersMu.Unlock()
+	closeEmitters(reporter.localEmitters)
+	reporter.localEmitters = nil
 }
 
-func (reporter *Reporter) getEmitter() Emitter {
-	// Check if emitter should be reloaded
-	if reporter.emitter != nil && reporter.emitter_timeout.After(time.Now()) {
-		return reporter.emitter
+func closeEmitters(emitters []Emitter) {
+	for _, emitter := range emitters {
+		emitter.Close()
 	}
+}
 
-	// By default do nothing
-	reporter.emitter = &NullEmitter{}
+// getEmitters returns the local emitters of reporting.yml and the emitter
+// of the alerting service, if enabled, by name.
+func (reporter *Reporter) getEmitters() map[string]Emitter {
+	reporter.emittersMu.Lock()
+	defer reporter.emittersMu.Unlock()
+
+	// Check if emitters should be reloaded
+	if reporter.emitters != nil && reporter.emitter_timeout.After(time.Now()) {
+		return reporter.emitters
+	}
 	reporter.emitter_timeout = time.Now().Add(time.Minute)
 
+	emitters := make(map[string]Emitter)
+	for _, emitter := range reporter.getLocalEmitters() {
+		emitters[emitter.(*filteredEmitter).name] = emitter
+	}
+	if emitter, ok := reporter.getEmitter().(*HttpEmitter); ok {
+		emitters[ServiceEmitterName] = emitter
+	}
+	reporter.emitters = emitters
+	return reporter.emitters
+}
+
+// getLocalEmitters returns the emitters of reporting.yml, which are only
+// set up again when it changed.  The replaced ones are closed, failing the
+// deliveries they were busy with, which are retried.
+func (reporter *Reporter) getLocalEmitters() []Emitter {
+	if reporter.ctx.ConfigDir == "" {
+		return nil
+	}
+
+	path := ConfigPath(reporter.ctx.ConfigDir)
+	fi, err := os.Stat(path)
+	if err != nil {
+		fi = nil
+	}
+	if sameFile(fi, reporter.localConfig) {
+		return reporter.localEmitters
+	}
+
+	closeEmitters(reporter.localEmitters)
+	reporter.localEmitters = nil
+	reporter.localConfig = fi
+
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		reporter.ctx.GetLogger().Warn("failed to load reporting configuration: %v", err)
+		return nil
+	}
+	emitters, err := cfg.NewEmitters()
+	if err != nil {
+		reporter.ctx.GetLogger().Warn("failed to set up report emitters: %v", err)
+		return nil
+	}
+	reporter.localEmitters = emitters
+	return emitters
+}
+
+// sameFile tells whether a and b describe the same version of a file, or
+// are both missing.
+func sameFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+func (reporter *Reporter) getEmitter() Emitter {
+	// By default do nothing
+	emitter := &NullEmitter{}
+
 	// Check if user is logged
+	if reporter.ctx.GetCookies() == nil {
+		return emitter
+	}
 	token, _ := reporter.ctx.GetCookies().GetAuthToken()
 	if token == "" {
-		return reporter.emitter
+		return emitter
 	}
 
 	sc := services.NewServiceConnector(reporter.ctx, token)
 	enabled, err := sc.GetServiceStatus("alerting")
 	if err != nil {
 		reporter.ctx.GetLogger().Warn("failed to check alerting service: %v", err)
-		return reporter.emitter
+		return emitter
 	}
 	if !enabled {
-		return reporter.emitter
+		return emitter
 	}
 
 	// User is logged and alerting service is enabled
@@ -120,11 +393,10 @@ func (reporter *Reporter) getEmitter() Emitter {
 		url = PLAKAR_API_URL
 	}
 
-	reporter.emitter = &HttpEmitter{
+	return &HttpEmitter{
 		url:   url,
 		token: token,
 	}
-	return reporter.emitter
 }
 
 func (reporter *Reporter) NewReport() *Report {
@@ -165,6 +437,24 @@ func (report *Report) WithRepository(repository *repository.Repository) {
 	report.Repository.Storage = configuration
 }
 
+// WithRepositoryStats records the size of the repository and its number
+// of snapshots, once the task is over.
+func (report *Report) WithRepositoryStats() {
+	if err := report.repo.RebuildState(); err != nil {
+		report.logger.Warn("failed to rebuild the repository state: %s", err)
+		return
+	}
+	size, err := report.repo.StorageSize()
+	if err != nil {
+		report.logger.Warn("failed to compute the repository size: %s", err)
+		return
+	}
+	report.Repository.Size = size
+	for range report.repo.ListSnapshots() {
+		report.Repository.Snapshots++
+	}
+}
+
 func (report *Report) WithSnapshotID(snapshotId objects.MAC) {
 	snap, err := snapshot.Load(report.repo, snapshotId)
 	if err != nil {
@@ -184,6 +474,53 @@ func (report *Report) WithSnapshot(snapshot *snapshot.Snapshot) {
 	}
 }
 
+// NewAttempt returns the attempt number n at running a task, that started
+// at startTime and ended with err.
+func NewAttempt(n int, startTime time.Time, err error) ReportAttempt {
+	attempt := ReportAttempt{
+		Attempt:   n,
+		StartTime: startTime,
+		Duration:  time.Since(startTime),
+		Status:    StatusOK,
+	}
+	if err != nil {
+		attempt.Status = StatusFailed
+		attempt.ErrorMessage = err.Error()
+	}
+	return attempt
+}
+
+// WithAttempts records the previous attempts at running a task that is
+// retried.
+func (report *Report) WithAttempts(attempts []ReportAttempt) {
+	report.Task.Attempts = append(report.Task.Attempts, attempts...)
+}
+
+// TaskAttempt records an attempt at running the task that started at
+// startTime and ended with err.
+func (report *Report) TaskAttempt(startTime time.Time, err error) {
+	report.Task.Attempts = append(report.Task.Attempts, NewAttempt(len(report.Task.Attempts)+1, startTime, err))
+}
+
+// WithPruneSnapshot records the fate of a snapshot in a prune.
+func (report *Report) WithPruneSnapshot(dryRun bool, id objects.MAC, timestamp time.Time, action string, reason string) {
+	if report.Prune == nil {
+		report.Prune = &ReportPrune{DryRun: dryRun}
+	}
+	switch action {
+	case "keep":
+		report.Prune.Kept++
+	case "delete":
+		report.Prune.Deleted++
+	}
+	report.Prune.Snapshots = append(report.Prune.Snapshots, ReportPruneSnapshot{
+		ID:        fmt.Sprintf("%x", id),
+		Timestamp: timestamp,
+		Action:    action,
+		Reason:    reason,
+	})
+}
+
 func (report *Report) TaskDone() {
 	report.taskEnd(StatusOK, 0, "")
 }
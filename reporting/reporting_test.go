@@ -1,9 +1,12 @@
 package reporting
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEmit(t *testing.T) {
@@ -15,3 +18,26 @@ func TestEmit(t *testing.T) {
 	report.TaskDone()
 	reporter.StopAndWait()
 }
+
+func TestLocalEmitters(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	ctx.ConfigDir = t.TempDir()
+	reporter := &Reporter{ctx: ctx}
+
+	require.Empty(t, reporter.getLocalEmitters())
+
+	config := "emitters:\n  - name: audit\n    type: file\n    path: " + filepath.Join(t.TempDir(), "reports") + "\n"
+	require.NoError(t, os.WriteFile(ConfigPath(ctx.ConfigDir), []byte(config), 0600))
+	emitters := reporter.getLocalEmitters()
+	require.Len(t, emitters, 1)
+
+	// the emitters are kept until the configuration changes
+	require.Same(t, emitters[0], reporter.getLocalEmitters()[0])
+
+	config += "  - name: oncall\n    type: exec\n    command: \"true\"\n"
+	require.NoError(t, os.WriteFile(ConfigPath(ctx.ConfigDir), []byte(config), 0600))
+	require.Len(t, reporter.getLocalEmitters(), 2)
+
+	require.NoError(t, os.Remove(ConfigPath(ctx.ConfigDir)))
+	require.Empty(t, reporter.getLocalEmitters())
+}
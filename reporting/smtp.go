@@ -0,0 +1,161 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	DefaultSubject = "plakar: {{.Task.Type}} {{.Task.Name}}: {{.Task.Status}}"
+	DefaultBody    = `{{with .Task}}{{.Type}} {{.Name}} started at {{.StartTime}} ended with {{.Status}} after {{.Duration}}{{if .ErrorMessage}}: {{.ErrorMessage}}{{end}}
+{{end}}{{with .Repository}}repository: {{.Name}}
+{{end}}`
+)
+
+// SMTPEmitter mails reports.
+type SMTPEmitter struct {
+	address string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject *template.Template
+	body    *template.Template
+}
+
+func NewSMTPEmitter(address, username, password, from string, to []string, subject, body string) (*SMTPEmitter, error) {
+	if address == "" {
+		return nil, fmt.Errorf("missing address")
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("missing sender")
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("missing recipients")
+	}
+
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	subjectTmpl, err := newTemplate("subject", subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	if body == "" {
+		body = DefaultBody
+	}
+	bodyTmpl, err := newTemplate("body", body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPEmitter{
+		address: address,
+		auth:    auth,
+		from:    from,
+		to:      to,
+		subject: subjectTmpl,
+		body:    bodyTmpl,
+	}, nil
+}
+
+func (emitter *SMTPEmitter) Emit(ctx context.Context, report *Report) error {
+	subject, err := render(emitter.subject, report)
+	if err != nil {
+		return fmt.Errorf("failed to render subject: %s", err)
+	}
+	body, err := render(emitter.body, report)
+	if err != nil {
+		return fmt.Errorf("failed to render body: %s", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", emitter.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(emitter.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(string(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+
+	return sendMail(ctx, emitter.address, emitter.auth, emitter.from, emitter.to, msg.Bytes())
+}
+
+// sendMail is smtp.SendMail giving up once ctx is done, so that a stalled
+// server doesn't hold the delivery forever.
+func sendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (emitter *SMTPEmitter) Close() error {
+	return nil
+}
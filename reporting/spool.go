@@ -0,0 +1,447 @@
+package reporting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DeliveryAttempts is the number of attempts at sending a report to an
+	// emitter before it is moved to the dead-letter queue.
+	DeliveryAttempts = 10
+
+	DeliveryBackoff    = time.Minute
+	DeliveryMaxBackoff = time.Hour
+
+	// SpoolRetention is how long a report is kept once it has been sent
+	// to all of its emitters.
+	SpoolRetention = 7 * 24 * time.Hour
+
+	// claimTimeout is after how long a delivery claimed by a process
+	// that went away is put back in the queue.
+	claimTimeout = 10 * time.Minute
+)
+
+var ErrUnknownReport = errors.New("no such report")
+
+// Spool stores the reports on disk until they are sent to every emitter.
+// Each report is saved once under data/, and its delivery to an emitter is
+// tracked by a file under queue/<emitter>/ until it succeeds, or is moved
+// under dead/<emitter>/ once all attempts failed.  Deliveries are claimed
+// by renaming their file, so that several processes may share a spool.
+type Spool struct {
+	dir string
+}
+
+// Delivery is the state of the delivery of a report to an emitter.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Emitter     string    `json:"emitter"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// SpooledReport is a report of the spool and its undelivered emitters.
+type SpooledReport struct {
+	ID      string      `json:"id"`
+	Report  *Report     `json:"report"`
+	Pending []*Delivery `json:"pending,omitempty"`
+	Dead    []*Delivery `json:"dead,omitempty"`
+}
+
+// SpoolDir returns the path of the spool in cacheDir.
+func SpoolDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "reports")
+}
+
+func OpenSpool(dir string) (*Spool, error) {
+	for _, sub := range []string{"data", "queue", "dead"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &Spool{dir: dir}, nil
+}
+
+func (s *Spool) dataPath(id string) string {
+	return filepath.Join(s.dir, "data", id+".json")
+}
+
+func (s *Spool) queueDir(emitter string) string {
+	return filepath.Join(s.dir, "queue", url.QueryEscape(emitter))
+}
+
+func (s *Spool) deadDir(emitter string) string {
+	return filepath.Join(s.dir, "dead", url.QueryEscape(emitter))
+}
+
+func (d *Delivery) backoff() time.Duration {
+	delay := DeliveryBackoff
+	for i := 1; i < d.Attempts && delay < DeliveryMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, DeliveryMaxBackoff)
+}
+
+// writeJSON atomically replaces the file at path.
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// newID returns an identifier sorting reports by time.
+func newID(t time.Time) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return t.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix[:])
+}
+
+// Put saves report and queues its delivery to emitters.
+func (s *Spool) Put(report *Report, emitters []string) (string, error) {
+	id := newID(report.Timestamp)
+	if err := writeJSON(s.dataPath(id), report); err != nil {
+		return "", fmt.Errorf("failed to spool report: %w", err)
+	}
+	for _, emitter := range emitters {
+		if err := s.queue(id, emitter); err != nil {
+			return "", fmt.Errorf("failed to spool report: %w", err)
+		}
+	}
+	return id, nil
+}
+
+func (s *Spool) queue(id, emitter string) error {
+	d := &Delivery{ID: id, Emitter: emitter, NextAttempt: time.Now()}
+	return writeJSON(filepath.Join(s.queueDir(emitter), id+".json"), d)
+}
+
+// readDeliveries returns the deliveries in dir, including the claimed ones
+// if asked to, and releases those whose claim timed out.
+func readDeliveries(dir string, claimed bool) ([]*Delivery, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ret []*Delivery
+	for _, entry := range entries {
+		name := entry.Name()
+		if released, ok := strings.CutSuffix(name, ".claim"); ok {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > claimTimeout {
+				if os.Rename(filepath.Join(dir, name), filepath.Join(dir, released)) == nil {
+					name = released
+				}
+			}
+			if !claimed && name != released {
+				continue
+			}
+		} else if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		var d Delivery
+		if err := readJSON(filepath.Join(dir, name), &d); err != nil {
+			// claimed or done with in the meantime
+			continue
+		}
+		ret = append(ret, &d)
+	}
+	return ret, nil
+}
+
+// due returns the deliveries to emitter due by now, oldest first, and the
+// time the next one is due at, if any.
+func (s *Spool) due(emitter string, now time.Time) ([]*Delivery, time.Time, error) {
+	deliveries, err := readDeliveries(s.queueDir(emitter), false)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var ret []*Delivery
+	var next time.Time
+	for _, d := range deliveries {
+		if d.NextAttempt.After(now) {
+			if next.IsZero() || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+			continue
+		}
+		ret = append(ret, d)
+	}
+	slices.SortFunc(ret, func(a, b *Delivery) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return ret, next, nil
+}
+
+// claim takes a delivery over, returning false if another process did.
+func (s *Spool) claim(d *Delivery) (string, bool) {
+	path := filepath.Join(s.queueDir(d.Emitter), d.ID+".json")
+	claimed := path + ".claim"
+	if err := os.Rename(path, claimed); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(claimed, now, now)
+	return claimed, true
+}
+
+// release records the outcome of a claimed delivery: it is done if err is
+// nil, scheduled for another attempt or moved to the dead-letter queue
+// otherwise.
+func (s *Spool) release(claimed string, d *Delivery, err error) error {
+	if err == nil {
+		return os.Remove(claimed)
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= DeliveryAttempts {
+		if err := writeJSON(filepath.Join(s.deadDir(d.Emitter), d.ID+".json"), d); err != nil {
+			return err
+		}
+		return os.Remove(claimed)
+	}
+
+	d.NextAttempt = time.Now().Add(d.backoff())
+	if err := writeJSON(claimed, d); err != nil {
+		return err
+	}
+	return os.Rename(claimed, strings.TrimSuffix(claimed, ".claim"))
+}
+
+// Load returns the report saved under id.
+func (s *Spool) Load(id string) (*Report, error) {
+	var report Report
+	if err := readJSON(s.dataPath(id), &report); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownReport, id)
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// deliveries returns the deliveries under dir, which is either queue or
+// dead, by report.
+func (s *Spool) deliveries(dir string) (map[string][]*Delivery, error) {
+	emitters, err := os.ReadDir(filepath.Join(s.dir, dir))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string][]*Delivery)
+	for _, emitter := range emitters {
+		if !emitter.IsDir() {
+			continue
+		}
+		deliveries, err := readDeliveries(filepath.Join(s.dir, dir, emitter.Name()), true)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deliveries {
+			ret[d.ID] = append(ret[d.ID], d)
+		}
+	}
+	return ret, nil
+}
+
+// IDs returns the identifiers of the reports of the spool, oldest first.
+func (s *Spool) IDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "data"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			ret = append(ret, id)
+		}
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+// List returns the reports of the spool, oldest first.
+func (s *Spool) List() ([]*SpooledReport, error) {
+	ids, err := s.IDs()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.deliveries("queue")
+	if err != nil {
+		return nil, err
+	}
+	dead, err := s.deliveries("dead")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*SpooledReport
+	for _, id := range ids {
+		report, err := s.Load(id)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, &SpooledReport{
+			ID:      id,
+			Report:  report,
+			Pending: pending[id],
+			Dead:    dead[id],
+		})
+	}
+	return ret, nil
+}
+
+// Lookup returns the identifier of the report starting with prefix.
+func (s *Spool) Lookup(prefix string) (string, error) {
+	ids, err := s.IDs()
+	if err != nil {
+		return "", err
+	}
+
+	var found string
+	for _, id := range ids {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		if found != "" {
+			return "", fmt.Errorf("ambiguous report identifier: %s", prefix)
+		}
+		found = id
+	}
+	if found == "" {
+		return "", fmt.Errorf("%w: %s", ErrUnknownReport, prefix)
+	}
+	return found, nil
+}
+
+// Get returns the report of the spool starting with prefix.
+func (s *Spool) Get(prefix string) (*SpooledReport, error) {
+	id, err := s.Lookup(prefix)
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &SpooledReport{ID: id, Report: report}
+	for _, dir := range []string{"queue", "dead"} {
+		deliveries, err := s.deliveries(dir)
+		if err != nil {
+			return nil, err
+		}
+		if dir == "queue" {
+			ret.Pending = deliveries[id]
+		} else {
+			ret.Dead = deliveries[id]
+		}
+	}
+	return ret, nil
+}
+
+// Resend queues the report again for emitters, or for those it is in the
+// dead-letter queue of if none is given.
+func (s *Spool) Resend(id string, emitters []string) error {
+	if _, err := os.Stat(s.dataPath(id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrUnknownReport, id)
+		}
+		return err
+	}
+
+	if len(emitters) == 0 {
+		dead, err := s.deliveries("dead")
+		if err != nil {
+			return err
+		}
+		for _, d := range dead[id] {
+			emitters = append(emitters, d.Emitter)
+		}
+	}
+	for _, emitter := range emitters {
+		if err := s.queue(id, emitter); err != nil {
+			return err
+		}
+		err := os.Remove(filepath.Join(s.deadDir(emitter), id+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes the reports sent to all of their emitters more than
+// retention ago.
+func (s *Spool) Prune(retention time.Duration) error {
+	ids, err := s.IDs()
+	if err != nil {
+		return err
+	}
+	pending, err := s.deliveries("queue")
+	if err != nil {
+		return err
+	}
+	dead, err := s.deliveries("dead")
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if len(pending[id]) != 0 || len(dead[id]) != 0 {
+			continue
+		}
+		info, err := os.Stat(s.dataPath(id))
+		if err != nil || time.Since(info.ModTime()) < retention {
+			continue
+		}
+		if err := os.Remove(s.dataPath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package reporting
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+
+	id, err := spool.Put(testReport(StatusFailed), []string{"audit", "chat#1"})
+	require.NoError(t, err)
+
+	found, err := spool.Lookup(id[:10])
+	require.NoError(t, err)
+	require.Equal(t, id, found)
+
+	// a successful delivery is done with
+	due, _, err := spool.due("audit", time.Now())
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	claimed, ok := spool.claim(due[0])
+	require.True(t, ok)
+	_, ok = spool.claim(due[0])
+	require.False(t, ok)
+	require.NoError(t, spool.release(claimed, due[0], nil))
+
+	// a failed one is retried later
+	due, _, err = spool.due("chat#1", time.Now())
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	claimed, ok = spool.claim(due[0])
+	require.True(t, ok)
+	require.NoError(t, spool.release(claimed, due[0], errors.New("connection refused")))
+
+	due, next, err := spool.due("chat#1", time.Now())
+	require.NoError(t, err)
+	require.Empty(t, due)
+	require.WithinDuration(t, time.Now().Add(DeliveryBackoff), next, 5*time.Second)
+
+	sr, err := spool.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, sr.Report.Task.Status)
+	require.Empty(t, sr.Dead)
+	require.Len(t, sr.Pending, 1)
+	require.Equal(t, "chat#1", sr.Pending[0].Emitter)
+	require.Equal(t, 1, sr.Pending[0].Attempts)
+	require.Equal(t, "connection refused", sr.Pending[0].LastError)
+
+	// until it runs out of attempts
+	due, _, err = spool.due("chat#1", next)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	due[0].Attempts = DeliveryAttempts - 1
+	claimed, ok = spool.claim(due[0])
+	require.True(t, ok)
+	require.NoError(t, spool.release(claimed, due[0], errors.New("connection refused")))
+
+	sr, err = spool.Get(id)
+	require.NoError(t, err)
+	require.Empty(t, sr.Pending)
+	require.Len(t, sr.Dead, 1)
+
+	// and is sent again on request
+	require.NoError(t, spool.Resend(id, nil))
+	sr, err = spool.Get(id)
+	require.NoError(t, err)
+	require.Empty(t, sr.Dead)
+	require.Len(t, sr.Pending, 1)
+	require.Equal(t, 0, sr.Pending[0].Attempts)
+
+	// reports are kept until sent everywhere
+	require.NoError(t, spool.Prune(0))
+	reports, err := spool.List()
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+
+	due, _, err = spool.due("chat#1", time.Now())
+	require.NoError(t, err)
+	claimed, ok = spool.claim(due[0])
+	require.True(t, ok)
+	require.NoError(t, spool.release(claimed, due[0], nil))
+
+	require.NoError(t, spool.Prune(0))
+	reports, err = spool.List()
+	require.NoError(t, err)
+	require.Empty(t, reports)
+
+	_, err = spool.Get(id)
+	require.ErrorIs(t, err, ErrUnknownReport)
+}
+
+func TestSpoolClaimTimeout(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = spool.Put(testReport(StatusOK), []string{"audit"})
+	require.NoError(t, err)
+
+	due, _, err := spool.due("audit", time.Now())
+	require.NoError(t, err)
+	claimed, ok := spool.claim(due[0])
+	require.True(t, ok)
+
+	due, _, err = spool.due("audit", time.Now())
+	require.NoError(t, err)
+	require.Empty(t, due)
+
+	// the process that claimed it went away
+	stale := time.Now().Add(-2 * claimTimeout)
+	require.NoError(t, os.Chtimes(claimed, stale, stale))
+
+	due, _, err = spool.due("audit", time.Now())
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+}
+
+func TestReporterSpool(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	ctx := appcontext.NewAppContext()
+	ctx.CacheDir = t.TempDir()
+	ctx.ConfigDir = t.TempDir()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+
+	out := filepath.Join(t.TempDir(), "reports.jsonl")
+	require.NoError(t, os.WriteFile(ConfigPath(ctx.ConfigDir), []byte(`
+emitters:
+  - name: audit
+    type: file
+    path: `+out+`
+  - name: chat
+    type: webhook
+    url: `+failing.URL+`
+  - name: oncall
+    type: file
+    path: `+out+`
+    filter:
+      status: [failure]
+`), 0600))
+
+	reporter := NewReporter(ctx)
+	report := reporter.NewReport()
+	report.TaskStart("backup", "nightly")
+	report.TaskDone()
+	reporter.StopAndWait()
+
+	// the file emitter got it despite the webhook failing
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 1)
+
+	spool, err := OpenSpool(SpoolDir(ctx.CacheDir))
+	require.NoError(t, err)
+	reports, err := spool.List()
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Equal(t, "nightly", reports[0].Report.Task.Name)
+	require.Len(t, reports[0].Pending, 1)
+	require.Equal(t, "chat", reports[0].Pending[0].Emitter)
+	require.Equal(t, 1, reports[0].Pending[0].Attempts)
+	require.Contains(t, reports[0].Pending[0].LastError, "503")
+}
+
+func TestReporterSpoolWithoutEmitters(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	ctx.CacheDir = t.TempDir()
+	ctx.ConfigDir = t.TempDir()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+
+	reporter := NewReporter(ctx)
+	report := reporter.NewReport()
+	report.TaskStart("backup", "nightly")
+	report.TaskDone()
+	reporter.StopAndWait()
+
+	// nobody would ever read it
+	spool, err := OpenSpool(SpoolDir(ctx.CacheDir))
+	require.NoError(t, err)
+	ids, err := spool.IDs()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogEmitter logs a line per report, at the error level for failures.
+type SyslogEmitter struct {
+	writer *syslog.Writer
+}
+
+func NewSyslogEmitter(network, address, tag string) (*SyslogEmitter, error) {
+	if tag == "" {
+		tag = "plakar"
+	}
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogEmitter{writer: w}, nil
+}
+
+func (emitter *SyslogEmitter) Emit(ctx context.Context, report *Report) error {
+	line := summary(report)
+	if report.Task == nil {
+		return emitter.writer.Info(line)
+	}
+	switch report.Task.Status {
+	case StatusFailed:
+		return emitter.writer.Err(line)
+	case StatusWarning:
+		return emitter.writer.Warning(line)
+	default:
+		return emitter.writer.Info(line)
+	}
+}
+
+func (emitter *SyslogEmitter) Close() error {
+	return emitter.writer.Close()
+}
+
+func summary(report *Report) string {
+	line := "report"
+	if task := report.Task; task != nil {
+		line = fmt.Sprintf("%s %s: %s in %s", task.Type, task.Name, task.Status, task.Duration)
+		if task.ErrorMessage != "" {
+			line += ": " + task.ErrorMessage
+		}
+	}
+	if report.Repository != nil {
+		line += fmt.Sprintf(" (repository %s)", report.Repository.Name)
+	}
+	return line
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package reporting
+
+import (
+	"context"
+	"fmt"
+)
+
+type SyslogEmitter struct{}
+
+func NewSyslogEmitter(network, address, tag string) (*SyslogEmitter, error) {
+	return nil, fmt.Errorf("syslog is not supported on windows")
+}
+
+func (emitter *SyslogEmitter) Emit(ctx context.Context, report *Report) error {
+	return nil
+}
+
+func (emitter *SyslogEmitter) Close() error {
+	return nil
+}
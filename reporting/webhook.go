@@ -0,0 +1,124 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"text/template"
+
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+const DefaultSignatureHeader = "X-Plakar-Signature"
+
+// newTemplate parses a template rendering a report, text being optional.
+func newTemplate(name string, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New(name).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+// render executes the template on the report, or encodes the report as
+// JSON if there is no template.
+func render(tmpl *template.Template, report *Report) ([]byte, error) {
+	if tmpl == nil {
+		return json.Marshal(report)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, report); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WebhookEmitter sends reports to an HTTP endpoint, either as JSON or with
+// a templated body, signed with HMAC-SHA256 if it has a secret.
+type WebhookEmitter struct {
+	url             string
+	method          string
+	headers         map[string]string
+	body            *template.Template
+	secret          []byte
+	signatureHeader string
+	client          http.Client
+}
+
+func NewWebhookEmitter(url, method string, headers map[string]string, body, secret, signatureHeader string) (*WebhookEmitter, error) {
+	if url == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+	if method == "" {
+		method = http.MethodPost
+	}
+	if signatureHeader == "" {
+		signatureHeader = DefaultSignatureHeader
+	}
+	tmpl, err := newTemplate("body", body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	return &WebhookEmitter{
+		url:             url,
+		method:          method,
+		headers:         headers,
+		body:            tmpl,
+		secret:          []byte(secret),
+		signatureHeader: signatureHeader,
+	}, nil
+}
+
+// Sign returns the signature of a body sent with secret, as found in the
+// signature header.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (emitter *WebhookEmitter) Emit(ctx context.Context, report *Report) error {
+	data, err := render(emitter.body, report)
+	if err != nil {
+		return fmt.Errorf("failed to render report: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, emitter.method, emitter.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("plakar/%s (%s/%s)", utils.VERSION, runtime.GOOS, runtime.GOARCH))
+	if emitter.body == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range emitter.headers {
+		req.Header.Set(key, value)
+	}
+	if len(emitter.secret) != 0 {
+		req.Header.Set(emitter.signatureHeader, Sign(emitter.secret, data))
+	}
+
+	res, err := emitter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if 200 <= res.StatusCode && res.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("request failed with status %s", res.Status)
+}
+
+func (emitter *WebhookEmitter) Close() error {
+	return nil
+}
@@ -18,6 +18,13 @@ type Request struct {
 type Response struct {
 	ExitCode int
 	Err      string
+	Payload  []byte
+}
+
+// TaskRequest is the payload of the requests acting on a single task,
+// designated either by its identifier or by its name.
+type TaskRequest struct {
+	Name string
 }
 
 type Client struct {
@@ -76,9 +83,18 @@ func (c *Client) handshake(ignoreVersion bool) error {
 	return nil
 }
 
-func (c *Client) Stop() (int, error) {
+// call sends a request with the given payload, if any, and decodes the
+// payload of the response into result, if any.
+func (c *Client) call(reqType string, payload any, result any) (int, error) {
 	var request Request
-	request.Type = "stop"
+	request.Type = reqType
+	if payload != nil {
+		data, err := msgpack.Marshal(payload)
+		if err != nil {
+			return 1, fmt.Errorf("failed to encode request: %w", err)
+		}
+		request.Payload = data
+	}
 	if err := c.enc.Encode(request); err != nil {
 		return 1, fmt.Errorf("failed to send packet: %w", err)
 	}
@@ -88,11 +104,49 @@ func (c *Client) Stop() (int, error) {
 		return 1, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	var err error
 	if response.Err != "" {
-		err = fmt.Errorf("scheduler error: %s", response.Err)
+		return response.ExitCode, fmt.Errorf("scheduler error: %s", response.Err)
+	}
+
+	if result != nil {
+		if err := msgpack.Unmarshal(response.Payload, result); err != nil {
+			return 1, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return response.ExitCode, nil
+}
+
+func (c *Client) Stop() (int, error) {
+	return c.call("stop", nil, nil)
+}
+
+// Reload asks the scheduler to read its tasks configuration again.
+func (c *Client) Reload() (ConfigDiff, error) {
+	var diff ConfigDiff
+	if _, err := c.call("reload", nil, &diff); err != nil {
+		return diff, err
+	}
+	return diff, nil
+}
+
+func (c *Client) ListTasks() ([]TaskStatus, error) {
+	var tasks []TaskStatus
+	if _, err := c.call("list", nil, &tasks); err != nil {
+		return nil, err
 	}
-	return response.ExitCode, err
+	return tasks, nil
+}
+
+func (c *Client) RunTask(name string) (int, error) {
+	return c.call("run", TaskRequest{Name: name}, nil)
+}
+
+func (c *Client) PauseTask(name string) (int, error) {
+	return c.call("pause", TaskRequest{Name: name}, nil)
+}
+
+func (c *Client) ResumeTask(name string) (int, error) {
+	return c.call("resume", TaskRequest{Name: name}, nil)
 }
 
 func (c *Client) Close() error {
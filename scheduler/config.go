@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-viper/mapstructure/v2"
 
@@ -21,6 +22,14 @@ type AgentConfig struct {
 	Reporting   bool                `yaml:"reporting"`
 	Maintenance []MaintenanceConfig `validate:"dive"`
 	Tasks       []Task              `mapstructure:"tasks" validate:"dive"`
+
+	// MaxConcurrentTasks bounds the number of tasks running at once,
+	// zero meaning no limit.
+	MaxConcurrentTasks int `mapstructure:"max_concurrent_tasks" validate:"gte=0"`
+
+	// Jitter is the default upper bound of the random delay added to
+	// each scheduled run, to spread the load of hosts sharing a store.
+	Jitter time.Duration `validate:"gte=0"`
 }
 
 type Task struct {
@@ -31,17 +40,52 @@ type Task struct {
 	Check   []CheckConfig   `validate:"dive"`
 	Restore []RestoreConfig `validate:"dive"`
 	Sync    []SyncConfig    `validate:"dive"`
+	Monitor []MonitorConfig `validate:"dive"`
+
+	Pipeline *PipelineConfig
+
+	// Retry applies to all the actions of the task.
+	Retry *RetryConfig
 }
 
 type BackupConfig struct {
 	Name       string
 	Tags       []string
 	Path       string        `validate:"required"`
-	Interval   time.Duration `validate:"required"`
+	Interval   time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule   *Schedule
+	Catchup    CatchupPolicy
+	Jitter     time.Duration `validate:"gte=0"`
 	Check      BackupConfigCheck
-	Retention  time.Duration
+	Retention  time.Duration `validate:"excluded_with=Policy"`
+	Policy     *PolicyConfig
 	Ignore     []string
-	IgnoreFile string `yaml:"ignoreFile"`
+	IgnoreFile string      `yaml:"ignoreFile"`
+	PreHook    *HookConfig `mapstructure:"pre_hook"`
+	PostHook   *HookConfig `mapstructure:"post_hook"`
+}
+
+// HookConfig describes a command run before or after a backup.
+type HookConfig struct {
+	Command        string `validate:"required"`
+	Timeout        time.Duration
+	AbortOnFailure bool `mapstructure:"abort_on_failure"`
+}
+
+// HookConfigDecodeHook is a mapstructure decode hook to allow users to
+// specify "pre_hook: <command>" in the config file, but also with
+// "pre_hook: <object>" to set a timeout or abort on failure.
+func HookConfigDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(HookConfig{}) {
+			return HookConfig{Command: data.(string)}, nil
+		}
+		return data, nil
+	}
 }
 
 // CheckDecodeHook is a mapstructure decode hook to allow users to specify
@@ -74,14 +118,34 @@ type CheckConfig struct {
 	Path     string `validate:"required"`
 	Since    string
 	Before   string
-	Interval time.Duration `validate:"required"`
+	Interval time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule *Schedule
+	Catchup  CatchupPolicy
+	Jitter   time.Duration `validate:"gte=0"`
 	Latest   bool
 }
 
 type RestoreConfig struct {
 	Path     string        `validate:"required"`
 	Target   string        `validate:"required"`
-	Interval time.Duration `validate:"required"`
+	Interval time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule *Schedule
+	Catchup  CatchupPolicy
+	Jitter   time.Duration `validate:"gte=0"`
+}
+
+// MonitorConfig reports the snapshots of the task that are too old, or
+// that changed too much since the previous one.  Changes are percentages
+// and zero disables a threshold.
+type MonitorConfig struct {
+	Interval    time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule    *Schedule
+	Catchup     CatchupPolicy
+	Jitter      time.Duration `validate:"gte=0"`
+	MaxAge      time.Duration `mapstructure:"max_age" validate:"gte=0"`
+	SizeChange  float64       `mapstructure:"size_change" validate:"gte=0"`
+	FilesChange float64       `mapstructure:"files_change" validate:"gte=0"`
+	DedupDrop   float64       `mapstructure:"dedup_drop" validate:"gte=0,lte=100"`
 }
 
 type SyncDirection string
@@ -116,6 +180,38 @@ func SyncDirectionDecodeHook() mapstructure.DecodeHookFunc {
 	}
 }
 
+// CatchupPolicy tells what to do with the runs of a task that were missed
+// because the scheduler was not running or the host was asleep.
+type CatchupPolicy string
+
+const (
+	CatchupNone CatchupPolicy = "none"
+	CatchupOnce CatchupPolicy = "once"
+	CatchupAll  CatchupPolicy = "all"
+)
+
+// CatchupPolicyDecodeHook is a mapstructure decode hook to force the catchup
+// policy to be one of "none", "once" or "all".  An absent policy is
+// treated as "once".
+func CatchupPolicyDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(CatchupPolicy("")) {
+			s := strings.TrimSpace(data.(string))
+			switch s {
+			case "none", "once", "all":
+				return CatchupPolicy(s), nil
+			default:
+				return nil, fmt.Errorf("invalid catchup policy %q; must be one of: none, once, all", s)
+			}
+		}
+		return data, nil
+	}
+}
+
 func DurationDecodeHook() mapstructure.DecodeHookFunc {
 	return func(
 		from reflect.Type,
@@ -145,74 +241,349 @@ func DurationDecodeHook() mapstructure.DecodeHookFunc {
 type SyncConfig struct {
 	Peer      string        `validate:"required"`
 	Direction SyncDirection `validate:"required"`
-	Interval  time.Duration `validate:"required"`
+	Interval  time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule  *Schedule
+	Catchup   CatchupPolicy
+	Jitter    time.Duration `validate:"gte=0"`
 }
 
 type MaintenanceConfig struct {
-	Interval   time.Duration `validate:"required"`
-	Retention  time.Duration `validate:"required"`
-	Repository string        `validate:"required"`
+	Interval   time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule   *Schedule
+	Catchup    CatchupPolicy
+	Jitter     time.Duration `validate:"gte=0"`
+	Retention  time.Duration `validate:"required_without=Policy,excluded_with=Policy"`
+	Policy     *PolicyConfig
+	Repository string `validate:"required"`
+	Retry      *RetryConfig
 }
 
-func NewConfiguration() *Configuration {
-	return &Configuration{}
+// PolicyConfig selects the snapshots kept once a task ran: those of the
+// named policy of policies.yml, if any, with the inline rules taking
+// precedence as with "plakar prune -policy".  The fields are those of
+// "plakar policy set".
+type PolicyConfig struct {
+	Name string
+
+	// DryRun only reports the snapshots that would be pruned.
+	DryRun bool `mapstructure:"dry_run"`
+
+	Minutes    int `validate:"gte=0"`
+	Hours      int `validate:"gte=0"`
+	Days       int `validate:"gte=0"`
+	Weeks      int `validate:"gte=0"`
+	Months     int `validate:"gte=0"`
+	Years      int `validate:"gte=0"`
+	Mondays    int `validate:"gte=0"`
+	Tuesdays   int `validate:"gte=0"`
+	Wednesdays int `validate:"gte=0"`
+	Thursdays  int `validate:"gte=0"`
+	Fridays    int `validate:"gte=0"`
+	Saturdays  int `validate:"gte=0"`
+	Sundays    int `validate:"gte=0"`
+
+	PerMinute    int `mapstructure:"per-minute" validate:"gte=0"`
+	PerHour      int `mapstructure:"per-hour" validate:"gte=0"`
+	PerDay       int `mapstructure:"per-day" validate:"gte=0"`
+	PerWeek      int `mapstructure:"per-week" validate:"gte=0"`
+	PerMonth     int `mapstructure:"per-month" validate:"gte=0"`
+	PerYear      int `mapstructure:"per-year" validate:"gte=0"`
+	PerMonday    int `mapstructure:"per-monday" validate:"gte=0"`
+	PerTuesday   int `mapstructure:"per-tuesday" validate:"gte=0"`
+	PerWednesday int `mapstructure:"per-wednesday" validate:"gte=0"`
+	PerThursday  int `mapstructure:"per-thursday" validate:"gte=0"`
+	PerFriday    int `mapstructure:"per-friday" validate:"gte=0"`
+	PerSaturday  int `mapstructure:"per-saturday" validate:"gte=0"`
+	PerSunday    int `mapstructure:"per-sunday" validate:"gte=0"`
 }
 
-func DefaultConfiguration() *Configuration {
-	return &Configuration{}
+// PolicyConfigDecodeHook is a mapstructure decode hook to allow users to
+// specify "policy: <name>" in the config file, but also with
+// "policy: <object>" to inline the rules.
+func PolicyConfigDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(PolicyConfig{}) {
+			return PolicyConfig{Name: data.(string)}, nil
+		}
+		return data, nil
+	}
 }
 
-// ParseConfig parses the YAML file into the Config struct.
-func ParseConfigFile(filename string) (*Configuration, error) {
-	file := viper.New()
-	file.SetConfigFile(filename)
+// RetryConfig tells how a failed run is retried before giving up until
+// the next scheduled one.  Unset fields default to DefaultRetryAttempts,
+// DefaultRetryBackoff, DefaultRetryMaxBackoff and DefaultRetryClasses.
+type RetryConfig struct {
+	// Attempts is the total number of attempts, the first one included.
+	Attempts   int           `validate:"gte=0"`
+	Backoff    time.Duration `validate:"gte=0"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff" validate:"gte=0"`
+	On         []ErrorClass
+}
 
-	if err := file.ReadInConfig(); err != nil {
-		return nil, fmt.Errorf("failed to read configuration file: %w", err)
-	}
+// ErrorClass designates the errors worth retrying.
+type ErrorClass string
 
-	var config Configuration
+const (
+	// ErrorStorage is an unreachable store or repository.
+	ErrorStorage ErrorClass = "storage"
+	// ErrorLock is a repository locked by another host.
+	ErrorLock ErrorClass = "lock"
+	// ErrorAny is any error.
+	ErrorAny ErrorClass = "any"
+)
 
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		Result: &config,
-		DecodeHook: mapstructure.ComposeDecodeHookFunc(
-			BackupConfigCheckDecodeHook(),
-			SyncDirectionDecodeHook(),
-			DurationDecodeHook(),
-		),
-		ErrorUnused: true, // errors out if there are extra/unmapped keys
-	})
-	if err != nil {
-		return nil, fmt.Errorf("creating decoder: %w", err)
+// ErrorClassDecodeHook is a mapstructure decode hook to force the classes
+// of errors to retry to be one of "storage", "lock" or "any".
+func ErrorClassDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(ErrorClass("")) {
+			s := strings.TrimSpace(data.(string))
+			switch s {
+			case "storage", "lock", "any":
+				return ErrorClass(s), nil
+			default:
+				return nil, fmt.Errorf("invalid error class %q; must be one of: storage, lock, any", s)
+			}
+		}
+		return data, nil
 	}
+}
 
-	if err := decoder.Decode(file.AllSettings()); err != nil {
-		return nil, fmt.Errorf("decoding config: %w", err)
-	}
+// PipelineConfig chains steps that run one after the other, following the
+// schedule of the pipeline.
+type PipelineConfig struct {
+	Interval time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule *Schedule
+	Catchup  CatchupPolicy
+	Jitter   time.Duration  `validate:"gte=0"`
+	Steps    []PipelineStep `validate:"required,min=1,dive"`
+}
 
-	// Set default values for SyncConfig.Direction.
-	for i := range config.Agent.Tasks {
-		for j := range config.Agent.Tasks[i].Sync {
-			if config.Agent.Tasks[i].Sync[j].Direction == "" {
-				config.Agent.Tasks[i].Sync[j].Direction = SyncDirectionTo
+// StepCondition tells, given the outcome of the previous steps of a
+// pipeline, whether a step should run.
+type StepCondition string
+
+const (
+	StepOnSuccess StepCondition = "success"
+	StepOnFailure StepCondition = "failure"
+	StepAlways    StepCondition = "always"
+)
+
+// StepConditionDecodeHook is a mapstructure decode hook to force the "when"
+// of a pipeline step to be one of "success", "failure" or "always".
+func StepConditionDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(StepCondition("")) {
+			s := strings.TrimSpace(data.(string))
+			switch s {
+			case "success", "failure", "always":
+				return StepCondition(s), nil
+			default:
+				return nil, fmt.Errorf("invalid step condition %q; must be one of: success, failure, always", s)
 			}
 		}
+		return data, nil
 	}
+}
 
-	validate := validator.New(validator.WithRequiredStructEnabled())
+// PipelineStep holds exactly one action.  Steps running after a successful
+// backup step operate on the snapshot it produced.
+type PipelineStep struct {
+	When StepCondition
 
-	validate.RegisterStructValidation(func(sl validator.StructLevel) {
-		obj := sl.Current().Interface().(Task)
-		if obj.Backup == nil && len(obj.Check) == 0 && len(obj.Restore) == 0 && len(obj.Sync) == 0 {
-			sl.ReportError(obj, "Task", "Task", "atleastone", "at least one of Backup, Check, Restore, or Sync must be set")
+	Backup      *BackupStep
+	Check       *CheckStep
+	Restore     *RestoreStep
+	Sync        *SyncStep
+	Maintenance *MaintenanceStep
+}
+
+type BackupStep struct {
+	Tags       []string
+	Path       string `validate:"required"`
+	Check      BackupConfigCheck
+	Retention  time.Duration `validate:"excluded_with=Policy"`
+	Policy     *PolicyConfig
+	Ignore     []string
+	IgnoreFile string      `yaml:"ignoreFile"`
+	PreHook    *HookConfig `mapstructure:"pre_hook"`
+	PostHook   *HookConfig `mapstructure:"post_hook"`
+}
+
+type CheckStep struct {
+	Path   string
+	Latest bool
+}
+
+type RestoreStep struct {
+	Path   string
+	Target string `validate:"required"`
+}
+
+type SyncStep struct {
+	Peer      string `validate:"required"`
+	Direction SyncDirection
+}
+
+type MaintenanceStep struct {
+	Retention time.Duration `validate:"excluded_with=Policy"`
+	Policy    *PolicyConfig
+}
+
+func (step PipelineStep) actions() []string {
+	var ret []string
+	if step.Backup != nil {
+		ret = append(ret, "backup")
+	}
+	if step.Check != nil {
+		ret = append(ret, "check")
+	}
+	if step.Restore != nil {
+		ret = append(ret, "restore")
+	}
+	if step.Sync != nil {
+		ret = append(ret, "sync")
+	}
+	if step.Maintenance != nil {
+		ret = append(ret, "maintenance")
+	}
+	return ret
+}
+
+// hasMaintenance tells whether one of the steps is a maintenance, which
+// needs the repository for itself.
+func (c PipelineConfig) hasMaintenance() bool {
+	for _, step := range c.Steps {
+		if step.Maintenance != nil {
+			return true
 		}
-	}, Task{})
+	}
+	return false
+}
 
-	if err := validate.Struct(config); err != nil {
-		return nil, fmt.Errorf("validating config: %w", err)
+// Kind returns the name of the action of the step.
+func (step PipelineStep) Kind() string {
+	if actions := step.actions(); len(actions) == 1 {
+		return actions[0]
 	}
+	return ""
+}
 
-	return &config, nil
+// Hook returns the backup hook described by the configuration, the zero
+// hook being a no-op.
+func (c *HookConfig) Hook() backup.Hook {
+	if c == nil {
+		return backup.Hook{}
+	}
+	return backup.Hook{
+		Command:        c.Command,
+		Timeout:        c.Timeout,
+		AbortOnFailure: c.AbortOnFailure,
+	}
+}
+
+func scheduleOrInterval(schedule *Schedule, interval time.Duration) *Schedule {
+	if schedule != nil {
+		return schedule
+	}
+	return IntervalSchedule(interval)
+}
+
+func (c BackupConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+func (c CheckConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+func (c RestoreConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+func (c SyncConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+func (c MonitorConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+func (c MaintenanceConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+func (c PipelineConfig) GetSchedule() *Schedule {
+	return scheduleOrInterval(c.Schedule, c.Interval)
+}
+
+// TaskID returns the identifier of a scheduled entry of a task.  Entries
+// that can be repeated, such as checks, are suffixed with their index.
+func TaskID(task string, kind string, index int) string {
+	if index < 0 {
+		return task + "/" + kind
+	}
+	return fmt.Sprintf("%s/%s/%d", task, kind, index)
+}
+
+// TaskSchedule associates a configured task with its schedule.
+type TaskSchedule struct {
+	ID       string
+	Task     string
+	Kind     string
+	Schedule *Schedule
+	Catchup  CatchupPolicy
+	Jitter   time.Duration
+}
+
+// jitter returns the jitter of an action, defaulting to the global one.
+func (config *Configuration) jitter(jitter time.Duration) time.Duration {
+	if jitter != 0 {
+		return jitter
+	}
+	return config.Agent.Jitter
+}
+
+// Schedules returns the schedule of every task in the configuration, in the
+// order they appear in the file.
+func (config *Configuration) Schedules() []TaskSchedule {
+	var ret []TaskSchedule
+	for _, u := range config.units() {
+		ret = append(ret, u.schedule)
+	}
+	return ret
+}
+
+func NewConfiguration() *Configuration {
+	return &Configuration{}
+}
+
+func DefaultConfiguration() *Configuration {
+	return &Configuration{}
+}
+
+// ParseConfig parses the YAML file into the Config struct.
+func ParseConfigFile(filename string) (*Configuration, error) {
+	file := viper.New()
+	file.SetConfigFile(filename)
+
+	if err := file.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	return decodeConfig(file.AllSettings())
 }
 
 func ParseConfigBytes(configBytes []byte) (*Configuration, error) {
@@ -223,14 +594,24 @@ func ParseConfigBytes(configBytes []byte) (*Configuration, error) {
 		return nil, fmt.Errorf("failed to read configuration data: %w", err)
 	}
 
+	return decodeConfig(file.AllSettings())
+}
+
+func decodeConfig(settings map[string]any) (*Configuration, error) {
 	var config Configuration
 
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		Result: &config,
 		DecodeHook: mapstructure.ComposeDecodeHookFunc(
 			BackupConfigCheckDecodeHook(),
+			HookConfigDecodeHook(),
+			PolicyConfigDecodeHook(),
 			SyncDirectionDecodeHook(),
 			DurationDecodeHook(),
+			ScheduleDecodeHook(),
+			CatchupPolicyDecodeHook(),
+			StepConditionDecodeHook(),
+			ErrorClassDecodeHook(),
 		),
 		ErrorUnused: true, // errors out if there are extra/unmapped keys
 	})
@@ -238,28 +619,59 @@ func ParseConfigBytes(configBytes []byte) (*Configuration, error) {
 		return nil, fmt.Errorf("creating decoder: %w", err)
 	}
 
-	if err := decoder.Decode(file.AllSettings()); err != nil {
+	if err := decoder.Decode(settings); err != nil {
 		return nil, fmt.Errorf("decoding config: %w", err)
 	}
 
-	// Set default values for SyncConfig.Direction.
+	// Set default values for SyncConfig.Direction and the pipeline steps.
 	for i := range config.Agent.Tasks {
 		for j := range config.Agent.Tasks[i].Sync {
 			if config.Agent.Tasks[i].Sync[j].Direction == "" {
 				config.Agent.Tasks[i].Sync[j].Direction = SyncDirectionTo
 			}
 		}
+		if pipeline := config.Agent.Tasks[i].Pipeline; pipeline != nil {
+			for j := range pipeline.Steps {
+				if pipeline.Steps[j].When == "" {
+					pipeline.Steps[j].When = StepOnSuccess
+				}
+				if sync := pipeline.Steps[j].Sync; sync != nil && sync.Direction == "" {
+					sync.Direction = SyncDirectionTo
+				}
+			}
+		}
 	}
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
 
 	validate.RegisterStructValidation(func(sl validator.StructLevel) {
 		obj := sl.Current().Interface().(Task)
-		if obj.Backup == nil && len(obj.Check) == 0 && len(obj.Restore) == 0 && len(obj.Sync) == 0 {
-			sl.ReportError(obj, "Task", "Task", "atleastone", "at least one of Backup, Check, Restore, or Sync must be set")
+		if obj.Backup == nil && len(obj.Check) == 0 && len(obj.Restore) == 0 && len(obj.Sync) == 0 && len(obj.Monitor) == 0 && obj.Pipeline == nil {
+			sl.ReportError(obj, "Task", "Task", "atleastone", "at least one of Backup, Check, Restore, Sync, Monitor or Pipeline must be set")
 		}
 	}, Task{})
 
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		obj := sl.Current().Interface().(MonitorConfig)
+		if obj.MaxAge == 0 && obj.SizeChange == 0 && obj.FilesChange == 0 && obj.DedupDrop == 0 {
+			sl.ReportError(obj, "Monitor", "Monitor", "atleastone", "a monitor needs at least one threshold")
+		}
+	}, MonitorConfig{})
+
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		obj := sl.Current().Interface().(PipelineStep)
+		if len(obj.actions()) != 1 {
+			sl.ReportError(obj, "Step", "Step", "exactlyone", "exactly one of Backup, Check, Restore, Sync or Maintenance must be set")
+		}
+	}, PipelineStep{})
+
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		obj := sl.Current().Interface().(PolicyConfig)
+		if obj.Name == "" && !obj.inline().HasPeriods() {
+			sl.ReportError(obj, "Policy", "Policy", "atleastone", "a policy needs a name or at least one rule")
+		}
+	}, PolicyConfig{})
+
 	if err := validate.Struct(config); err != nil {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
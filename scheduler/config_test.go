@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigHooks(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: db
+      repository: /var/backups
+      backup:
+        path: /var/lib/postgres/dump
+        interval: 24h
+        pre_hook: pg_dumpall -f /var/lib/postgres/dump/all.sql
+        post_hook:
+          command: rm -f /var/lib/postgres/dump/all.sql
+          timeout: 1m
+          abort_on_failure: true
+`))
+	require.NoError(t, err)
+
+	backup := config.Agent.Tasks[0].Backup
+	pre := backup.PreHook.Hook()
+	require.Equal(t, "pg_dumpall -f /var/lib/postgres/dump/all.sql", pre.Command)
+	require.Zero(t, pre.Timeout)
+	require.False(t, pre.AbortOnFailure)
+
+	post := backup.PostHook.Hook()
+	require.Equal(t, "rm -f /var/lib/postgres/dump/all.sql", post.Command)
+	require.Equal(t, time.Minute, post.Timeout)
+	require.True(t, post.AbortOnFailure)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: db
+      repository: /var/backups
+      backup:
+        path: /var/lib/postgres/dump
+        interval: 24h
+        pre_hook:
+          timeout: 1m
+`))
+	require.Error(t, err)
+}
+
+func TestConfigConcurrency(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  max_concurrent_tasks: 2
+  jitter: 5m
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        interval: 24h
+      check:
+        - path: /
+          interval: 1h
+          jitter: 30s
+`))
+	require.NoError(t, err)
+	require.Equal(t, 2, config.Agent.MaxConcurrentTasks)
+
+	schedules := config.Schedules()
+	require.Equal(t, 5*time.Minute, schedules[0].Jitter)
+	require.Equal(t, 30*time.Second, schedules[1].Jitter)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  max_concurrent_tasks: -1
+`))
+	require.Error(t, err)
+}
+
+func TestConfigRetry(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      retry:
+        attempts: 5
+        backoff: 30s
+        max_backoff: 10m
+        on: [storage, lock]
+      backup:
+        path: /home
+        interval: 24h
+`))
+	require.NoError(t, err)
+	require.Equal(t, &RetryConfig{
+		Attempts:   5,
+		Backoff:    30 * time.Second,
+		MaxBackoff: 10 * time.Minute,
+		On:         []ErrorClass{ErrorStorage, ErrorLock},
+	}, config.Agent.Tasks[0].Retry)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      retry:
+        on: [disk]
+      backup:
+        path: /home
+        interval: 24h
+`))
+	require.Error(t, err)
+}
+
+func TestConfigPolicy(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  maintenance:
+    - repository: /var/backups
+      interval: 24h
+      policy: monthly
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        interval: 24h
+        policy:
+          name: weekly
+          dry_run: true
+          days: 7
+          per-day: 1
+`))
+	require.NoError(t, err)
+	require.Equal(t, &PolicyConfig{Name: "monthly"}, config.Agent.Maintenance[0].Policy)
+	require.Equal(t, &PolicyConfig{Name: "weekly", DryRun: true, Days: 7, PerDay: 1}, config.Agent.Tasks[0].Backup.Policy)
+
+	for _, backup := range []string{
+		// either a retention or a policy
+		"{path: /home, interval: 24h, retention: 168h, policy: weekly}",
+		// a policy that keeps everything
+		"{path: /home, interval: 24h, policy: {dry_run: true}}",
+	} {
+		_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup: ` + backup + `
+`))
+		require.Error(t, err, backup)
+	}
+}
+
+func TestConfigMonitor(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      monitor:
+        - schedule: "@hourly"
+          max_age: 26h
+          size_change: 50
+          dedup_drop: 75
+`))
+	require.NoError(t, err)
+	require.Len(t, config.Agent.Tasks[0].Monitor, 1)
+	monitor := config.Agent.Tasks[0].Monitor[0]
+	require.Equal(t, 26*time.Hour, monitor.MaxAge)
+	require.Equal(t, 50.0, monitor.SizeChange)
+	require.Equal(t, 0.0, monitor.FilesChange)
+	require.Equal(t, 75.0, monitor.DedupDrop)
+
+	for _, monitor := range []string{
+		// no threshold
+		`{schedule: "@hourly"}`,
+		// a drop beyond everything
+		`{schedule: "@hourly", dedup_drop: 150}`,
+		`{schedule: "@hourly", size_change: -10}`,
+	} {
+		_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      monitor: [` + monitor + `]
+`))
+		require.Error(t, err, monitor)
+	}
+}
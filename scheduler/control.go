@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/reporting"
+)
+
+var (
+	ErrUnknownTask   = errors.New("no such task")
+	ErrAlreadyQueued = errors.New("a run is already queued")
+)
+
+type TaskState string
+
+const (
+	TaskIdle    TaskState = "idle"
+	TaskQueued  TaskState = "queued"
+	TaskRunning TaskState = "running"
+	TaskPaused  TaskState = "paused"
+)
+
+// TaskStatus is the state of a scheduled task as reported over the control
+// socket.
+type TaskStatus struct {
+	ID         string
+	Task       string
+	Kind       string
+	Schedule   string
+	State      TaskState
+	QueuedOn   string
+	Paused     bool
+	LastRun    time.Time
+	LastResult string
+	LastError  string
+	NextRun    time.Time
+}
+
+// taskEntry tracks a running task so that it can be inspected and driven
+// through the control socket.
+type taskEntry struct {
+	unit    unit
+	trigger chan struct{}
+
+	// cancelled to stop the task, done is closed once it stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// the failed attempts of the current run, only used by the goroutine
+	// of the task.
+	attempts []reporting.ReportAttempt
+
+	mu         sync.Mutex
+	paused     bool
+	queued     string
+	running    bool
+	lastRun    time.Time
+	lastResult string
+	lastError  string
+}
+
+func newTaskEntry(parent context.Context, u unit) *taskEntry {
+	ctx, cancel := context.WithCancel(parent)
+	return &taskEntry{
+		unit:    u,
+		trigger: make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// stop makes the task exit once its current run, if any, is over.
+func (e *taskEntry) stop() {
+	e.cancel()
+}
+
+// inherit carries the state of the entry replaced by a reload over.
+func (e *taskEntry) inherit(old *taskEntry) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+
+	e.paused = old.paused
+	e.lastRun = old.lastRun
+	e.lastResult = old.lastResult
+	e.lastError = old.lastError
+}
+
+func (e *taskEntry) isPaused() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.paused
+}
+
+func (e *taskEntry) setPaused(paused bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.paused = paused
+}
+
+// setQueued records why the task is waiting to start.
+func (e *taskEntry) setQueued(reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.queued = reason
+}
+
+func (e *taskEntry) started() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.queued = ""
+	e.running = true
+	e.lastRun = time.Now()
+}
+
+func (e *taskEntry) finished(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running = false
+	if err != nil {
+		e.lastResult = "failure"
+		e.lastError = err.Error()
+	} else {
+		e.lastResult = "success"
+		e.lastError = ""
+	}
+}
+
+func (e *taskEntry) status(ledger *Ledger, now time.Time) TaskStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	st := TaskStatus{
+		ID:         e.unit.schedule.ID,
+		Task:       e.unit.schedule.Task,
+		Kind:       e.unit.schedule.Kind,
+		Schedule:   e.unit.schedule.Schedule.String(),
+		State:      TaskIdle,
+		Paused:     e.paused,
+		LastRun:    e.lastRun,
+		LastResult: e.lastResult,
+		LastError:  e.lastError,
+		NextRun:    e.unit.schedule.NextRun(ledger, now),
+	}
+	if st.LastRun.IsZero() && ledger != nil {
+		st.LastRun, _ = ledger.LastRun(st.ID)
+	}
+	if e.running {
+		st.State = TaskRunning
+	} else if e.queued != "" {
+		st.State = TaskQueued
+		st.QueuedOn = e.queued
+	} else if e.paused {
+		st.State = TaskPaused
+	}
+	return st
+}
+
+func (s *Scheduler) entry(id string) *taskEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tasks[id]
+}
+
+// lookup returns the entries designated by name, either the identifier of
+// an entry or the name of a task to designate all of its entries.
+func (s *Scheduler) lookup(name string) ([]*taskEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret []*taskEntry
+	for _, entry := range s.entries {
+		if entry.unit.schedule.ID == name || entry.unit.schedule.Task == name {
+			ret = append(ret, entry)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTask, name)
+	}
+	return ret, nil
+}
+
+// Tasks returns the status of every task, in the order of the
+// configuration.
+func (s *Scheduler) Tasks() []TaskStatus {
+	s.mu.Lock()
+	entries := s.entries
+	s.mu.Unlock()
+
+	now := time.Now()
+	ret := make([]TaskStatus, 0, len(entries))
+	for _, entry := range entries {
+		ret = append(ret, entry.status(s.ledger, now))
+	}
+	return ret
+}
+
+// RunTask queues an immediate run of the named task, regardless of its
+// schedule or of it being paused.
+func (s *Scheduler) RunTask(name string) error {
+	entries, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	// trigger none of the entries if one of them can't be
+	s.triggerMu.Lock()
+	defer s.triggerMu.Unlock()
+	for _, entry := range entries {
+		if len(entry.trigger) == cap(entry.trigger) {
+			return fmt.Errorf("%s: %w", entry.unit.schedule.ID, ErrAlreadyQueued)
+		}
+	}
+	for _, entry := range entries {
+		entry.trigger <- struct{}{}
+	}
+	return nil
+}
+
+// PauseTask stops the named task from running on its schedule until it is
+// resumed.  A run in progress is not interrupted.
+func (s *Scheduler) PauseTask(name string) error {
+	entries, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entry.setPaused(true)
+	}
+	return nil
+}
+
+func (s *Scheduler) ResumeTask(name string) error {
+	entries, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entry.setPaused(false)
+	}
+	return nil
+}
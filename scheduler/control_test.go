@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cookies"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestScheduler(t *testing.T, config string) *Scheduler {
+	cfg, err := ParseConfigBytes([]byte(config))
+	require.NoError(t, err)
+
+	ctx := appcontext.NewAppContext()
+	ctx.CacheDir = t.TempDir()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+	ctx.SetCookies(cookies.NewManager(t.TempDir()))
+	t.Cleanup(ctx.Cancel)
+
+	return NewScheduler(ctx, cfg)
+}
+
+func TestSchedulerControl(t *testing.T) {
+	s := newTestScheduler(t, `
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        schedule: "@daily"
+        catchup: none
+      check:
+        - path: /
+          interval: 1h
+          catchup: none
+`)
+
+	tasks := s.Tasks()
+	require.Len(t, tasks, 2)
+	require.Equal(t, "nightly/backup", tasks[0].ID)
+	require.Equal(t, TaskIdle, tasks[0].State)
+	require.True(t, tasks[0].LastRun.IsZero())
+	require.False(t, tasks[0].NextRun.IsZero())
+
+	require.ErrorIs(t, s.PauseTask("weekly"), ErrUnknownTask)
+
+	// a task name designates all of its entries
+	require.NoError(t, s.PauseTask("nightly"))
+	for _, task := range s.Tasks() {
+		require.Equal(t, TaskPaused, task.State)
+	}
+	require.NoError(t, s.ResumeTask("nightly/check/0"))
+	tasks = s.Tasks()
+	require.Equal(t, TaskPaused, tasks[0].State)
+	require.Equal(t, TaskIdle, tasks[1].State)
+
+	runs := make(chan struct{})
+	go s.loop(s.entry("nightly/backup"), IntervalSchedule(24*time.Hour), CatchupNone, func() error {
+		runs <- struct{}{}
+		return errors.New("no space left on device")
+	})
+
+	// a paused task still runs on demand
+	require.NoError(t, s.RunTask("nightly/backup"))
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task did not run")
+	}
+
+	require.Eventually(t, func() bool {
+		return s.Tasks()[0].LastResult == "failure"
+	}, 5*time.Second, 10*time.Millisecond)
+	tasks = s.Tasks()
+	require.Equal(t, "no space left on device", tasks[0].LastError)
+	require.False(t, tasks[0].LastRun.IsZero())
+}
+
+func TestSchedulerRunTaskQueued(t *testing.T) {
+	s := newTestScheduler(t, `
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        schedule: "@daily"
+      check:
+        - path: /
+          interval: 1h
+`)
+
+	require.NoError(t, s.RunTask("nightly/check/0"))
+
+	// nothing is triggered when one of the entries is already queued
+	require.ErrorIs(t, s.RunTask("nightly"), ErrAlreadyQueued)
+	require.Len(t, s.entry("nightly/backup").trigger, 0)
+
+	<-s.entry("nightly/check/0").trigger
+	require.NoError(t, s.RunTask("nightly"))
+	require.Len(t, s.entry("nightly/backup").trigger, 1)
+	require.Len(t, s.entry("nightly/check/0").trigger, 1)
+}
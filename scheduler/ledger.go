@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Ledger persists the last time each task ran so that runs missed while
+// the scheduler was stopped, or the host asleep, can be caught up.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+	runs map[string]time.Time
+}
+
+func LedgerPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "scheduler-ledger.json")
+}
+
+// LoadLedger reads the ledger at path.  A missing file yields an empty
+// ledger that will be created on the first recorded run.
+func LoadLedger(path string) (*Ledger, error) {
+	l := &Ledger{
+		path: path,
+		runs: make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return l, err
+	}
+
+	if err := json.Unmarshal(data, &l.runs); err != nil {
+		return l, fmt.Errorf("failed to decode ledger %s: %w", path, err)
+	}
+	return l, nil
+}
+
+func (l *Ledger) LastRun(id string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.runs[id]
+	return t, ok
+}
+
+func (l *Ledger) Record(id string, t time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.runs[id] = t.Round(0)
+
+	data, err := json.Marshal(l.runs)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
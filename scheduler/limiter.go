@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter bounds the number of tasks running at once and keeps tasks from
+// running on a repository while it is under maintenance.
+type limiter struct {
+	mu      sync.Mutex
+	max     int
+	running int
+	repos   map[string]*repoState
+
+	// closed and replaced whenever a task releases what it held.
+	changed chan struct{}
+}
+
+// repoState tracks the tasks holding a repository: any number of tasks may
+// share it, but maintenance needs it for itself.
+type repoState struct {
+	shared    int
+	exclusive bool
+
+	// exclusive requests waiting, which new shared ones queue behind so
+	// that maintenance is not starved.
+	waiting int
+}
+
+func newLimiter(max int) *limiter {
+	return &limiter{
+		max:     max,
+		repos:   make(map[string]*repoState),
+		changed: make(chan struct{}),
+	}
+}
+
+// setMax changes the number of tasks allowed to run at once, zero meaning
+// no limit.
+func (l *limiter) setMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.max = max
+	l.notify()
+}
+
+func (l *limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *limiter) repo(name string) *repoState {
+	r, ok := l.repos[name]
+	if !ok {
+		r = &repoState{}
+		l.repos[name] = r
+	}
+	return r
+}
+
+// blocker returns why the task can't start right now, or the empty string
+// if it can.  Must be called with l.mu held.
+func (l *limiter) blocker(r *repoState, exclusive bool) string {
+	switch {
+	case r.exclusive:
+		return "repository under maintenance"
+	case exclusive && r.shared > 0:
+		return "repository in use"
+	case !exclusive && r.waiting > 0:
+		return "maintenance pending"
+	case l.max > 0 && l.running >= l.max:
+		return "concurrency limit"
+	}
+	return ""
+}
+
+// acquire waits until the task may run on repository, calling queued with
+// the reason whenever it has to wait.  The returned function must be
+// called once the task is over.
+func (l *limiter) acquire(ctx context.Context, repository string, exclusive bool, queued func(string)) (func(), error) {
+	l.mu.Lock()
+	r := l.repo(repository)
+	waiting := false
+	for {
+		reason := l.blocker(r, exclusive)
+		if reason == "" {
+			break
+		}
+		if exclusive && !waiting {
+			waiting = true
+			r.waiting++
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		queued(reason)
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			l.mu.Lock()
+			if waiting {
+				r.waiting--
+				l.notify()
+			}
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		l.mu.Lock()
+	}
+
+	if waiting {
+		r.waiting--
+	}
+	if exclusive {
+		r.exclusive = true
+	} else {
+		r.shared++
+	}
+	l.running++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			if exclusive {
+				r.exclusive = false
+			} else {
+				r.shared--
+			}
+			l.running--
+			l.notify()
+		})
+	}, nil
+}
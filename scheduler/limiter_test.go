@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func noQueue(string) {}
+
+// acquireAsync returns a channel receiving the release function once the
+// limiter lets the task run.
+func acquireAsync(ctx context.Context, l *limiter, repository string, exclusive bool) <-chan func() {
+	ch := make(chan func(), 1)
+	go func() {
+		release, err := l.acquire(ctx, repository, exclusive, noQueue)
+		if err == nil {
+			ch <- release
+		}
+	}()
+	return ch
+}
+
+func requireBlocked(t *testing.T, ch <-chan func()) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatal("task was not queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func requireAcquired(t *testing.T, ch <-chan func()) func() {
+	t.Helper()
+	select {
+	case release := <-ch:
+		return release
+	case <-time.After(5 * time.Second):
+		t.Fatal("task is still queued")
+	}
+	return nil
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	ctx := context.Background()
+	l := newLimiter(2)
+
+	r1, err := l.acquire(ctx, "a", false, noQueue)
+	require.NoError(t, err)
+	r2, err := l.acquire(ctx, "b", false, noQueue)
+	require.NoError(t, err)
+
+	third := acquireAsync(ctx, l, "c", false)
+	requireBlocked(t, third)
+
+	r1()
+	r1() // releasing twice is harmless
+	r3 := requireAcquired(t, third)
+
+	// raising the limit lets queued tasks through
+	fourth := acquireAsync(ctx, l, "d", false)
+	requireBlocked(t, fourth)
+	l.setMax(0)
+	r4 := requireAcquired(t, fourth)
+
+	r2()
+	r3()
+	r4()
+}
+
+func TestLimiterRepositoryLock(t *testing.T) {
+	ctx := context.Background()
+	l := newLimiter(0)
+
+	backup, err := l.acquire(ctx, "repo", false, noQueue)
+	require.NoError(t, err)
+
+	// other repositories are not affected
+	other, err := l.acquire(ctx, "other", true, noQueue)
+	require.NoError(t, err)
+	other()
+
+	var reasons []string
+	maintenance := make(chan func(), 1)
+	go func() {
+		release, _ := l.acquire(ctx, "repo", true, func(reason string) {
+			reasons = append(reasons, reason)
+		})
+		maintenance <- release
+	}()
+	requireBlocked(t, maintenance)
+
+	// new writers queue behind the pending maintenance
+	check := acquireAsync(ctx, l, "repo", false)
+	requireBlocked(t, check)
+
+	backup()
+	release := requireAcquired(t, maintenance)
+	require.Equal(t, []string{"repository in use"}, reasons)
+	requireBlocked(t, check)
+
+	release()
+	requireAcquired(t, check)()
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := newLimiter(0)
+
+	backup, err := l.acquire(context.Background(), "repo", false, noQueue)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.acquire(ctx, "repo", true, noQueue)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	// the cancelled maintenance no longer holds writers back
+	release, err := l.acquire(context.Background(), "repo", false, noQueue)
+	require.NoError(t, err)
+	release()
+	backup()
+}
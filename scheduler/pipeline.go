@@ -0,0 +1,196 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/plakar/agent"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/backup"
+	"github.com/PlakarKorp/plakar/subcommands/check"
+	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/restore"
+	"github.com/PlakarKorp/plakar/subcommands/rm"
+	"github.com/PlakarKorp/plakar/subcommands/sync"
+)
+
+// pipelineState carries the outcome of the steps that already ran.
+type pipelineState struct {
+	failed bool
+
+	// set by a successful backup step so that the following steps
+	// operate on the snapshot it produced rather than on older ones.
+	since time.Time
+}
+
+// shouldRun tells whether a step with the given condition runs after the
+// previous steps of the pipeline.
+func (state *pipelineState) shouldRun(when StepCondition) bool {
+	switch when {
+	case StepAlways:
+		return true
+	case StepOnFailure:
+		return state.failed
+	default:
+		return !state.failed
+	}
+}
+
+func (s *Scheduler) pipelineTask(entry *taskEntry, taskset Task, pipeline PipelineConfig) {
+	s.loop(entry, pipeline.GetSchedule(), pipeline.Catchup, func() error {
+		return s.runPipeline(entry, taskset, pipeline)
+	})
+}
+
+func (s *Scheduler) runPipeline(entry *taskEntry, taskset Task, pipeline PipelineConfig) error {
+	storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+	if err != nil {
+		s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+		return err
+	}
+
+	state := &pipelineState{}
+	for i, step := range pipeline.Steps {
+		if !state.shouldRun(step.When) {
+			s.ctx.GetLogger().Info("pipeline %s: skipping step %d (%s)", taskset.Name, i, step.Kind())
+			continue
+		}
+
+		if err := s.runStep(entry, taskset, step, state, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("pipeline %s: step %d (%s) failed: %s", taskset.Name, i, step.Kind(), err)
+			state.failed = true
+		} else {
+			s.ctx.GetLogger().Info("pipeline %s: step %d (%s) succeeded", taskset.Name, i, step.Kind())
+		}
+	}
+
+	if state.failed {
+		return fmt.Errorf("pipeline %s failed", taskset.Name)
+	}
+	return nil
+}
+
+func (s *Scheduler) runStep(entry *taskEntry, taskset Task, step PipelineStep, state *pipelineState, storeConfig map[string]string) error {
+	switch {
+	case step.Backup != nil:
+		excludes, err := loadExcludes(step.Backup.IgnoreFile, step.Backup.Ignore)
+		if err != nil {
+			return err
+		}
+
+		backupSubcommand := &backup.Backup{}
+		backupSubcommand.Flags = subcommands.AgentSupport
+		backupSubcommand.Silent = true
+		backupSubcommand.Quiet = true
+		backupSubcommand.Job = taskset.Name
+		backupSubcommand.Tags = step.Backup.Tags
+		backupSubcommand.Path = step.Backup.Path
+		backupSubcommand.Excludes = excludes
+		backupSubcommand.OptCheck = step.Backup.Check.Enabled
+		backupSubcommand.Opts = make(map[string]string)
+		backupSubcommand.PreHook = step.Backup.PreHook.Hook()
+		backupSubcommand.PostHook = step.Backup.PostHook.Hook()
+
+		start := time.Now()
+		if err := s.execute(entry, "backup", backupSubcommand, storeConfig); err != nil {
+			return err
+		}
+		state.since = start
+
+		if step.Backup.Policy != nil {
+			return s.prune(entry, taskset.Name, step.Backup.Policy, storeConfig)
+		} else if step.Backup.Retention != 0 {
+			return s.purge(entry, taskset.Name, step.Backup.Retention, storeConfig)
+		}
+		return nil
+
+	case step.Check != nil:
+		checkSubcommand := &check.Check{}
+		checkSubcommand.Flags = subcommands.AgentSupport
+		checkSubcommand.LocateOptions = s.stepLocateOptions(taskset, state, step.Check.Latest)
+		checkSubcommand.Silent = true
+		if step.Check.Path != "" {
+			checkSubcommand.Snapshots = []string{":" + step.Check.Path}
+		}
+		return s.execute(entry, "check", checkSubcommand, storeConfig)
+
+	case step.Restore != nil:
+		restoreSubcommand := &restore.Restore{}
+		restoreSubcommand.Flags = subcommands.AgentSupport
+		restoreSubcommand.OptJob = taskset.Name
+		restoreSubcommand.LocateOptions = s.stepLocateOptions(taskset, state, true)
+		restoreSubcommand.Target = step.Restore.Target
+		restoreSubcommand.Silent = true
+		if step.Restore.Path != "" {
+			restoreSubcommand.Snapshots = []string{":" + step.Restore.Path}
+		}
+		return s.execute(entry, "restore", restoreSubcommand, storeConfig)
+
+	case step.Sync != nil:
+		syncSubcommand := &sync.Sync{}
+		syncSubcommand.Flags = subcommands.AgentSupport
+		syncSubcommand.PeerRepositoryLocation = step.Sync.Peer
+		syncSubcommand.Direction = string(step.Sync.Direction)
+		if !state.since.IsZero() {
+			syncSubcommand.SrcLocateOptions = s.stepLocateOptions(taskset, state, true)
+		}
+		return s.execute(entry, "sync", syncSubcommand, storeConfig)
+
+	case step.Maintenance != nil:
+		maintenanceSubcommand := &maintenance.Maintenance{}
+		maintenanceSubcommand.Flags = subcommands.AgentSupport
+		if err := s.execute(entry, "maintenance", maintenanceSubcommand, storeConfig); err != nil {
+			return err
+		}
+
+		if step.Maintenance.Policy != nil {
+			return s.prune(entry, taskset.Name, step.Maintenance.Policy, storeConfig)
+		} else if step.Maintenance.Retention != 0 {
+			return s.purge(entry, taskset.Name, step.Maintenance.Retention, storeConfig)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no action in step")
+}
+
+// stepLocateOptions selects the snapshots of the task, narrowed down to the
+// one produced by an earlier backup step of the pipeline if there was one.
+func (s *Scheduler) stepLocateOptions(taskset Task, state *pipelineState, latest bool) *locate.LocateOptions {
+	opts := []locate.Option{locate.WithJob(taskset.Name)}
+	if !state.since.IsZero() {
+		opts = append(opts, locate.WithSince(state.since), locate.WithLatest(true))
+	} else {
+		opts = append(opts, locate.WithLatest(latest))
+	}
+	return locate.NewDefaultLocateOptions(opts...)
+}
+
+func (s *Scheduler) purge(entry *taskEntry, job string, retention time.Duration, storeConfig map[string]string) error {
+	rmSubcommand := &rm.Rm{}
+	rmSubcommand.Apply = true
+	rmSubcommand.Flags = subcommands.AgentSupport
+	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions(
+		locate.WithJob(job),
+		locate.WithBefore(time.Now().Add(-retention)),
+	)
+	if err := s.execute(entry, "rm", rmSubcommand, storeConfig); err != nil {
+		return fmt.Errorf("removing obsolete backups: %w", err)
+	}
+	return nil
+}
+
+// execute runs the subcommand through the agent, turning a non-zero exit
+// status into an error.  Its report lists the failed attempts of the task.
+func (s *Scheduler) execute(entry *taskEntry, name string, cmd subcommands.Subcommand, storeConfig map[string]string) error {
+	cmd.SetAttempts(entry.attempts)
+	retval, err := agent.ExecuteRPC(s.ctx, []string{name}, cmd, storeConfig)
+	if err != nil {
+		return err
+	}
+	if retval != 0 {
+		return fmt.Errorf("%s exited with status %d", name, retval)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPipeline(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      pipeline:
+        schedule: "@daily 02:00"
+        steps:
+          - backup:
+              path: /home
+              retention: 168h
+          - check:
+              path: /
+          - sync:
+              peer: "@offsite"
+          - maintenance: {}
+            when: always
+          - restore:
+              target: /tmp/restore-failed
+            when: failure
+`))
+	require.NoError(t, err)
+
+	pipeline := config.Agent.Tasks[0].Pipeline
+	require.NotNil(t, pipeline)
+	require.Len(t, pipeline.Steps, 5)
+
+	var kinds []string
+	for _, step := range pipeline.Steps {
+		kinds = append(kinds, step.Kind())
+	}
+	require.Equal(t, []string{"backup", "check", "sync", "maintenance", "restore"}, kinds)
+	require.Equal(t, 168*time.Hour, pipeline.Steps[0].Backup.Retention)
+	require.Equal(t, StepOnSuccess, pipeline.Steps[1].When)
+	require.Equal(t, SyncDirectionTo, pipeline.Steps[2].Sync.Direction)
+	require.Equal(t, StepAlways, pipeline.Steps[3].When)
+	require.Equal(t, StepOnFailure, pipeline.Steps[4].When)
+
+	schedules := config.Schedules()
+	require.Len(t, schedules, 1)
+	require.Equal(t, "nightly/pipeline", schedules[0].ID)
+	require.Equal(t, "pipeline", schedules[0].Kind)
+}
+
+func TestConfigPipelineInvalid(t *testing.T) {
+	for _, steps := range []string{
+		"[]",
+		"[{}]",
+		"[{backup: {path: /home}, check: {path: /}}]",
+		"[{backup: {path: /home}, when: sometimes}]",
+		"[{restore: {path: /}}]",
+	} {
+		_, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      pipeline:
+        interval: 24h
+        steps: ` + steps + `
+`))
+		require.Error(t, err, steps)
+	}
+}
+
+func TestPipelineShouldRun(t *testing.T) {
+	state := &pipelineState{}
+	require.True(t, state.shouldRun(StepOnSuccess))
+	require.False(t, state.shouldRun(StepOnFailure))
+	require.True(t, state.shouldRun(StepAlways))
+
+	state.failed = true
+	require.False(t, state.shouldRun(StepOnSuccess))
+	require.True(t, state.shouldRun(StepOnFailure))
+	require.True(t, state.shouldRun(StepAlways))
+}
@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// policyRule is an inline rule of a policy and the period of the locate
+// options it sets.
+type policyRule struct {
+	period    *locate.LocatePeriod
+	keep, cap int
+}
+
+func (c *PolicyConfig) rules(opts *locate.LocateOptions) []policyRule {
+	return []policyRule{
+		{&opts.Periods.Minute, c.Minutes, c.PerMinute},
+		{&opts.Periods.Hour, c.Hours, c.PerHour},
+		{&opts.Periods.Day, c.Days, c.PerDay},
+		{&opts.Periods.Week, c.Weeks, c.PerWeek},
+		{&opts.Periods.Month, c.Months, c.PerMonth},
+		{&opts.Periods.Year, c.Years, c.PerYear},
+		{&opts.Periods.Monday, c.Mondays, c.PerMonday},
+		{&opts.Periods.Tuesday, c.Tuesdays, c.PerTuesday},
+		{&opts.Periods.Wednesday, c.Wednesdays, c.PerWednesday},
+		{&opts.Periods.Thursday, c.Thursdays, c.PerThursday},
+		{&opts.Periods.Friday, c.Fridays, c.PerFriday},
+		{&opts.Periods.Saturday, c.Saturdays, c.PerSaturday},
+		{&opts.Periods.Sunday, c.Sundays, c.PerSunday},
+	}
+}
+
+// merge sets the periods of opts that have an inline rule.
+func (c *PolicyConfig) merge(opts *locate.LocateOptions) {
+	for _, rule := range c.rules(opts) {
+		if rule.keep != 0 {
+			rule.period.Keep = rule.keep
+		}
+		if rule.cap != 0 {
+			rule.period.Cap = rule.cap
+		}
+	}
+}
+
+// inline returns the locate options of the inline rules alone.
+func (c *PolicyConfig) inline() *locate.LocateOptions {
+	opts := locate.NewDefaultLocateOptions()
+	c.merge(opts)
+	return opts
+}
+
+// LocateOptions returns the locate options of the policy, looking the named
+// policy up in the policies.yml file of configDir.
+func (c *PolicyConfig) LocateOptions(configDir string) (*locate.LocateOptions, error) {
+	opts := locate.NewDefaultLocateOptions()
+	if c.Name != "" {
+		cfg, err := utils.LoadPolicyConfigFile(filepath.Join(configDir, "policies.yml"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load policies config: %w", err)
+		}
+		if !cfg.Has(c.Name) {
+			return nil, fmt.Errorf("policy %q not found", c.Name)
+		}
+		cfg.ApplyConfig(c.Name, opts)
+	}
+	c.merge(opts)
+	return opts, nil
+}
+
+// prune removes the snapshots of job, or of the whole repository if job is
+// empty, that the policy does not keep.
+func (s *Scheduler) prune(entry *taskEntry, job string, policy *PolicyConfig, storeConfig map[string]string) error {
+	opts, err := policy.LocateOptions(s.ctx.ConfigDir)
+	if err != nil {
+		return err
+	}
+	if opts.Filters.Job == "" {
+		opts.Filters.Job = job
+	}
+
+	pruneSubcommand := &prune.Prune{}
+	pruneSubcommand.Flags = subcommands.AgentSupport
+	pruneSubcommand.Apply = !policy.DryRun
+	pruneSubcommand.LocateOptions = opts
+	if err := s.execute(entry, "prune", pruneSubcommand, storeConfig); err != nil {
+		return fmt.Errorf("pruning snapshots: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyLocateOptions(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policies.yml"), []byte(`
+version: v1.0.0
+policies:
+  weekly:
+    filters:
+      tags: [prod]
+    periods:
+      day:
+        keep: 7
+        cap: 2
+      week:
+        keep: 4
+`), 0600))
+
+	policy := &PolicyConfig{Name: "weekly", PerDay: 1, Months: 3}
+	opts, err := policy.LocateOptions(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod"}, opts.Filters.Tags)
+	require.Equal(t, 7, opts.Periods.Day.Keep)
+	require.Equal(t, 1, opts.Periods.Day.Cap)
+	require.Equal(t, 4, opts.Periods.Week.Keep)
+	require.Equal(t, 3, opts.Periods.Month.Keep)
+
+	_, err = (&PolicyConfig{Name: "daily"}).LocateOptions(dir)
+	require.Error(t, err)
+
+	// inline rules need no policies file
+	opts, err = (&PolicyConfig{Hours: 24}).LocateOptions(t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, 24, opts.Periods.Hour.Keep)
+}
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// unit is a scheduled action of the configuration along with everything it
+// depends on, so that reloads can tell which tasks changed.
+type unit struct {
+	schedule TaskSchedule
+	spec     any
+	run      func(s *Scheduler, entry *taskEntry)
+
+	// the repository the task runs on, and whether it needs it for
+	// itself rather than sharing it with other tasks.
+	repository string
+	exclusive  bool
+
+	retry *RetryConfig
+}
+
+// taskSpec is what an action of a task depends on: changing the repository
+// of a task restarts all of its actions.
+type taskSpec struct {
+	Name       string
+	Repository string
+	Retry      *RetryConfig
+	Action     any
+}
+
+func (config *Configuration) units() []unit {
+	var ret []unit
+
+	for i, task := range config.Agent.Maintenance {
+		ret = append(ret, unit{
+			schedule: TaskSchedule{TaskID(task.Repository, "maintenance", i), task.Repository, "maintenance", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+			spec:     task,
+			run:      func(s *Scheduler, entry *taskEntry) { s.maintenanceTask(entry, task) },
+
+			repository: task.Repository,
+			exclusive:  true,
+			retry:      task.Retry,
+		})
+	}
+
+	for _, taskset := range config.Agent.Tasks {
+		spec := func(action any) taskSpec {
+			return taskSpec{taskset.Name, taskset.Repository, taskset.Retry, action}
+		}
+
+		if task := taskset.Backup; task != nil {
+			ret = append(ret, unit{
+				schedule: TaskSchedule{TaskID(taskset.Name, "backup", -1), taskset.Name, "backup", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+				spec:     spec(*task),
+				run:      func(s *Scheduler, entry *taskEntry) { s.backupTask(entry, taskset, *task) },
+
+				repository: taskset.Repository,
+				retry:      taskset.Retry,
+			})
+		}
+		for i, task := range taskset.Check {
+			ret = append(ret, unit{
+				schedule: TaskSchedule{TaskID(taskset.Name, "check", i), taskset.Name, "check", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+				spec:     spec(task),
+				run:      func(s *Scheduler, entry *taskEntry) { s.checkTask(entry, taskset, task) },
+
+				repository: taskset.Repository,
+				retry:      taskset.Retry,
+			})
+		}
+		for i, task := range taskset.Restore {
+			ret = append(ret, unit{
+				schedule: TaskSchedule{TaskID(taskset.Name, "restore", i), taskset.Name, "restore", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+				spec:     spec(task),
+				run:      func(s *Scheduler, entry *taskEntry) { s.restoreTask(entry, taskset, task) },
+
+				repository: taskset.Repository,
+				retry:      taskset.Retry,
+			})
+		}
+		for i, task := range taskset.Sync {
+			ret = append(ret, unit{
+				schedule: TaskSchedule{TaskID(taskset.Name, "sync", i), taskset.Name, "sync", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+				spec:     spec(task),
+				run:      func(s *Scheduler, entry *taskEntry) { s.syncTask(entry, taskset, task) },
+
+				repository: taskset.Repository,
+				retry:      taskset.Retry,
+			})
+		}
+		for i, task := range taskset.Monitor {
+			ret = append(ret, unit{
+				schedule: TaskSchedule{TaskID(taskset.Name, "monitor", i), taskset.Name, "monitor", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+				spec:     spec(task),
+				run:      func(s *Scheduler, entry *taskEntry) { s.monitorTask(entry, taskset, task) },
+
+				repository: taskset.Repository,
+				retry:      taskset.Retry,
+			})
+		}
+		if task := taskset.Pipeline; task != nil {
+			ret = append(ret, unit{
+				schedule: TaskSchedule{TaskID(taskset.Name, "pipeline", -1), taskset.Name, "pipeline", task.GetSchedule(), task.Catchup, config.jitter(task.Jitter)},
+				spec:     spec(*task),
+				run:      func(s *Scheduler, entry *taskEntry) { s.pipelineTask(entry, taskset, *task) },
+
+				repository: taskset.Repository,
+				exclusive:  task.hasMaintenance(),
+				retry:      taskset.Retry,
+			})
+		}
+	}
+
+	return ret
+}
+
+// ConfigDiff lists the identifiers of the tasks affected by a reload.
+type ConfigDiff struct {
+	Added     []string
+	Removed   []string
+	Changed   []string
+	Unchanged []string
+}
+
+func (d ConfigDiff) String() string {
+	var parts []string
+	for _, part := range []struct {
+		what string
+		ids  []string
+	}{
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"changed", d.Changed},
+	} {
+		if len(part.ids) != 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", part.what, strings.Join(part.ids, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "no change"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Reload switches the scheduler to a new configuration.  Only the tasks
+// that were added or changed are (re)started and the removed ones are
+// stopped, others keep their timers.  Runs in progress are never
+// interrupted: a changed task starts over once its current run is over.
+func (s *Scheduler) Reload(config *Configuration) ConfigDiff {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var diff ConfigDiff
+	tasks := make(map[string]*taskEntry)
+	var entries []*taskEntry
+
+	for _, u := range config.units() {
+		id := u.schedule.ID
+
+		old, found := s.tasks[id]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, id)
+			entry := newTaskEntry(s.ctx, u)
+			s.start(entry, nil)
+			tasks[id], entries = entry, append(entries, entry)
+
+		case reflect.DeepEqual(old.unit.spec, u.spec) && old.unit.schedule.Jitter == u.schedule.Jitter:
+			diff.Unchanged = append(diff.Unchanged, id)
+			tasks[id], entries = old, append(entries, old)
+
+		default:
+			diff.Changed = append(diff.Changed, id)
+			old.stop()
+			entry := newTaskEntry(s.ctx, u)
+			entry.inherit(old)
+			s.start(entry, old.done)
+			tasks[id], entries = entry, append(entries, entry)
+		}
+	}
+
+	for _, old := range s.entries {
+		if _, found := tasks[old.unit.schedule.ID]; !found {
+			diff.Removed = append(diff.Removed, old.unit.schedule.ID)
+			old.stop()
+		}
+	}
+
+	s.limiter.setMax(config.Agent.MaxConcurrentTasks)
+	s.config = config
+	s.tasks = tasks
+	s.entries = entries
+	return diff
+}
+
+// start runs the task of the entry once the scheduler runs and after, if
+// not nil, has been closed.  Must be called with s.mu held.
+func (s *Scheduler) start(entry *taskEntry, after <-chan struct{}) {
+	if !s.running {
+		return
+	}
+
+	go func() {
+		defer close(entry.done)
+		if after != nil {
+			select {
+			case <-after:
+			case <-entry.ctx.Done():
+				return
+			}
+		}
+		entry.unit.run(s, entry)
+	}()
+}
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerReload(t *testing.T) {
+	s := newTestScheduler(t, `
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        interval: 24h
+      check:
+        - path: /
+          interval: 1h
+    - name: offsite
+      repository: /var/backups
+      sync:
+        - peer: "@offsite"
+          interval: 24h
+`)
+
+	require.NoError(t, s.PauseTask("nightly/check/0"))
+	backup := s.entry("nightly/backup")
+	check := s.entry("nightly/check/0")
+	offsite := s.entry("offsite/sync/0")
+
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        interval: 24h
+      check:
+        - path: /
+          interval: 2h
+      restore:
+        - path: /etc
+          target: /tmp/restore
+          interval: 168h
+`))
+	require.NoError(t, err)
+
+	diff := s.Reload(config)
+	require.Equal(t, []string{"nightly/restore/0"}, diff.Added)
+	require.Equal(t, []string{"offsite/sync/0"}, diff.Removed)
+	require.Equal(t, []string{"nightly/check/0"}, diff.Changed)
+	require.Equal(t, []string{"nightly/backup"}, diff.Unchanged)
+
+	// unchanged tasks are left alone, the others are stopped
+	require.Same(t, backup, s.entry("nightly/backup"))
+	require.NoError(t, backup.ctx.Err())
+	require.Error(t, check.ctx.Err())
+	require.Error(t, offsite.ctx.Err())
+	require.Nil(t, s.entry("offsite/sync/0"))
+
+	// a changed task stays paused
+	tasks := s.Tasks()
+	require.Len(t, tasks, 3)
+	require.Equal(t, "nightly/check/0", tasks[1].ID)
+	require.Equal(t, TaskPaused, tasks[1].State)
+	require.Equal(t, "@every 2h0m0s", tasks[1].Schedule)
+
+	require.Equal(t, "no change", s.Reload(config).String())
+}
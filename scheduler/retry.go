@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRetryAttempts   = 3
+	DefaultRetryBackoff    = time.Minute
+	DefaultRetryMaxBackoff = time.Hour
+)
+
+// DefaultRetryClasses are the errors retried when none are configured:
+// those that are likely to go away by themselves.
+var DefaultRetryClasses = []ErrorClass{ErrorStorage, ErrorLock}
+
+// attempts returns the number of attempts at a run, one if the task is
+// not retried.
+func (c *RetryConfig) attempts() int {
+	if c == nil {
+		return 1
+	}
+	if c.Attempts == 0 {
+		return DefaultRetryAttempts
+	}
+	return c.Attempts
+}
+
+// backoff returns the delay before the attempt following the nth one,
+// doubling after each attempt up to the maximum.
+func (c *RetryConfig) backoff(n int) time.Duration {
+	delay, max := c.Backoff, c.MaxBackoff
+	if delay == 0 {
+		delay = DefaultRetryBackoff
+	}
+	if max == 0 {
+		max = DefaultRetryMaxBackoff
+	}
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
+
+// retries tells whether err is worth another attempt.
+func (c *RetryConfig) retries(err error) bool {
+	classes := c.On
+	if len(classes) == 0 {
+		classes = DefaultRetryClasses
+	}
+	class := classifyError(err)
+	for _, want := range classes {
+		if want == ErrorAny || want == class {
+			return true
+		}
+	}
+	return false
+}
+
+// errorPatterns tell the class of an error.  Errors come back from the
+// agent as mere text, hence the matching on their message.
+var errorPatterns = []struct {
+	class   ErrorClass
+	pattern string
+}{
+	{ErrorLock, "already locked"},
+	{ErrorStorage, "failed to open storage"},
+	{ErrorStorage, "failed to open raw storage"},
+	{ErrorStorage, "failed to open repository"},
+	{ErrorStorage, "connection refused"},
+	{ErrorStorage, "connection reset"},
+	{ErrorStorage, "no such host"},
+	{ErrorStorage, "network is unreachable"},
+	{ErrorStorage, "i/o timeout"},
+}
+
+// classifyError returns the class of err, or the empty string if it
+// belongs to none.
+func classifyError(err error) ErrorClass {
+	msg := strings.ToLower(err.Error())
+	for _, p := range errorPatterns {
+		if strings.Contains(msg, p.pattern) {
+			return p.class
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	retry := &RetryConfig{Backoff: time.Minute, MaxBackoff: 5 * time.Minute}
+	require.Equal(t, time.Minute, retry.backoff(1))
+	require.Equal(t, 2*time.Minute, retry.backoff(2))
+	require.Equal(t, 4*time.Minute, retry.backoff(3))
+	require.Equal(t, 5*time.Minute, retry.backoff(4))
+	require.Equal(t, 5*time.Minute, retry.backoff(100))
+
+	var none *RetryConfig
+	require.Equal(t, 1, none.attempts())
+	require.Equal(t, DefaultRetryAttempts, (&RetryConfig{}).attempts())
+	require.Equal(t, DefaultRetryBackoff, (&RetryConfig{}).backoff(1))
+}
+
+func TestRetryClasses(t *testing.T) {
+	storage := errors.New("failed to open storage: dial tcp 10.0.0.1:22: connect: connection refused")
+	lock := errors.New("Can't take exclusive lock, repository is already locked")
+	other := errors.New("no space left on device")
+
+	require.Equal(t, ErrorStorage, classifyError(storage))
+	require.Equal(t, ErrorLock, classifyError(lock))
+	require.Equal(t, ErrorClass(""), classifyError(other))
+
+	retry := &RetryConfig{}
+	require.True(t, retry.retries(storage))
+	require.True(t, retry.retries(lock))
+	require.False(t, retry.retries(other))
+
+	retry = &RetryConfig{On: []ErrorClass{ErrorLock}}
+	require.False(t, retry.retries(storage))
+
+	retry = &RetryConfig{On: []ErrorClass{ErrorAny}}
+	require.True(t, retry.retries(other))
+}
+
+func TestRetryRun(t *testing.T) {
+	s := newTestScheduler(t, `
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      retry:
+        attempts: 3
+        backoff: 10ms
+      backup:
+        path: /home
+        interval: 24h
+`)
+	entry := s.entry("nightly/backup")
+
+	var calls int
+	require.True(t, s.run(entry, func() error {
+		// the commands report the failed attempts
+		require.Len(t, entry.attempts, calls)
+		calls++
+		if calls < 2 {
+			return errors.New("failed to open storage: i/o timeout")
+		}
+		return nil
+	}))
+	require.Equal(t, 2, calls)
+	require.Equal(t, "success", entry.status(s.ledger, time.Now()).LastResult)
+
+	// errors of other classes are not retried
+	calls = 0
+	require.True(t, s.run(entry, func() error {
+		calls++
+		return errors.New("no space left on device")
+	}))
+	require.Equal(t, 1, calls)
+	require.Equal(t, "failure", entry.status(s.ledger, time.Now()).LastResult)
+
+	// nor is a task past its last attempt
+	calls = 0
+	require.True(t, s.run(entry, func() error {
+		calls++
+		return errors.New("repository is already locked")
+	}))
+	require.Equal(t, 3, calls)
+	require.Len(t, entry.attempts, 2)
+	require.Equal(t, 2, entry.attempts[1].Attempt)
+	require.Equal(t, "repository is already locked", entry.attempts[1].ErrorMessage)
+}
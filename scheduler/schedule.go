@@ -0,0 +1,298 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// Schedule computes the next fire time of a task.  It is either a fixed
+// interval, as set by the "interval" field, or a calendar specification as
+// set by the "schedule" field:
+//
+//   - a five-field cron expression, i.e. "30 2 * * 1-5";
+//   - a named calendar, optionally followed by a time of day, i.e.
+//     "@daily", "@weekdays 02:30", "@weekends 10:00";
+//   - "@every <duration>".
+//
+// Calendar specifications may be prefixed by "TZ=<zone>" or
+// "CRON_TZ=<zone>" to be evaluated in a time zone other than the local one.
+type Schedule struct {
+	spec     string
+	location *time.Location
+	every    time.Duration
+
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type scheduleField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = scheduleField{0, 59, nil}
+	hourField   = scheduleField{0, 23, nil}
+	domField    = scheduleField{1, 31, nil}
+	monthField  = scheduleField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = scheduleField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// calendars maps the named calendars to their cron equivalent, with the
+// minute and hour fields left out so that a time of day can be given.
+var calendars = map[string]string{
+	"@yearly":   "1 1 *",
+	"@annually": "1 1 *",
+	"@monthly":  "1 * *",
+	"@weekly":   "* * 0",
+	"@daily":    "* * *",
+	"@midnight": "* * *",
+	"@weekdays": "* * 1-5",
+	"@weekends": "* * 0,6",
+}
+
+func IntervalSchedule(interval time.Duration) *Schedule {
+	return &Schedule{
+		spec:  "@every " + interval.String(),
+		every: interval,
+	}
+}
+
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{
+		spec:     strings.TrimSpace(spec),
+		location: time.Local,
+	}
+
+	fields := strings.Fields(s.spec)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		_, zone, _ := strings.Cut(fields[0], "=")
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", zone, err)
+		}
+		s.location = loc
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if fields[0] == "@every" {
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid schedule %q: expected @every <duration>", spec)
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: duration must be positive", spec)
+		}
+		s.every = d
+		return s, nil
+	}
+
+	if fields[0] == "@hourly" {
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("invalid schedule %q: @hourly takes no time of day", spec)
+		}
+		fields = []string{"0", "*", "*", "*", "*"}
+	} else if calendar, ok := calendars[fields[0]]; ok {
+		minute, hour := "0", "0"
+		switch len(fields) {
+		case 1:
+		case 2:
+			h, m, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid time of day %q: expected HH:MM", fields[1])
+			}
+			hour, minute = h, m
+		default:
+			return nil, fmt.Errorf("invalid schedule %q: too many fields", spec)
+		}
+		fields = append([]string{minute, hour}, strings.Fields(calendar)...)
+	} else if strings.HasPrefix(fields[0], "@") {
+		return nil, fmt.Errorf("unknown calendar %q", fields[0])
+	}
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid minute %q: %w", fields[0], err)
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid hour %q: %w", fields[1], err)
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("invalid day of month %q: %w", fields[2], err)
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid month %q: %w", fields[3], err)
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("invalid day of week %q: %w", fields[4], err)
+	}
+
+	// sunday can be written as either 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: never fires", spec)
+	}
+
+	return s, nil
+}
+
+func (f scheduleField) value(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("not a number: %q", s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%d out of range [%d-%d]", n, f.min, f.max)
+	}
+	return n, nil
+}
+
+func (f scheduleField) parse(expr string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(expr, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+
+		var lo, hi int
+		if rng == "*" {
+			lo, hi = f.min, f.max
+		} else if first, last, ok := strings.Cut(rng, "-"); ok {
+			var err error
+			if lo, err = f.value(first); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(last); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		} else {
+			var err error
+			if lo, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the first fire time strictly after t, or the zero time if
+// the schedule never fires again.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every != 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.In(s.location)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, s.location).Add(time.Minute)
+
+	// give up after a few years, which is only reached by schedules
+	// such as "0 0 30 2 *" that can never match.
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchDay follows the cron semantics: when both the day of month and the
+// day of week are restricted, a day matching either of them is selected.
+func (s *Schedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// ScheduleDecodeHook is a mapstructure decode hook turning the "schedule"
+// string of a task into a parsed Schedule.
+func ScheduleDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(Schedule{}) {
+			s, err := ParseSchedule(data.(string))
+			if err != nil {
+				return nil, err
+			}
+			return *s, nil
+		}
+		return data, nil
+	}
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleNext(t *testing.T) {
+	utc := time.UTC
+	base := time.Date(2025, time.March, 14, 10, 17, 42, 0, utc) // a friday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"30 2 * * *", time.Date(2025, time.March, 15, 2, 30, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2025, time.March, 14, 10, 30, 0, 0, utc)},
+		{"0 9-17 * * 1-5", time.Date(2025, time.March, 14, 11, 0, 0, 0, utc)},
+		{"0 0 1 * *", time.Date(2025, time.April, 1, 0, 0, 0, 0, utc)},
+		{"0 0 * * sun", time.Date(2025, time.March, 16, 0, 0, 0, 0, utc)},
+		{"0 0 * * 7", time.Date(2025, time.March, 16, 0, 0, 0, 0, utc)},
+		{"0 0 13 * 5", time.Date(2025, time.March, 21, 0, 0, 0, 0, utc)},
+		{"0 0 29 feb *", time.Date(2028, time.February, 29, 0, 0, 0, 0, utc)},
+		{"@hourly", time.Date(2025, time.March, 14, 11, 0, 0, 0, utc)},
+		{"@daily", time.Date(2025, time.March, 15, 0, 0, 0, 0, utc)},
+		{"@weekdays 02:30", time.Date(2025, time.March, 17, 2, 30, 0, 0, utc)},
+		{"@weekends 10:00", time.Date(2025, time.March, 15, 10, 0, 0, 0, utc)},
+		{"@every 90m", base.Add(90 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule("TZ=UTC " + tt.spec)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, s.Next(base).In(utc))
+		})
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no timezone database available")
+	}
+
+	s, err := ParseSchedule("CRON_TZ=Europe/Paris 30 2 * * *")
+	require.NoError(t, err)
+
+	base := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2025, time.June, 2, 2, 30, 0, 0, paris), s.Next(base))
+
+	// 02:30 does not exist on the night of the switch to summer time
+	base = time.Date(2025, time.March, 29, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2025, time.March, 31, 2, 30, 0, 0, paris), s.Next(base))
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"0 0 30 feb *",
+		"@fortnightly",
+		"@daily 2h30",
+		"@every",
+		"@every -1h",
+		"TZ=Nowhere/Land @daily",
+	} {
+		_, err := ParseSchedule(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestConfigSchedule(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        schedule: "TZ=UTC @weekdays 02:30"
+      check:
+        - path: /
+          interval: 1h
+`))
+	require.NoError(t, err)
+
+	schedules := config.Schedules()
+	require.Len(t, schedules, 2)
+	require.Equal(t, "backup", schedules[0].Kind)
+	require.Equal(t, "TZ=UTC @weekdays 02:30", schedules[0].Schedule.String())
+	require.Equal(t, "check", schedules[1].Kind)
+	require.Equal(t, "@every 1h0m0s", schedules[1].Schedule.String())
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+        interval: 1h
+        schedule: "@daily"
+`))
+	require.Error(t, err)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: nightly
+      repository: /var/backups
+      backup:
+        path: /home
+`))
+	require.Error(t, err)
+}
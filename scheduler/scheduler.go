@@ -9,10 +9,19 @@ import (
 )
 
 type Scheduler struct {
-	config   *Configuration
-	ctx      *appcontext.AppContext
-	wg       sync.WaitGroup
-	reporter *reporting.Reporter
+	config  *Configuration
+	ctx     *appcontext.AppContext
+	wg      sync.WaitGroup
+	ledger  *Ledger
+	limiter *limiter
+
+	// serializes the runs requested through the control socket.
+	triggerMu sync.Mutex
+
+	mu      sync.Mutex
+	running bool
+	tasks   map[string]*taskEntry
+	entries []*taskEntry
 }
 
 func stringToDuration(s string) (time.Duration, error) {
@@ -24,38 +33,41 @@ func stringToDuration(s string) (time.Duration, error) {
 }
 
 func NewScheduler(ctx *appcontext.AppContext, config *Configuration) *Scheduler {
-	return &Scheduler{
-		ctx:    ctx,
-		config: config,
-		wg:     sync.WaitGroup{},
+	s := &Scheduler{
+		ctx:     ctx,
+		config:  config,
+		wg:      sync.WaitGroup{},
+		tasks:   make(map[string]*taskEntry),
+		limiter: newLimiter(config.Agent.MaxConcurrentTasks),
 	}
-}
-
-func (s *Scheduler) Run() {
-	s.reporter = reporting.NewReporter(s.ctx)
 
-	for _, cleanupCfg := range s.config.Agent.Maintenance {
-		go s.maintenanceTask(cleanupCfg)
+	ledger, err := LoadLedger(LedgerPath(ctx.CacheDir))
+	if err != nil {
+		ctx.GetLogger().Warn("could not load the scheduler ledger, missed runs will not be caught up: %s", err)
 	}
+	s.ledger = ledger
 
-	for _, tasksetCfg := range s.config.Agent.Tasks {
-		if tasksetCfg.Backup != nil {
-			go s.backupTask(tasksetCfg, *tasksetCfg.Backup)
-		}
-
-		for _, checkCfg := range tasksetCfg.Check {
-			go s.checkTask(tasksetCfg, checkCfg)
-		}
+	for _, u := range config.units() {
+		entry := newTaskEntry(ctx, u)
+		s.tasks[u.schedule.ID] = entry
+		s.entries = append(s.entries, entry)
+	}
+	return s
+}
 
-		for _, restoreCfg := range tasksetCfg.Restore {
-			go s.restoreTask(tasksetCfg, restoreCfg)
-		}
+func (s *Scheduler) Run() {
+	// the reports of the tasks are sent by the agent running them, this
+	// one only delivers the reports spooled before a restart.
+	reporter := reporting.NewReporter(s.ctx)
+	reporter.DeliverSpooled()
 
-		for _, syncCfg := range tasksetCfg.Sync {
-			go s.syncTask(tasksetCfg, syncCfg)
-		}
+	s.mu.Lock()
+	s.running = true
+	for _, entry := range s.entries {
+		s.start(entry, nil)
 	}
+	s.mu.Unlock()
 
 	<-s.ctx.Done()
-	s.reporter.StopAndWait()
+	reporter.StopAndWait()
 }
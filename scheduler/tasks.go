@@ -1,20 +1,119 @@
 package scheduler
 
 import (
+	"fmt"
+	"math/rand/v2"
 	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
-	"github.com/PlakarKorp/plakar/agent"
+	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/monitor"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
 	"github.com/PlakarKorp/plakar/subcommands/sync"
 )
 
-func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
+// loadExcludes returns the patterns of the ignore file followed by the
+// inline ones.
+func loadExcludes(ignoreFile string, ignore []string) ([]string, error) {
+	var excludes []string
+	if ignoreFile != "" {
+		lines, err := backup.LoadIgnoreFile(ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, lines...)
+	}
+	return append(excludes, ignore...), nil
+}
+
+// loop runs the task on its schedule, or when triggered through the
+// control socket, until the scheduler stops.
+func (s *Scheduler) loop(entry *taskEntry, schedule *Schedule, catchup CatchupPolicy, run func() error) {
+	id := entry.unit.schedule.ID
+	timer := s.newTimer(entry, schedule, catchup)
+
+	tick := timer.C()
+	for {
+		select {
+		case <-entry.ctx.Done():
+			return
+		case <-tick:
+			tick = nil
+			if entry.isPaused() {
+				s.ctx.GetLogger().Info("%s: paused, skipping run", id)
+				tick = timer.C()
+				continue
+			}
+
+			if jitter := entry.unit.schedule.Jitter; jitter > 0 {
+				entry.setQueued("jitter")
+				select {
+				case <-entry.ctx.Done():
+					return
+				case <-time.After(rand.N(jitter)):
+				}
+			}
+		case <-entry.trigger:
+			s.ctx.GetLogger().Info("%s: run requested", id)
+		}
+
+		if !s.run(entry, run) {
+			return
+		}
+
+		if tick == nil {
+			tick = timer.C()
+		}
+	}
+}
+
+// run runs the task once it may, retrying it on failure as configured.  The
+// failed attempts are reported along with the commands of the next one.  It
+// returns false if the task was stopped meanwhile.
+func (s *Scheduler) run(entry *taskEntry, run func() error) bool {
+	id := entry.unit.schedule.ID
+	retry := entry.unit.retry
+	attempts := retry.attempts()
+
+	entry.attempts = nil
+	for attempt := 1; ; attempt++ {
+		release, err := s.limiter.acquire(entry.ctx, entry.unit.repository, entry.unit.exclusive, entry.setQueued)
+		if err != nil {
+			entry.setQueued("")
+			return false
+		}
+		entry.started()
+		start := time.Now()
+		err = run()
+		release()
+		entry.finished(err)
+
+		if err == nil {
+			return true
+		}
+		if attempt == attempts || !retry.retries(err) {
+			return true
+		}
+		entry.attempts = append(entry.attempts, reporting.NewAttempt(attempt, start, err))
+
+		delay := retry.backoff(attempt)
+		s.ctx.GetLogger().Warn("%s: attempt %d/%d failed, retrying in %s: %s", id, attempt, attempts, delay, err)
+		entry.setQueued(fmt.Sprintf("retry %d/%d", attempt+1, attempts))
+		select {
+		case <-entry.ctx.Done():
+			entry.setQueued("")
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *Scheduler) backupTask(entry *taskEntry, taskset Task, task BackupConfig) {
 	backupSubcommand := &backup.Backup{}
 	backupSubcommand.Flags = subcommands.AgentSupport
 	backupSubcommand.Silent = true
@@ -22,6 +121,8 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
 	backupSubcommand.Path = task.Path
 	backupSubcommand.Quiet = true
 	backupSubcommand.Opts = make(map[string]string)
+	backupSubcommand.PreHook = task.PreHook.Hook()
+	backupSubcommand.PostHook = task.PostHook.Hook()
 	if task.Check.Enabled {
 		backupSubcommand.OptCheck = true
 	}
@@ -31,52 +132,42 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
 	rmSubcommand.Flags = subcommands.AgentSupport
 	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions(locate.WithJob(task.Name))
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
+	s.loop(entry, task.GetSchedule(), task.Catchup, func() error {
+		excludes, err := loadExcludes(task.IgnoreFile, task.Ignore)
+		if err != nil {
+			s.ctx.GetLogger().Error("Failed to load ignore file: %s", err)
+			return err
+		}
+		backupSubcommand.Excludes = excludes
 
-			var excludes []string
-			if task.IgnoreFile != "" {
-				lines, err := backup.LoadIgnoreFile(task.IgnoreFile)
-				if err != nil {
-					s.ctx.GetLogger().Error("Failed to load ignore file: %s", err)
-					continue
-				}
-				for _, line := range lines {
-					excludes = append(excludes, line)
-				}
-			}
-			for _, line := range task.Ignore {
-				excludes = append(excludes, line)
-			}
-			backupSubcommand.Excludes = excludes
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return err
+		}
 
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+		if err := s.execute(entry, "backup", backupSubcommand, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("Error creating backup: %s", err)
+			return err
+		}
 
-			if retval, err := agent.ExecuteRPC(s.ctx, []string{"backup"}, backupSubcommand, storeConfig); err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error creating backup: %s", err)
-				continue
+		if task.Policy != nil {
+			if err := s.prune(entry, taskset.Name, task.Policy, storeConfig); err != nil {
+				s.ctx.GetLogger().Error("Error pruning backups: %s", err)
+				return err
 			}
-
-			if task.Retention != 0 {
-				rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
-				if retval, err := agent.ExecuteRPC(s.ctx, []string{"rm"}, rmSubcommand, storeConfig); err != nil || retval != 0 {
-					s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
-					continue
-				}
+		} else if task.Retention != 0 {
+			rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
+			if err := s.execute(entry, "rm", rmSubcommand, storeConfig); err != nil {
+				s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
+				return err
 			}
 		}
-	}
+		return nil
+	})
 }
 
-func (s *Scheduler) checkTask(taskset Task, task CheckConfig) {
+func (s *Scheduler) checkTask(entry *taskEntry, taskset Task, task CheckConfig) {
 	checkSubcommand := &check.Check{}
 	checkSubcommand.Flags = subcommands.AgentSupport
 	checkSubcommand.LocateOptions = locate.NewDefaultLocateOptions(
@@ -88,27 +179,22 @@ func (s *Scheduler) checkTask(taskset Task, task CheckConfig) {
 		checkSubcommand.Snapshots = []string{":" + task.Path}
 	}
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	s.loop(entry, task.GetSchedule(), task.Catchup, func() error {
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return err
+		}
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"check"}, checkSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing check: %s", err)
-			}
+		if err := s.execute(entry, "check", checkSubcommand, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("Error executing check: %s", err)
+			return err
 		}
-	}
+		return nil
+	})
 }
 
-func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) {
+func (s *Scheduler) restoreTask(entry *taskEntry, taskset Task, task RestoreConfig) {
 	restoreSubcommand := &restore.Restore{}
 	restoreSubcommand.Flags = subcommands.AgentSupport
 	restoreSubcommand.OptJob = taskset.Name
@@ -118,27 +204,22 @@ func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) {
 		restoreSubcommand.Snapshots = []string{":" + task.Path}
 	}
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	s.loop(entry, task.GetSchedule(), task.Catchup, func() error {
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return err
+		}
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"restore"}, restoreSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing restore: %s", err)
-			}
+		if err := s.execute(entry, "restore", restoreSubcommand, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("Error executing restore: %s", err)
+			return err
 		}
-	}
+		return nil
+	})
 }
 
-func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
+func (s *Scheduler) syncTask(entry *taskEntry, taskset Task, task SyncConfig) {
 	syncSubcommand := &sync.Sync{}
 	syncSubcommand.Flags = subcommands.AgentSupport
 	syncSubcommand.PeerRepositoryLocation = task.Peer
@@ -161,29 +242,50 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
 	//	syncSubcommand.Target = task.Target
 	//	syncSubcommand.Silent = true
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	s.loop(entry, task.GetSchedule(), task.Catchup, func() error {
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return err
+		}
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"sync"}, syncSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("sync: %s", err)
-			} else {
-				s.ctx.GetLogger().Info("sync: synchronization succeeded")
-			}
+		if err := s.execute(entry, "sync", syncSubcommand, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("sync: %s", err)
+			return err
 		}
+		s.ctx.GetLogger().Info("sync: synchronization succeeded")
+		return nil
+	})
+}
+
+func (s *Scheduler) monitorTask(entry *taskEntry, taskset Task, task MonitorConfig) {
+	monitorSubcommand := &monitor.Monitor{}
+	monitorSubcommand.Flags = subcommands.AgentSupport
+	monitorSubcommand.Jobs = []string{taskset.Name}
+	monitorSubcommand.Silent = true
+	monitorSubcommand.Thresholds = monitor.Thresholds{
+		MaxAge:      task.MaxAge,
+		SizeChange:  task.SizeChange,
+		FilesChange: task.FilesChange,
+		DedupDrop:   task.DedupDrop,
 	}
+
+	s.loop(entry, task.GetSchedule(), task.Catchup, func() error {
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return err
+		}
+
+		if err := s.execute(entry, "monitor", monitorSubcommand, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("Error executing monitor: %s", err)
+			return err
+		}
+		return nil
+	})
 }
 
-func (s *Scheduler) maintenanceTask(task MaintenanceConfig) {
+func (s *Scheduler) maintenanceTask(entry *taskEntry, task MaintenanceConfig) {
 	maintenanceSubcommand := &maintenance.Maintenance{}
 	maintenanceSubcommand.Flags = subcommands.AgentSupport
 	rmSubcommand := &rm.Rm{}
@@ -191,36 +293,33 @@ func (s *Scheduler) maintenanceTask(task MaintenanceConfig) {
 	rmSubcommand.Flags = subcommands.AgentSupport
 	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions(locate.WithJob("maintenance"))
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(task.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	s.loop(entry, task.GetSchedule(), task.Catchup, func() error {
+		storeConfig, err := s.ctx.Config.GetRepository(task.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return err
+		}
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"maintenance"}, maintenanceSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing maintenance: %s", err)
-				continue
-			} else {
-				s.ctx.GetLogger().Info("maintenance of repository %s succeeded", task.Repository)
-			}
+		if err := s.execute(entry, "maintenance", maintenanceSubcommand, storeConfig); err != nil {
+			s.ctx.GetLogger().Error("Error executing maintenance: %s", err)
+			return err
+		}
+		s.ctx.GetLogger().Info("maintenance of repository %s succeeded", task.Repository)
 
-			if task.Retention != 0 {
-				rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
-				retval, err := agent.ExecuteRPC(s.ctx, []string{"rm"}, rmSubcommand, storeConfig)
-				if err != nil || retval != 0 {
-					s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
-					continue
-				} else {
-					s.ctx.GetLogger().Info("Retention purge succeeded")
-				}
+		if task.Policy != nil {
+			if err := s.prune(entry, "", task.Policy, storeConfig); err != nil {
+				s.ctx.GetLogger().Error("Error pruning backups: %s", err)
+				return err
+			}
+			s.ctx.GetLogger().Info("Retention purge succeeded")
+		} else if task.Retention != 0 {
+			rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
+			if err := s.execute(entry, "rm", rmSubcommand, storeConfig); err != nil {
+				s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
+				return err
 			}
+			s.ctx.GetLogger().Info("Retention purge succeeded")
 		}
-	}
+		return nil
+	})
 }
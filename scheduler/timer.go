@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+const (
+	// the timer re-checks the wall clock at least this often, so that
+	// deadlines passed while the host was asleep are noticed on wakeup.
+	timerPollInterval = time.Minute
+
+	// upper bound on the runs replayed by the "all" catchup policy.
+	maxCatchupRuns = 100
+)
+
+type taskTimer struct {
+	ctx      *appcontext.AppContext
+	id       string
+	schedule *Schedule
+	catchup  CatchupPolicy
+	ledger   *Ledger
+	last     time.Time
+	pending  int
+
+	// closed when the task stops, nil if it never does.
+	done <-chan struct{}
+
+	// tells whether the task is paused, whose runs are then skipped
+	// rather than recorded, nil if it never is.
+	paused func() bool
+}
+
+func newTaskTimer(ctx *appcontext.AppContext, ledger *Ledger, id string, schedule *Schedule, catchup CatchupPolicy) *taskTimer {
+	if catchup == "" {
+		catchup = CatchupOnce
+	}
+
+	t := &taskTimer{
+		ctx:      ctx,
+		id:       id,
+		schedule: schedule,
+		catchup:  catchup,
+		ledger:   ledger,
+	}
+	t.last, _ = ledger.LastRun(id)
+	return t
+}
+
+func (s *Scheduler) newTimer(entry *taskEntry, schedule *Schedule, catchup CatchupPolicy) *taskTimer {
+	t := newTaskTimer(s.ctx, s.ledger, entry.unit.schedule.ID, schedule, catchup)
+	t.done = entry.ctx.Done()
+	t.paused = entry.isPaused
+	return t
+}
+
+// NextRun returns when the task will run next, given the runs recorded in
+// the ledger and its catchup policy.
+func (ts TaskSchedule) NextRun(ledger *Ledger, now time.Time) time.Time {
+	return newTaskTimer(nil, ledger, ts.ID, ts.Schedule, ts.Catchup).next(now)
+}
+
+// next returns the time of the next run, applying the catchup policy if
+// runs were missed since the last one.
+func (t *taskTimer) next(now time.Time) time.Time {
+	if t.pending > 0 {
+		t.pending--
+		return now
+	}
+
+	if t.last.IsZero() {
+		if t.catchup == CatchupNone {
+			return t.schedule.Next(now).Round(0)
+		}
+		// never ran before, no need to wait
+		return now
+	}
+
+	next := t.schedule.Next(t.last).Round(0)
+	if next.After(now) {
+		return next
+	}
+
+	switch t.catchup {
+	case CatchupNone:
+		return t.schedule.Next(now).Round(0)
+	case CatchupAll:
+		missed := 0
+		for !next.IsZero() && !next.After(now) && missed < maxCatchupRuns {
+			missed++
+			next = t.schedule.Next(next)
+		}
+		t.pending = missed - 1
+	}
+	return now
+}
+
+// C returns a channel delivering the time of the next run, which is also
+// recorded in the ledger as the last run of the task unless it is paused.
+func (t *taskTimer) C() <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	next := t.next(time.Now())
+
+	go func() {
+		for {
+			now := time.Now()
+			if !now.Before(next) {
+				// waking up that late means the host was asleep,
+				// re-evaluate what was missed in the meantime.
+				if now.Sub(next) > timerPollInterval {
+					if n := t.next(now); n.After(now) {
+						next = n
+						continue
+					}
+				}
+
+				t.last = now
+				if t.paused == nil || !t.paused() {
+					if err := t.ledger.Record(t.id, now); err != nil {
+						t.ctx.GetLogger().Warn("failed to record run of %s: %s", t.id, err)
+					}
+				}
+				ch <- now
+				return
+			}
+
+			wait := min(next.Sub(now), timerPollInterval)
+			select {
+			case <-t.done:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+
+	return ch
+}
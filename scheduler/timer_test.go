@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedgerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+
+	ledger, err := LoadLedger(path)
+	require.NoError(t, err)
+
+	_, ok := ledger.LastRun("nightly/backup")
+	require.False(t, ok)
+
+	when := time.Date(2025, time.March, 14, 2, 30, 0, 0, time.UTC)
+	require.NoError(t, ledger.Record("nightly/backup", when))
+
+	ledger, err = LoadLedger(path)
+	require.NoError(t, err)
+
+	last, ok := ledger.LastRun("nightly/backup")
+	require.True(t, ok)
+	require.True(t, when.Equal(last))
+}
+
+func TestTimerCatchup(t *testing.T) {
+	ledger, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	require.NoError(t, err)
+
+	hourly := IntervalSchedule(time.Hour)
+	now := time.Date(2025, time.March, 14, 12, 0, 0, 0, time.UTC)
+
+	// never ran: run right away unless catching up is disabled
+	timer := newTaskTimer(nil, ledger, "task", hourly, "")
+	require.Equal(t, now, timer.next(now))
+	timer = newTaskTimer(nil, ledger, "task", hourly, CatchupNone)
+	require.Equal(t, now.Add(time.Hour), timer.next(now))
+
+	// ran recently: wait for the remainder of the interval
+	require.NoError(t, ledger.Record("task", now.Add(-10*time.Minute)))
+	timer = newTaskTimer(nil, ledger, "task", hourly, CatchupAll)
+	require.Equal(t, now.Add(50*time.Minute), timer.next(now))
+
+	// three runs missed
+	require.NoError(t, ledger.Record("task", now.Add(-3*time.Hour-time.Minute)))
+
+	timer = newTaskTimer(nil, ledger, "task", hourly, CatchupNone)
+	require.Equal(t, now.Add(time.Hour), timer.next(now))
+
+	timer = newTaskTimer(nil, ledger, "task", hourly, CatchupOnce)
+	require.Equal(t, now, timer.next(now))
+
+	timer = newTaskTimer(nil, ledger, "task", hourly, CatchupAll)
+	require.Equal(t, now, timer.next(now))
+	require.Equal(t, 2, timer.pending)
+}
+
+func TestTimerPaused(t *testing.T) {
+	ledger, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	require.NoError(t, err)
+
+	// a paused task never ran, which isn't recorded
+	timer := newTaskTimer(nil, ledger, "task", IntervalSchedule(time.Hour), "")
+	timer.paused = func() bool { return true }
+	select {
+	case <-timer.C():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer did not fire")
+	}
+	_, ok := ledger.LastRun("task")
+	require.False(t, ok)
+
+	timer = newTaskTimer(nil, ledger, "task", IntervalSchedule(time.Hour), "")
+	select {
+	case <-timer.C():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer did not fire")
+	}
+	_, ok = ledger.LastRun("task")
+	require.True(t, ok)
+}
@@ -0,0 +1,225 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// Role is what a client is allowed to do with the store.
+type Role string
+
+const (
+	// RoleReadOnly clients may only read, to restore.
+	RoleReadOnly Role = "read-only"
+
+	// RoleAppendOnly clients may also add states and packfiles and
+	// take locks, to back up, but never delete nor overwrite anything
+	// but the locks they took.
+	RoleAppendOnly Role = "append-only"
+
+	// RoleAdmin clients may do anything, to maintain the store.  They
+	// still can't delete unless the server allows it.
+	RoleAdmin Role = "admin"
+)
+
+func (role Role) valid() bool {
+	switch role {
+	case RoleReadOnly, RoleAppendOnly, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+var (
+	errOverwrite   = errors.New("not allowed to overwrite")
+	errLockRelease = errors.New("not allowed to release the lock of another client")
+)
+
+// role returns the role of the client of r.  The server is all open when it
+// doesn't know its clients, and so are clients authenticated only by their
+// certificate.
+func (s *server) role(r *http.Request) Role {
+	client := ClientFromContext(r.Context())
+	if client == nil || client.Role == "" {
+		return RoleAdmin
+	}
+	return client.Role
+}
+
+// allowed tells whether the client of r may write, which includes taking
+// locks, or delete if del is set.  If not, it answers r.
+func (s *server) allowed(w http.ResponseWriter, r *http.Request, del bool) bool {
+	role := s.role(r)
+	switch {
+	case role == RoleReadOnly:
+		http.Error(w, fmt.Errorf("not allowed to write").Error(), http.StatusForbidden)
+		return false
+	case del && role != RoleAdmin:
+		http.Error(w, fmt.Errorf("not allowed to delete").Error(), http.StatusForbidden)
+		return false
+	case del && s.noDelete:
+		http.Error(w, fmt.Errorf("not allowed to delete").Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// appendOnly tells whether data must be written as mac for the client of
+// r.  Append-only clients may not overwrite an existing object, but may
+// write it again as it is, which is then skipped, so that they can retry.
+func (s *server) appendOnly(r *http.Request, set *objectSet, mac objects.MAC, data io.Reader) (bool, error) {
+	if s.role(r) != RoleAppendOnly {
+		return true, nil
+	}
+
+	exists, err := set.contains(r.Context(), mac)
+	if err != nil || !exists {
+		return true, err
+	}
+
+	rd, err := set.get(r.Context(), mac)
+	if err != nil {
+		return false, err
+	}
+	defer rd.Close()
+	same, err := sameContent(rd, data)
+	if err != nil {
+		return false, err
+	}
+	if !same {
+		return false, errOverwrite
+	}
+	return false, nil
+}
+
+// lockAllowed fails if the client of r may not take the lock mac, or
+// release it if release is set.  Append-only clients may only take new
+// locks, and release the ones they took through this server.
+func (s *server) lockAllowed(r *http.Request, mac objects.MAC, release bool) error {
+	if s.role(r) != RoleAppendOnly {
+		return nil
+	}
+	if release {
+		if owner, ok := s.locks.owner(mac); !ok || owner != ClientFromContext(r.Context()).Name {
+			return errLockRelease
+		}
+		return nil
+	}
+
+	locks, err := s.store.GetLocks(r.Context())
+	if err != nil {
+		return err
+	}
+	if slices.Contains(locks, mac) {
+		return errOverwrite
+	}
+	return nil
+}
+
+// lockOwners are the clients that took the locks through the server.
+type lockOwners struct {
+	mu     sync.Mutex
+	owners map[objects.MAC]string
+}
+
+// taken records that the client of r took the lock mac.
+func (l *lockOwners) taken(r *http.Request, mac objects.MAC) {
+	client := ClientFromContext(r.Context())
+	if client == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.owners[mac] = client.Name
+}
+
+func (l *lockOwners) owner(mac objects.MAC) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	owner, ok := l.owners[mac]
+	return owner, ok
+}
+
+func (l *lockOwners) released(mac objects.MAC) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.owners, mac)
+}
+
+// sameContent tells whether a and b have the same content, without
+// holding either in memory.
+func sameContent(a, b io.Reader) (bool, error) {
+	bufa := make([]byte, 32*1024)
+	bufb := make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(a, bufa)
+		nb, errb := io.ReadFull(b, bufb)
+		if !bytes.Equal(bufa[:na], bufb[:nb]) {
+			return false, nil
+		}
+
+		enda := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		endb := errb == io.EOF || errb == io.ErrUnexpectedEOF
+		if erra != nil && !enda {
+			return false, erra
+		}
+		if errb != nil && !endb {
+			return false, errb
+		}
+		if enda || endb {
+			return enda == endb, nil
+		}
+	}
+}
+
+// objectSet is the set of states or packfiles in the store, loaded when
+// first needed and kept up to date with the writes of the server.  The
+// objects written to the store by others since then are not seen.
+type objectSet struct {
+	list func(context.Context) ([]objects.MAC, error)
+	get  func(context.Context, objects.MAC) (io.ReadCloser, error)
+
+	mu   sync.Mutex
+	macs map[objects.MAC]struct{}
+}
+
+func (set *objectSet) contains(ctx context.Context, mac objects.MAC) (bool, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if set.macs == nil {
+		macs, err := set.list(ctx)
+		if err != nil {
+			return false, err
+		}
+		set.macs = make(map[objects.MAC]struct{}, len(macs))
+		for _, mac := range macs {
+			set.macs[mac] = struct{}{}
+		}
+	}
+
+	_, ok := set.macs[mac]
+	return ok, nil
+}
+
+func (set *objectSet) add(mac objects.MAC) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.macs != nil {
+		set.macs[mac] = struct{}{}
+	}
+}
+
+func (set *objectSet) remove(mac objects.MAC) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	delete(set.macs, mac)
+}
@@ -0,0 +1,185 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHandler(t *testing.T, noDelete bool) http.Handler {
+	store := ptesting.NewMockBackend(map[string]string{"location": "mock:///?behavior=oneState"})
+	require.NoError(t, store.Create(context.Background(), nil))
+
+	opts := &Options{
+		NoDelete: noDelete,
+		Credentials: &Credentials{
+			Version: CREDENTIALS_VERSION,
+			Clients: []Client{
+				{Name: "restore", Role: RoleReadOnly, Token: HashToken("restore")},
+				{Name: "backup", Role: RoleAppendOnly, Token: HashToken("backup")},
+				{Name: "admin", Role: RoleAdmin, Token: HashToken("admin")},
+			},
+		},
+	}
+	s, err := newServer(context.Background(), store, opts)
+	require.NoError(t, err)
+	return s.handler(opts)
+}
+
+// do sends req as token to h, and returns the status code.
+func do(t *testing.T, h http.Handler, token, method, path string, req any) int {
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestReadOnly(t *testing.T) {
+	h := newTestHandler(t, false)
+
+	require.Equal(t, http.StatusOK, do(t, h, "restore", "GET", "/states", network.ReqGetStates{}))
+	require.Equal(t, http.StatusOK, do(t, h, "restore", "GET", "/packfile", network.ReqGetPackfile{MAC: objects.MAC{0x04}}))
+
+	require.Equal(t, http.StatusForbidden, do(t, h, "restore", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x09}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "restore", "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{0x09}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "restore", "DELETE", "/state", network.ReqDeleteState{MAC: objects.MAC{0x01}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "restore", "DELETE", "/packfile", network.ReqDeletePackfile{MAC: objects.MAC{0x04}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "restore", "PUT", "/lock", network.ReqPutLock{Mac: objects.MAC{0x01}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "restore", "DELETE", "/lock", network.ReqDeleteLock{Mac: objects.MAC{0x01}}))
+}
+
+func TestAppendOnly(t *testing.T) {
+	h := newTestHandler(t, false)
+
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "GET", "/states", network.ReqGetStates{}))
+
+	// new objects
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x09}, Data: []byte("state")}))
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{0x09}, Data: []byte("packfile")}))
+
+	// existing objects written again as they are
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x01}}))
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{0x04}, Data: []byte("packfile data")}))
+
+	// existing objects overwritten, including the ones just written
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x01}, Data: []byte("ransom")}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{0x04}, Data: []byte("ransom")}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{0x09}, Data: []byte("ransom")}))
+
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "DELETE", "/state", network.ReqDeleteState{MAC: objects.MAC{0x01}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "DELETE", "/packfile", network.ReqDeletePackfile{MAC: objects.MAC{0x04}}))
+}
+
+// lockStore keeps the locks it is given, unlike the mock.
+type lockStore struct {
+	*ptesting.MockBackend
+
+	mu    sync.Mutex
+	locks map[objects.MAC][]byte
+}
+
+func (s *lockStore) GetLocks(ctx context.Context) ([]objects.MAC, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var macs []objects.MAC
+	for mac := range s.locks {
+		macs = append(macs, mac)
+	}
+	return macs, nil
+}
+
+func (s *lockStore) PutLock(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[mac] = data
+	return int64(len(data)), nil
+}
+
+func (s *lockStore) DeleteLock(ctx context.Context, mac objects.MAC) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locks, mac)
+	return nil
+}
+
+func TestAppendOnlyLocks(t *testing.T) {
+	store := &lockStore{
+		MockBackend: ptesting.NewMockBackend(map[string]string{"location": "mock:///?behavior=oneState"}),
+		locks:       make(map[objects.MAC][]byte),
+	}
+	require.NoError(t, store.Create(context.Background(), nil))
+
+	opts := &Options{
+		Credentials: &Credentials{
+			Version: CREDENTIALS_VERSION,
+			Clients: []Client{
+				{Name: "backup", Role: RoleAppendOnly, Token: HashToken("backup")},
+				{Name: "other", Role: RoleAppendOnly, Token: HashToken("other")},
+				{Name: "admin", Role: RoleAdmin, Token: HashToken("admin")},
+			},
+		},
+	}
+	s, err := newServer(context.Background(), store, opts)
+	require.NoError(t, err)
+	h := s.handler(opts)
+
+	// the maintenance lock of the admin stays
+	require.Equal(t, http.StatusOK, do(t, h, "admin", "PUT", "/lock", network.ReqPutLock{Mac: objects.MAC{0x01}, Data: []byte("admin")}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "PUT", "/lock", network.ReqPutLock{Mac: objects.MAC{0x01}, Data: []byte("ransom")}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "backup", "DELETE", "/lock", network.ReqDeleteLock{Mac: objects.MAC{0x01}}))
+	res := doV2(t, h, "backup", "PUT", v2Path("locks", 0x01), "ransom")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res = doV2(t, h, "backup", "DELETE", v2Path("locks", 0x01), "")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	require.Equal(t, []byte("admin"), store.locks[objects.MAC{0x01}])
+
+	// the clients release their own locks only
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "PUT", "/lock", network.ReqPutLock{Mac: objects.MAC{0x02}, Data: []byte("backup")}))
+	res = doV2(t, h, "backup", "PUT", v2Path("locks", 0x03), "backup")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Equal(t, http.StatusForbidden, do(t, h, "other", "DELETE", "/lock", network.ReqDeleteLock{Mac: objects.MAC{0x02}}))
+	res = doV2(t, h, "other", "DELETE", v2Path("locks", 0x03), "")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	require.Equal(t, http.StatusOK, do(t, h, "backup", "DELETE", "/lock", network.ReqDeleteLock{Mac: objects.MAC{0x02}}))
+	res = doV2(t, h, "backup", "DELETE", v2Path("locks", 0x03), "")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	require.Equal(t, http.StatusOK, do(t, h, "admin", "DELETE", "/lock", network.ReqDeleteLock{Mac: objects.MAC{0x01}}))
+	locks, err := store.GetLocks(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, locks)
+}
+
+func TestAdmin(t *testing.T) {
+	h := newTestHandler(t, false)
+
+	require.Equal(t, http.StatusOK, do(t, h, "admin", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x01}, Data: []byte("state")}))
+	require.Equal(t, http.StatusOK, do(t, h, "admin", "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{0x04}, Data: []byte("packfile")}))
+	require.Equal(t, http.StatusOK, do(t, h, "admin", "DELETE", "/state", network.ReqDeleteState{MAC: objects.MAC{0x01}}))
+	require.Equal(t, http.StatusOK, do(t, h, "admin", "DELETE", "/packfile", network.ReqDeletePackfile{MAC: objects.MAC{0x04}}))
+
+	// the server still forbids deletions
+	h = newTestHandler(t, true)
+	require.Equal(t, http.StatusForbidden, do(t, h, "admin", "DELETE", "/state", network.ReqDeleteState{MAC: objects.MAC{0x01}}))
+	require.Equal(t, http.StatusForbidden, do(t, h, "admin", "DELETE", "/packfile", network.ReqDeletePackfile{MAC: objects.MAC{0x04}}))
+
+	require.Equal(t, http.StatusUnauthorized, do(t, h, "nobody", "GET", "/states", network.ReqGetStates{}))
+}
@@ -0,0 +1,245 @@
+package httpd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const CREDENTIALS_VERSION = "v1.0.0"
+
+// Credentials is the content of the credentials file of a server, listing
+// the clients allowed to use it, and the quota of the store.
+type Credentials struct {
+	Version string   `yaml:"version"`
+	Quota   *Quota   `yaml:"quota,omitempty"`
+	Clients []Client `yaml:"clients"`
+}
+
+// Client is a client of the server, authenticating with a bearer token,
+// a password or a certificate whose common name is its name.  The token is
+// stored as "sha256:" and the hex of its SHA-256, and the password as a
+// bcrypt hash.  The role tells what the client may do, and the quota how
+// much it may write.
+type Client struct {
+	Name     string `yaml:"name"`
+	Role     Role   `yaml:"role"`
+	Token    string `yaml:"token,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Quota    *Quota `yaml:"quota,omitempty"`
+}
+
+// HashToken returns the form of token stored in the credentials file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (c *Credentials) validate() error {
+	if c.Version != CREDENTIALS_VERSION {
+		return fmt.Errorf("unsupported version %q", c.Version)
+	}
+
+	if err := c.Quota.validate(); err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{})
+	for _, client := range c.Clients {
+		if client.Name == "" {
+			return fmt.Errorf("client without a name")
+		}
+		if _, ok := names[client.Name]; ok {
+			return fmt.Errorf("duplicate client %q", client.Name)
+		}
+		names[client.Name] = struct{}{}
+
+		if !client.Role.valid() {
+			return fmt.Errorf("client %q: invalid role %q", client.Name, client.Role)
+		}
+		if err := client.Quota.validate(); err != nil {
+			return fmt.Errorf("client %q: %w", client.Name, err)
+		}
+
+		if client.Token != "" {
+			sum, ok := strings.CutPrefix(client.Token, "sha256:")
+			if !ok {
+				return fmt.Errorf("client %q: token must be a sha256: hash", client.Name)
+			}
+			if raw, err := hex.DecodeString(sum); err != nil || len(raw) != sha256.Size {
+				return fmt.Errorf("client %q: invalid token hash", client.Name)
+			}
+		}
+		if client.Password != "" {
+			if _, err := bcrypt.Cost([]byte(client.Password)); err != nil {
+				return fmt.Errorf("client %q: password must be a bcrypt hash: %w", client.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadCredentials reads the credentials file.
+func LoadCredentials(filename string) (*Credentials, error) {
+	rd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	creds := &Credentials{}
+	dec := yaml.NewDecoder(rd)
+	dec.KnownFields(true)
+	if err := dec.Decode(creds); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := creds.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return creds, nil
+}
+
+// TLSConfig returns the TLS configuration of a server with the given
+// certificate and key.  If caFile is set, the clients must present a
+// certificate signed by one of its certificate authorities.
+func TLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the server certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate authority: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificate found", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+type clientKey struct{}
+
+// ClientFromContext returns the client that sent the request of ctx, or
+// nil when the server doesn't authenticate them.
+func ClientFromContext(ctx context.Context) *Client {
+	client, _ := ctx.Value(clientKey{}).(*Client)
+	return client
+}
+
+// authenticator checks that the requests come from the clients of the
+// credentials, or from a client with a verified certificate if there are
+// no credentials.
+type authenticator struct {
+	clients map[string]*Client
+	tokens  map[string]*Client
+
+	// the passwords are slow to check on purpose, so we remember the
+	// ones that were good.
+	mu       sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+func newAuthenticator(creds *Credentials) *authenticator {
+	a := &authenticator{
+		verified: make(map[string][sha256.Size]byte),
+	}
+	if creds == nil {
+		return a
+	}
+
+	a.clients = make(map[string]*Client)
+	a.tokens = make(map[string]*Client)
+	for i := range creds.Clients {
+		client := &creds.Clients[i]
+		a.clients[client.Name] = client
+		if client.Token != "" {
+			a.tokens[client.Token] = client
+		}
+	}
+	return a
+}
+
+func (a *authenticator) checkPassword(client *Client, password string) bool {
+	sum := sha256.Sum256([]byte(password))
+
+	a.mu.Lock()
+	good, ok := a.verified[client.Name]
+	a.mu.Unlock()
+	if ok {
+		return subtle.ConstantTimeCompare(good[:], sum[:]) == 1
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(client.Password), []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.verified[client.Name] = sum
+	a.mu.Unlock()
+	return true
+}
+
+// authenticate returns the client that sent r, or nil.
+func (a *authenticator) authenticate(r *http.Request) *Client {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.tokens[HashToken(token)]
+	}
+
+	if name, password, ok := r.BasicAuth(); ok {
+		client := a.clients[name]
+		if client == nil || client.Password == "" || !a.checkPassword(client, password) {
+			return nil
+		}
+		return client
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		name := r.TLS.PeerCertificates[0].Subject.CommonName
+		if a.clients == nil {
+			return &Client{Name: name}
+		}
+		return a.clients[name]
+	}
+	return nil
+}
+
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := a.authenticate(r)
+		if client == nil {
+			unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, withClient(r, client))
+	})
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="plakar"`)
+	http.Error(w, "authentication required", http.StatusUnauthorized)
+}
+
+// withClient returns r sent by client.
+func withClient(r *http.Request, client *Client) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientKey{}, client))
+}
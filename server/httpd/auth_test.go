@@ -0,0 +1,143 @@
+package httpd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	load := func(content string) (*Credentials, error) {
+		filename := filepath.Join(t.TempDir(), "credentials.yml")
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+		return LoadCredentials(filename)
+	}
+
+	password, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	creds, err := load(`version: v1.0.0
+clients:
+  - name: alice
+    role: append-only
+    token: ` + HashToken("alice-token") + `
+  - name: bob
+    role: admin
+    password: ` + string(password) + `
+`)
+	require.NoError(t, err)
+	require.Len(t, creds.Clients, 2)
+	require.Equal(t, "alice", creds.Clients[0].Name)
+	require.Equal(t, string(password), creds.Clients[1].Password)
+	require.Equal(t, RoleAppendOnly, creds.Clients[0].Role)
+
+	_, err = load("version: v0.0.1\n")
+	require.Error(t, err)
+
+	_, err = load("version: v1.0.0\nclients:\n  - name: alice\n    role: admin\n    token: alice-token\n")
+	require.Error(t, err)
+
+	_, err = load("version: v1.0.0\nclients:\n  - name: bob\n    role: admin\n    password: secret\n")
+	require.Error(t, err)
+
+	_, err = load("version: v1.0.0\nclients:\n  - name: alice\n    role: admin\n  - name: alice\n    role: admin\n")
+	require.Error(t, err)
+
+	_, err = load("version: v1.0.0\nclients:\n  - name: alice\n    role: admin\n    tokens: x\n")
+	require.Error(t, err)
+
+	_, err = load("version: v1.0.0\nclients:\n  - name: alice\n")
+	require.Error(t, err)
+
+	_, err = load("version: v1.0.0\nclients:\n  - name: alice\n    role: root\n")
+	require.Error(t, err)
+}
+
+func TestAuthenticator(t *testing.T) {
+	password, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	creds := &Credentials{
+		Version: CREDENTIALS_VERSION,
+		Clients: []Client{
+			{Name: "alice", Token: HashToken("alice-token")},
+			{Name: "bob", Password: string(password)},
+			{Name: "carol"},
+		},
+	}
+
+	var seen *Client
+	handler := newAuthenticator(creds).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientFromContext(r.Context())
+	}))
+
+	// do returns the status code and the client seen by the handler.
+	do := func(setup func(r *http.Request)) (int, *Client) {
+		seen = nil
+		r := httptest.NewRequest("GET", "/", nil)
+		setup(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, seen
+	}
+
+	code, client := do(func(r *http.Request) {})
+	require.Equal(t, http.StatusUnauthorized, code)
+	require.Nil(t, client)
+
+	code, client = do(func(r *http.Request) { r.Header.Set("Authorization", "Bearer alice-token") })
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "alice", client.Name)
+
+	code, _ = do(func(r *http.Request) { r.Header.Set("Authorization", "Bearer bob-token") })
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	// twice, to check the remembered password too
+	for range 2 {
+		code, client = do(func(r *http.Request) { r.SetBasicAuth("bob", "secret") })
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "bob", client.Name)
+
+		code, _ = do(func(r *http.Request) { r.SetBasicAuth("bob", "guess") })
+		require.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	code, _ = do(func(r *http.Request) { r.SetBasicAuth("alice", "") })
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	verified := func(cn string) func(r *http.Request) {
+		return func(r *http.Request) {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+			r.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			}
+		}
+	}
+
+	code, client = do(verified("carol"))
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "carol", client.Name)
+
+	code, _ = do(verified("mallory"))
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	// without credentials, any verified certificate is good
+	handler = newAuthenticator(nil).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientFromContext(r.Context())
+	}))
+	code, client = do(verified("mallory"))
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "mallory", client.Name)
+
+	code, _ = do(func(r *http.Request) { r.Header.Set("Authorization", "Bearer alice-token") })
+	require.Equal(t, http.StatusUnauthorized, code)
+}
@@ -0,0 +1,204 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+// Hosting is the set of stores served by a server hosting many of them,
+// each under "/r/" and its name.
+type Hosting struct {
+	// Stores are the configurations of the stores, by name.
+	Stores map[string]map[string]string
+
+	// Root is a directory whose subdirectories are hosted too, and in
+	// which the clients may create new stores.
+	Root string
+
+	// CredentialsDir holds the credentials of the stores, named after
+	// them with a .yml extension, replacing the ones of the server.  The
+	// stores lacking them are not served unless the server has some.
+	CredentialsDir string
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+type host struct {
+	ctx     *kcontext.KContext
+	opts    *Options
+	hosting *Hosting
+
+	mu     sync.Mutex
+	stores map[string]*hostedStore
+}
+
+type hostedStore struct {
+	store   storage.Store
+	handler http.Handler
+}
+
+// storeConfig returns the configuration of the store name, and whether it
+// is a store of the root that is yet to be created.
+func (h *host) storeConfig(name string) (map[string]string, bool, bool) {
+	if !validName.MatchString(name) {
+		return nil, false, false
+	}
+	if config, ok := h.hosting.Stores[name]; ok {
+		// the storage alters the configuration it's given
+		return maps.Clone(config), false, true
+	}
+	if h.hosting.Root != "" {
+		dir := filepath.Join(h.hosting.Root, name)
+		_, err := os.Stat(dir)
+		return map[string]string{"location": "fs://" + dir}, os.IsNotExist(err), true
+	}
+	return nil, false, false
+}
+
+// errNoCredentials is returned for the stores lacking credentials when the
+// server has none to fall back to.
+var errNoCredentials = errors.New("no credentials")
+
+// options returns the options of the store name, with its own credentials
+// if it has some.  Without them, the store is only served with the
+// credentials of the server.
+func (h *host) options(name string) (*Options, error) {
+	opts := *h.opts
+	if h.hosting.CredentialsDir != "" {
+		creds, err := LoadCredentials(filepath.Join(h.hosting.CredentialsDir, name+".yml"))
+		if errors.Is(err, fs.ErrNotExist) {
+			if opts.Credentials == nil {
+				return nil, errNoCredentials
+			}
+		} else if err != nil {
+			return nil, err
+		} else {
+			opts.Credentials = creds
+		}
+	}
+	return &opts, nil
+}
+
+func (h *host) cached(name string) *hostedStore {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stores[name]
+}
+
+// add caches hosted for name, unless another request did first, and
+// returns the one cached.
+func (h *host) add(name string, hosted *hostedStore) *hostedStore {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if other, ok := h.stores[name]; ok {
+		hosted.store.Close(h.ctx)
+		return other
+	}
+	h.stores[name] = hosted
+	return hosted
+}
+
+// serveStore serves the store of the request, which is opened once its
+// client is authenticated.  Only the stores that exist are kept open, the
+// others are for the request creating them.
+func (h *host) serveStore(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if hosted := h.cached(name); hosted != nil {
+		hosted.handler.ServeHTTP(w, r)
+		return
+	}
+
+	config, missing, ok := h.storeConfig(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := h.options(name)
+	if errors.Is(err, errNoCredentials) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// anyone could create stores otherwise
+	if missing && !opts.authenticates() {
+		http.Error(w, "creating stores requires authentication", http.StatusForbidden)
+		return
+	}
+
+	var auth *authenticator
+	if opts.authenticates() {
+		auth = newAuthenticator(opts.Credentials)
+		client := auth.authenticate(r)
+		if client == nil {
+			unauthorized(w)
+			return
+		}
+		r = withClient(r, client)
+	}
+
+	store, err := storage.New(h.ctx, config)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("store %s: %s", name, err), http.StatusInternalServerError)
+		return
+	}
+	s, err := newServer(h.ctx, store, opts)
+	if err != nil {
+		store.Close(h.ctx)
+		http.Error(w, fmt.Sprintf("store %s: %s", name, err), http.StatusInternalServerError)
+		return
+	}
+	routes := http.StripPrefix("/r/"+name, s.routes())
+
+	if missing {
+		defer store.Close(h.ctx)
+		routes.ServeHTTP(w, r)
+		return
+	}
+
+	handler := routes
+	if auth != nil {
+		handler = auth.wrap(routes)
+	}
+	if hosted := h.add(name, &hostedStore{store: store, handler: handler}); hosted.store != store {
+		hosted.handler.ServeHTTP(w, r)
+		return
+	}
+	routes.ServeHTTP(w, r)
+}
+
+func (h *host) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/{name}/", h.serveStore)
+	return mux
+}
+
+// Host serves the stores of hosting on addr, each with its own credentials
+// if there are some, until ctx is done.
+func Host(ctx *kcontext.KContext, addr string, opts *Options, hosting *Hosting) error {
+	h := &host{
+		ctx:     ctx,
+		opts:    opts,
+		hosting: hosting,
+		stores:  make(map[string]*hostedStore),
+	}
+	err := serve(ctx, addr, h.handler(), opts)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, hosted := range h.stores {
+		hosted.store.Close(ctx)
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/PlakarKorp/integration-fs/storage"
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost(t *testing.T) {
+	root := t.TempDir()
+	credsDir := t.TempDir()
+
+	// alice's store only lets alice in
+	require.NoError(t, os.WriteFile(filepath.Join(credsDir, "alice.yml"), []byte(`version: v1.0.0
+clients:
+  - name: alice
+    role: admin
+    token: `+HashToken("alice")+`
+`), 0600))
+
+	// the other stores let the admin of the server in
+	opts := &Options{Credentials: &Credentials{
+		Version: CREDENTIALS_VERSION,
+		Clients: []Client{{Name: "admin", Role: RoleAdmin, Token: HashToken("admin")}},
+	}}
+	require.NoError(t, opts.Credentials.validate())
+
+	hst := &host{
+		ctx:  kcontext.NewKContext(),
+		opts: opts,
+		hosting: &Hosting{
+			Stores: map[string]map[string]string{
+				"mock": {"location": "mock:///mock"},
+			},
+			Root:           root,
+			CredentialsDir: credsDir,
+		},
+		stores: make(map[string]*hostedStore),
+	}
+	h := hst.handler()
+
+	// send sends req as token to path, and returns the status code and
+	// the decoded response in res.
+	send := func(token, method, path string, req, res any) int {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code == http.StatusOK && res != nil {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(res))
+		}
+		return w.Code
+	}
+
+	var resCreate network.ResCreate
+	require.Equal(t, http.StatusUnauthorized, send("", "PUT", "/r/bob/", network.ReqCreate{Configuration: []byte("bob")}, nil))
+	require.Equal(t, http.StatusOK, send("admin", "PUT", "/r/bob/", network.ReqCreate{Configuration: []byte("bob")}, &resCreate))
+	require.Empty(t, resCreate.Err)
+	require.FileExists(t, filepath.Join(root, "bob", "CONFIG"))
+
+	var resOpen network.ResOpen
+	require.Equal(t, http.StatusOK, send("admin", "GET", "/r/bob/", network.ReqOpen{}, &resOpen))
+	require.Empty(t, resOpen.Err)
+	require.Equal(t, []byte("bob"), resOpen.Configuration)
+
+	// the store isn't touched before the client is authenticated
+	require.Equal(t, http.StatusUnauthorized, send("", "PUT", "/r/alice/", network.ReqCreate{Configuration: []byte("alice")}, nil))
+	require.NoDirExists(t, filepath.Join(root, "alice"))
+	require.Equal(t, http.StatusOK, send("alice", "PUT", "/r/alice/", network.ReqCreate{Configuration: []byte("alice")}, &resCreate))
+	require.Empty(t, resCreate.Err)
+	require.Equal(t, http.StatusUnauthorized, send("admin", "GET", "/r/alice/", network.ReqOpen{}, nil))
+	require.Equal(t, http.StatusOK, send("alice", "GET", "/r/alice/", network.ReqOpen{}, &resOpen))
+	require.Equal(t, []byte("alice"), resOpen.Configuration)
+	require.Equal(t, http.StatusUnauthorized, send("", "GET", "/r/alice/", network.ReqOpen{}, nil))
+
+	// only the stores that exist are kept open
+	require.Equal(t, http.StatusInternalServerError, send("admin", "GET", "/r/carol/", network.ReqOpen{}, nil))
+	require.Contains(t, hst.stores, "alice")
+	require.NotContains(t, hst.stores, "carol")
+
+	var resGetStates network.ResGetStates
+	require.Equal(t, http.StatusOK, send("admin", "GET", "/r/mock/states", network.ReqGetStates{}, &resGetStates))
+	require.Empty(t, resGetStates.Err)
+
+	require.Equal(t, http.StatusNotFound, send("", "GET", "/r/..%2fetc/", network.ReqOpen{}, nil))
+	require.Equal(t, http.StatusNotFound, send("", "GET", "/states", network.ReqGetStates{}, nil))
+
+	// without credentials to fall back to, only alice's store is served
+	h = (&host{
+		ctx:  kcontext.NewKContext(),
+		opts: &Options{},
+		hosting: &Hosting{
+			Root:           root,
+			CredentialsDir: credsDir,
+		},
+		stores: make(map[string]*hostedStore),
+	}).handler()
+	require.Equal(t, http.StatusNotFound, send("", "GET", "/r/bob/", network.ReqOpen{}, nil))
+	require.Equal(t, http.StatusOK, send("alice", "GET", "/r/alice/", network.ReqOpen{}, &resOpen))
+
+	// nor may anyone create stores without credentials
+	h = (&host{
+		ctx:     kcontext.NewKContext(),
+		opts:    &Options{},
+		hosting: &Hosting{Root: root},
+		stores:  make(map[string]*hostedStore),
+	}).handler()
+	require.Equal(t, http.StatusForbidden, send("", "PUT", "/r/dave/", network.ReqCreate{Configuration: []byte("dave")}, nil))
+	require.NoDirExists(t, filepath.Join(root, "dave"))
+
+	// the same without a root
+	h = (&host{
+		ctx:     kcontext.NewKContext(),
+		opts:    &Options{},
+		hosting: &Hosting{},
+		stores:  make(map[string]*hostedStore),
+	}).handler()
+	require.Equal(t, http.StatusNotFound, send("", "GET", "/r/bob/", network.ReqOpen{}, nil))
+}
@@ -3,11 +3,15 @@ package httpd
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/network"
@@ -17,6 +21,36 @@ type server struct {
 	store    storage.Store
 	ctx      context.Context
 	noDelete bool
+
+	states    *objectSet
+	packfiles *objectSet
+	locks     *lockOwners
+
+	quota   *Quota
+	clients map[string]*Client
+	usage   *accounting
+}
+
+func (s *server) createRepository(w http.ResponseWriter, r *http.Request) {
+	if s.role(r) != RoleAdmin {
+		http.Error(w, fmt.Errorf("not allowed to create").Error(), http.StatusForbidden)
+		return
+	}
+
+	var reqCreate network.ReqCreate
+	if err := json.NewDecoder(r.Body).Decode(&reqCreate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resCreate network.ResCreate
+	if err := s.store.Create(s.ctx, reqCreate.Configuration); err != nil {
+		resCreate.Err = err.Error()
+	}
+	if err := json.NewEncoder(w).Encode(resCreate); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
@@ -35,6 +69,7 @@ func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
 	var resOpen network.ResOpen
 	resOpen.Configuration = serializedConfig
 	resOpen.Err = ""
+	resOpen.Protocols = []int{network.ProtocolV1, network.ProtocolV2}
 	if err := json.NewEncoder(w).Encode(resOpen); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -63,6 +98,10 @@ func (s *server) getStates(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) putState(w http.ResponseWriter, r *http.Request) {
+	if !s.allowed(w, r, false) {
+		return
+	}
+
 	var reqPutState network.ReqPutState
 	if err := json.NewDecoder(r.Body).Decode(&reqPutState); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -71,9 +110,29 @@ func (s *server) putState(w http.ResponseWriter, r *http.Request) {
 
 	var resPutIndex network.ResPutState
 	data := reqPutState.Data
-	_, err := s.store.PutState(r.Context(), reqPutState.MAC, bytes.NewBuffer(data))
-	if err != nil {
-		resPutIndex.Err = err.Error()
+	write, err := s.appendOnly(r, s.states, reqPutState.MAC, bytes.NewReader(data))
+	if errors.Is(err, errOverwrite) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if write {
+		if err := s.reserve(r, int64(len(data)), false); errors.Is(err, errQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = s.store.PutState(r.Context(), reqPutState.MAC, bytes.NewBuffer(data))
+		if err != nil {
+			s.release(r, int64(len(data)), false)
+			resPutIndex.Err = err.Error()
+		} else {
+			s.states.add(reqPutState.MAC)
+		}
 	}
 	if err := json.NewEncoder(w).Encode(resPutIndex); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -109,8 +168,7 @@ func (s *server) getState(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) deleteState(w http.ResponseWriter, r *http.Request) {
-	if s.noDelete {
-		http.Error(w, fmt.Errorf("not allowed to delete").Error(), http.StatusForbidden)
+	if !s.allowed(w, r, true) {
 		return
 	}
 
@@ -124,6 +182,9 @@ func (s *server) deleteState(w http.ResponseWriter, r *http.Request) {
 	err := s.store.DeleteState(r.Context(), reqDeleteState.MAC)
 	if err != nil {
 		resDeleteState.Err = err.Error()
+	} else {
+		s.states.remove(reqDeleteState.MAC)
+		s.deleted()
 	}
 	if err := json.NewEncoder(w).Encode(resDeleteState); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -153,6 +214,10 @@ func (s *server) getPackfiles(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) putPackfile(w http.ResponseWriter, r *http.Request) {
+	if !s.allowed(w, r, false) {
+		return
+	}
+
 	var reqPutPackfile network.ReqPutPackfile
 	if err := json.NewDecoder(r.Body).Decode(&reqPutPackfile); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -160,9 +225,30 @@ func (s *server) putPackfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var resPutPackfile network.ResPutPackfile
-	_, err := s.store.PutPackfile(r.Context(), reqPutPackfile.MAC, bytes.NewBuffer(reqPutPackfile.Data))
-	if err != nil {
-		resPutPackfile.Err = err.Error()
+	write, err := s.appendOnly(r, s.packfiles, reqPutPackfile.MAC, bytes.NewReader(reqPutPackfile.Data))
+	if errors.Is(err, errOverwrite) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if write {
+		size := int64(len(reqPutPackfile.Data))
+		if err := s.reserve(r, size, true); errors.Is(err, errQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = s.store.PutPackfile(r.Context(), reqPutPackfile.MAC, bytes.NewBuffer(reqPutPackfile.Data))
+		if err != nil {
+			s.release(r, size, true)
+			resPutPackfile.Err = err.Error()
+		} else {
+			s.packfiles.add(reqPutPackfile.MAC)
+		}
 	}
 	if err := json.NewEncoder(w).Encode(resPutPackfile); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -224,8 +310,7 @@ func (s *server) GetPackfileBlob(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) deletePackfile(w http.ResponseWriter, r *http.Request) {
-	if s.noDelete {
-		http.Error(w, fmt.Errorf("not allowed to delete").Error(), http.StatusForbidden)
+	if !s.allowed(w, r, true) {
 		return
 	}
 
@@ -239,6 +324,9 @@ func (s *server) deletePackfile(w http.ResponseWriter, r *http.Request) {
 	err := s.store.DeletePackfile(r.Context(), reqDeletePackfile.MAC)
 	if err != nil {
 		resDeletePackfile.Err = err.Error()
+	} else {
+		s.packfiles.remove(reqDeletePackfile.MAC)
+		s.deleted()
 	}
 	if err := json.NewEncoder(w).Encode(resDeletePackfile); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -267,15 +355,29 @@ func (s *server) getLocks(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) putLock(w http.ResponseWriter, r *http.Request) {
+	if !s.allowed(w, r, false) {
+		return
+	}
+
 	var req network.ReqPutLock
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err := s.lockAllowed(r, req.Mac, false); errors.Is(err, errOverwrite) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	var res network.ResPutLock
 	if _, err := s.store.PutLock(r.Context(), req.Mac, bytes.NewReader(req.Data)); err != nil {
 		res.Err = err.Error()
+	} else {
+		s.locks.taken(r, req.Mac)
 	}
 
 	if err := json.NewEncoder(w).Encode(&res); err != nil {
@@ -312,15 +414,26 @@ func (s *server) getLock(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) deleteLock(w http.ResponseWriter, r *http.Request) {
+	if !s.allowed(w, r, false) {
+		return
+	}
+
 	var req network.ReqDeleteLock
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err := s.lockAllowed(r, req.Mac, true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	var res network.ResDeleteLock
 	if err := s.store.DeleteLock(r.Context(), req.Mac); err != nil {
 		res.Err = err.Error()
+	} else {
+		s.locks.released(req.Mac)
 	}
 
 	if err := json.NewEncoder(w).Encode(&res); err != nil {
@@ -329,16 +442,83 @@ func (s *server) deleteLock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func Server(ctx context.Context, repo *repository.Repository, addr string, noDelete bool) error {
-	s := server{
-		store:    repo.Store(),
+// Options are the settings of a server.
+type Options struct {
+	NoDelete bool
+
+	// TLSConfig enables TLS, and the client certificates if it asks
+	// for them.
+	TLSConfig *tls.Config
+
+	// Credentials are the clients allowed to use the server.  Without
+	// them, any client is allowed unless they must have a certificate.
+	Credentials *Credentials
+
+	// UsageDir keeps what each client wrote across restarts, as the
+	// store can't tell.  Without it, the usage of the clients starts
+	// from zero with the server.
+	UsageDir string
+}
+
+func (opts *Options) authenticates() bool {
+	return opts.Credentials != nil ||
+		(opts.TLSConfig != nil && opts.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+}
+
+func newServer(ctx context.Context, store storage.Store, opts *Options) (*server, error) {
+	s := &server{
+		store:    store,
 		ctx:      ctx,
-		noDelete: noDelete,
+		noDelete: opts.NoDelete,
+		states: &objectSet{
+			list: store.GetStates,
+			get:  store.GetState,
+		},
+		packfiles: &objectSet{
+			list: store.GetPackfiles,
+			get:  store.GetPackfile,
+		},
+		locks: &lockOwners{
+			owners: make(map[objects.MAC]string),
+		},
+		clients: make(map[string]*Client),
+		usage: &accounting{
+			clients: make(map[string]*usage),
+		},
+	}
+	if opts.UsageDir != "" {
+		location, err := store.Location(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.usage.file = usageFile(opts.UsageDir, location)
 	}
+	if opts.Credentials != nil {
+		s.quota = opts.Credentials.Quota
+		for i := range opts.Credentials.Clients {
+			client := &opts.Credentials.Clients[i]
+			s.clients[client.Name] = client
+		}
+	}
+	return s, nil
+}
 
+// handler returns the handler of the requests, which authenticates the
+// clients if opts asks for it.
+func (s *server) handler(opts *Options) http.Handler {
+	if opts.authenticates() {
+		return newAuthenticator(opts.Credentials).wrap(s.routes())
+	}
+	return s.routes()
+}
+
+// routes returns the handler of the requests of clients authenticated if
+// need be.
+func (s *server) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /", s.openRepository)
+	mux.HandleFunc("PUT /{$}", s.createRepository)
 
 	mux.HandleFunc("GET /states", s.getStates)
 	mux.HandleFunc("PUT /state", s.putState)
@@ -356,11 +536,49 @@ func Server(ctx context.Context, repo *repository.Repository, addr string, noDel
 	mux.HandleFunc("GET /lock", s.getLock)
 	mux.HandleFunc("DELETE /lock", s.deleteLock)
 
-	server := &http.Server{Addr: addr, Handler: mux}
+	mux.HandleFunc("GET /usage", s.getUsage)
+
+	mux.HandleFunc("GET /v2/{$}", s.v2Open)
+
+	mux.HandleFunc("GET /v2/states", s.v2List(s.store.GetStates))
+	mux.HandleFunc("PUT /v2/states/{mac}", s.v2Put(s.states, s.store.PutState))
+	mux.HandleFunc("GET /v2/states/{mac}", s.v2Get(s.store.GetState))
+	mux.HandleFunc("DELETE /v2/states/{mac}", s.v2Delete(s.states, s.store.DeleteState))
+
+	mux.HandleFunc("GET /v2/packfiles", s.v2List(s.store.GetPackfiles))
+	mux.HandleFunc("PUT /v2/packfiles/{mac}", s.v2Put(s.packfiles, s.store.PutPackfile))
+	mux.HandleFunc("GET /v2/packfiles/{mac}", s.v2GetPackfile)
+	mux.HandleFunc("DELETE /v2/packfiles/{mac}", s.v2Delete(s.packfiles, s.store.DeletePackfile))
+
+	mux.HandleFunc("GET /v2/locks", s.v2List(s.store.GetLocks))
+	mux.HandleFunc("PUT /v2/locks/{mac}", s.v2Put(nil, s.store.PutLock))
+	mux.HandleFunc("GET /v2/locks/{mac}", s.v2Get(s.store.GetLock))
+	mux.HandleFunc("DELETE /v2/locks/{mac}", s.v2Delete(nil, s.store.DeleteLock))
+
+	mux.HandleFunc("GET /v2/usage", s.v2GetUsage)
+	mux.HandleFunc("DELETE /v2/usage/{client}", s.v2ResetUsage)
+
+	return mux
+}
+
+func Server(ctx context.Context, repo *repository.Repository, addr string, opts *Options) error {
+	s, err := newServer(ctx, repo.Store(), opts)
+	if err != nil {
+		return err
+	}
+	return serve(repo.AppContext(), addr, s.handler(opts), opts)
+}
+
+func serve(kctx *kcontext.KContext, addr string, handler http.Handler, opts *Options) error {
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: opts.TLSConfig}
 	go func() {
-		<-repo.AppContext().Done()
-		server.Shutdown(repo.AppContext().Context)
+		<-kctx.Done()
+		server.Shutdown(kctx.Context)
 	}()
 
+	if opts.TLSConfig != nil {
+		// the certificate is in the TLS configuration
+		return server.ListenAndServeTLS("", "")
+	}
 	return server.ListenAndServe()
 }
@@ -0,0 +1,368 @@
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/dustin/go-humanize"
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the size and the number of packfiles of a store, or what a
+// client may write to it.  The size is given with its unit, as in "50GB".
+// Zero means no limit.
+type Quota struct {
+	Size      string `yaml:"size,omitempty"`
+	Packfiles int64  `yaml:"packfiles,omitempty"`
+
+	size int64
+}
+
+func (q *Quota) validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.Size != "" {
+		size, err := humanize.ParseBytes(q.Size)
+		if err != nil {
+			return fmt.Errorf("invalid quota size %q: %w", q.Size, err)
+		}
+		q.size = int64(size)
+	}
+	if q.Packfiles < 0 {
+		return fmt.Errorf("invalid quota packfiles %d", q.Packfiles)
+	}
+	return nil
+}
+
+func (q *Quota) maxSize() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.size
+}
+
+func (q *Quota) maxPackfiles() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.Packfiles
+}
+
+// check fails if u can't grow by size and packfiles within q.  what is the
+// name of what is limited, for the error.
+func (q *Quota) check(what string, u *usage, size, packfiles int64) error {
+	if max := q.maxSize(); max != 0 && u.size+size > max {
+		return fmt.Errorf("%w: %s is limited to %s", errQuotaExceeded, what, humanize.Bytes(uint64(max)))
+	}
+	if max := q.maxPackfiles(); max != 0 && u.packfiles+packfiles > max {
+		return fmt.Errorf("%w: %s is limited to %d packfiles", errQuotaExceeded, what, max)
+	}
+	return nil
+}
+
+type usage struct {
+	size      int64
+	packfiles int64
+
+	// since is when the usage of a client was last reset.
+	since time.Time
+}
+
+// accounting is the usage of the store, loaded from it when first needed,
+// and what each client wrote to it since its usage was last reset.  The
+// store can't tell who wrote what, so the usage of the clients is kept in
+// file, if any, across restarts, and deleting doesn't lower it.
+type accounting struct {
+	mu      sync.Mutex
+	loaded  bool
+	total   usage
+	clients map[string]*usage
+
+	file          string
+	clientsLoaded bool
+}
+
+// savedUsage is the usage of a client in the file of the accounting.
+type savedUsage struct {
+	Size      int64     `json:"size"`
+	Packfiles int64     `json:"packfiles"`
+	Since     time.Time `json:"since"`
+}
+
+// UsageDir returns the directory keeping the usage of the clients of the
+// stores in cacheDir.
+func UsageDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "server-usage")
+}
+
+// usageFile returns the file keeping the usage of the clients of the store
+// at location in dir.
+func usageFile(dir, location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// loadClients reads the usage of the clients if it isn't known.
+func (s *server) loadClients() error {
+	if s.usage.clientsLoaded || s.usage.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.usage.file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		var saved map[string]savedUsage
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.usage.file, err)
+		}
+		for name, u := range saved {
+			s.usage.clients[name] = &usage{size: u.Size, packfiles: u.Packfiles, since: u.Since}
+		}
+	}
+	s.usage.clientsLoaded = true
+	return nil
+}
+
+// saveClients writes the usage of the clients to the file, if any.
+func (s *server) saveClients() error {
+	if s.usage.file == "" {
+		return nil
+	}
+	saved := make(map[string]savedUsage, len(s.usage.clients))
+	for name, u := range s.usage.clients {
+		saved[name] = savedUsage{Size: u.size, Packfiles: u.packfiles, Since: u.since}
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.usage.file), 0700); err != nil {
+		return err
+	}
+	tmp := s.usage.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.usage.file)
+}
+
+// load reads the usage of the store if it isn't known.  The states are
+// left out of the count of packfiles.
+func (s *server) loadUsage(r *http.Request) error {
+	if s.usage.loaded {
+		return nil
+	}
+	size, err := s.store.Size(r.Context())
+	if err != nil {
+		return err
+	}
+	packfiles, err := s.store.GetPackfiles(r.Context())
+	if err != nil {
+		return err
+	}
+	s.usage.total = usage{size: size, packfiles: int64(len(packfiles))}
+	s.usage.loaded = true
+	return nil
+}
+
+// limited tells whether the writes of the client of r are limited.
+func (s *server) limited(r *http.Request) bool {
+	if s.quota != nil {
+		return true
+	}
+	client := ClientFromContext(r.Context())
+	return client != nil && client.Quota != nil
+}
+
+// reserve accounts for the client of r writing an object of size bytes,
+// which is a packfile if packfile is set, or fails if that would exceed a
+// quota.
+func (s *server) reserve(r *http.Request, size int64, packfile bool) error {
+	var packfiles int64
+	if packfile {
+		packfiles = 1
+	}
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	if s.quota != nil {
+		if err := s.loadUsage(r); err != nil {
+			return err
+		}
+		if err := s.quota.check("the store", &s.usage.total, size, packfiles); err != nil {
+			return err
+		}
+	}
+
+	if client := ClientFromContext(r.Context()); client != nil {
+		if err := s.loadClients(); err != nil {
+			return err
+		}
+		written := s.usage.clients[client.Name]
+		if written == nil {
+			written = &usage{since: time.Now()}
+			s.usage.clients[client.Name] = written
+		}
+		if err := client.Quota.check("the client "+client.Name, written, size, packfiles); err != nil {
+			return err
+		}
+		written.size += size
+		written.packfiles += packfiles
+		if err := s.saveClients(); err != nil {
+			written.size -= size
+			written.packfiles -= packfiles
+			return err
+		}
+	}
+	if s.usage.loaded {
+		s.usage.total.size += size
+		s.usage.total.packfiles += packfiles
+	}
+	return nil
+}
+
+// release cancels a reservation for an object that couldn't be written.
+func (s *server) release(r *http.Request, size int64, packfile bool) {
+	var packfiles int64
+	if packfile {
+		packfiles = 1
+	}
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	if client := ClientFromContext(r.Context()); client != nil {
+		if written := s.usage.clients[client.Name]; written != nil {
+			written.size -= size
+			written.packfiles -= packfiles
+			// at worst, the client is charged for what it didn't write
+			s.saveClients()
+		}
+	}
+	if s.usage.loaded {
+		s.usage.total.size -= size
+		s.usage.total.packfiles -= packfiles
+	}
+}
+
+// deleted forgets the usage of the store, which is read again when needed,
+// as the size of what was deleted is not known.
+func (s *server) deleted() {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	s.usage.loaded = false
+}
+
+// usageOf returns the usage of the store and what the clients wrote, even
+// if the usage of the store couldn't be read.  Only the clients with the
+// admin role see the usage of the others.
+func (s *server) usageOf(r *http.Request) (network.ResGetUsage, error) {
+	var resGetUsage network.ResGetUsage
+
+	s.usage.mu.Lock()
+	err := s.loadClients()
+	if err == nil {
+		err = s.loadUsage(r)
+	}
+	if err == nil {
+		resGetUsage.Size = s.usage.total.size
+		resGetUsage.Packfiles = s.usage.total.packfiles
+		resGetUsage.MaxSize = s.quota.maxSize()
+		resGetUsage.MaxPackfiles = s.quota.maxPackfiles()
+	}
+
+	self := ClientFromContext(r.Context())
+	for name, written := range s.usage.clients {
+		if s.role(r) != RoleAdmin && name != self.Name {
+			continue
+		}
+		resGetUsage.Clients = append(resGetUsage.Clients, network.ClientUsage{
+			Name:      name,
+			Size:      written.size,
+			Packfiles: written.packfiles,
+			Since:     written.since,
+		})
+	}
+	s.usage.mu.Unlock()
+
+	slices.SortFunc(resGetUsage.Clients, func(a, b network.ClientUsage) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range resGetUsage.Clients {
+		if client := s.clients[resGetUsage.Clients[i].Name]; client != nil {
+			resGetUsage.Clients[i].MaxSize = client.Quota.maxSize()
+			resGetUsage.Clients[i].MaxPackfiles = client.Quota.maxPackfiles()
+		}
+	}
+	return resGetUsage, err
+}
+
+// getUsage answers the usage of the store and what the clients wrote.  It
+// has no request body.
+func (s *server) getUsage(w http.ResponseWriter, r *http.Request) {
+	resGetUsage, err := s.usageOf(r)
+	if err != nil {
+		resGetUsage.Err = err.Error()
+	}
+	if err := json.NewEncoder(w).Encode(resGetUsage); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// v2GetUsage is getUsage for version 2, answering the errors as status
+// codes.
+func (s *server) v2GetUsage(w http.ResponseWriter, r *http.Request) {
+	resGetUsage, err := s.usageOf(r)
+	if err != nil {
+		v2Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resGetUsage)
+}
+
+// v2ResetUsage resets the usage of a client, such as once its data was
+// pruned, so that its quota counts from now on.  Only the clients with the
+// admin role may reset it.
+func (s *server) v2ResetUsage(w http.ResponseWriter, r *http.Request) {
+	if s.role(r) != RoleAdmin {
+		http.Error(w, "not allowed to reset the usage", http.StatusForbidden)
+		return
+	}
+	name := r.PathValue("client")
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	if err := s.loadClients(); err != nil {
+		v2Error(w, err)
+		return
+	}
+	if _, ok := s.usage.clients[name]; !ok {
+		http.Error(w, fmt.Sprintf("no usage for client %q", name), http.StatusNotFound)
+		return
+	}
+	s.usage.clients[name] = &usage{since: time.Now()}
+	if err := s.saveClients(); err != nil {
+		v2Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
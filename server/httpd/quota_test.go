@@ -0,0 +1,227 @@
+package httpd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func v2Path(kind string, b byte) string {
+	mac := objects.MAC{b}
+	return "/v2/" + kind + "/" + hex.EncodeToString(mac[:])
+}
+
+func TestQuotaValidate(t *testing.T) {
+	q := &Quota{Size: "10GB", Packfiles: 100}
+	require.NoError(t, q.validate())
+	require.Equal(t, int64(10_000_000_000), q.maxSize())
+	require.Equal(t, int64(100), q.maxPackfiles())
+
+	require.Error(t, (&Quota{Size: "lots"}).validate())
+	require.Error(t, (&Quota{Packfiles: -1}).validate())
+
+	var none *Quota
+	require.NoError(t, none.validate())
+	require.Zero(t, none.maxSize())
+}
+
+func TestQuota(t *testing.T) {
+	store := ptesting.NewMockBackend(map[string]string{"location": "mock:///?behavior=oneState"})
+	require.NoError(t, store.Create(context.Background(), nil))
+
+	creds := &Credentials{
+		Version: CREDENTIALS_VERSION,
+		// the store has 3 packfiles already
+		Quota: &Quota{Packfiles: 6},
+		Clients: []Client{
+			{Name: "backup", Role: RoleAppendOnly, Token: HashToken("backup"), Quota: &Quota{Size: "10B"}},
+			{Name: "other", Role: RoleAppendOnly, Token: HashToken("other")},
+			{Name: "admin", Role: RoleAdmin, Token: HashToken("admin")},
+		},
+	}
+	require.NoError(t, creds.validate())
+	opts := &Options{Credentials: creds}
+	s, err := newServer(context.Background(), store, opts)
+	require.NoError(t, err)
+	h := s.handler(opts)
+
+	putPackfile := func(token string, mac byte, data string) int {
+		return do(t, h, token, "PUT", "/packfile", network.ReqPutPackfile{MAC: objects.MAC{mac}, Data: []byte(data)})
+	}
+
+	require.Equal(t, http.StatusOK, putPackfile("backup", 0x10, "12345678"))
+	require.Equal(t, http.StatusInsufficientStorage, putPackfile("backup", 0x11, "12345678"))
+	require.Equal(t, http.StatusInsufficientStorage,
+		do(t, h, "backup", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x11}, Data: []byte("123")}))
+	require.Equal(t, http.StatusOK,
+		do(t, h, "backup", "PUT", "/state", network.ReqPutState{MAC: objects.MAC{0x11}, Data: []byte("12")}))
+
+	require.Equal(t, http.StatusOK, putPackfile("other", 0x12, "12345678"))
+	res := doV2(t, h, "other", "PUT", v2Path("packfiles", 0x13), "12345678")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Equal(t, http.StatusInsufficientStorage, putPackfile("other", 0x14, "12345678"))
+	res = doV2(t, h, "other", "PUT", v2Path("packfiles", 0x14), "12345678")
+	require.Equal(t, http.StatusInsufficientStorage, res.StatusCode)
+
+	// the length must be known to check the quotas
+	r := httptest.NewRequest("PUT", v2Path("states", 0x15), strings.NewReader("state"))
+	r.Header.Set("Authorization", "Bearer other")
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusLengthRequired, w.Code)
+
+	usage := func(token string) network.ResGetUsage {
+		r := httptest.NewRequest("GET", "/usage", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res network.ResGetUsage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Empty(t, res.Err)
+		for i := range res.Clients {
+			require.False(t, res.Clients[i].Since.IsZero())
+			res.Clients[i].Since = time.Time{}
+		}
+		return res
+	}
+
+	all := usage("admin")
+	require.Equal(t, int64(6), all.Packfiles)
+	require.Equal(t, int64(6), all.MaxPackfiles)
+	require.Equal(t, []network.ClientUsage{
+		{Name: "backup", Size: 10, Packfiles: 1, MaxSize: 10},
+		{Name: "other", Size: 16, Packfiles: 2},
+	}, all.Clients)
+
+	mine := usage("backup")
+	require.Equal(t, all.Packfiles, mine.Packfiles)
+	require.Equal(t, all.Clients[:1], mine.Clients)
+
+	// deleting makes room again
+	require.Equal(t, http.StatusOK,
+		do(t, h, "admin", "DELETE", "/packfile", network.ReqDeletePackfile{MAC: objects.MAC{0x12}}))
+	require.Equal(t, http.StatusOK, putPackfile("other", 0x14, "12345678"))
+}
+
+// readingStore reads the states it is given, unlike the mock.
+type readingStore struct {
+	*ptesting.MockBackend
+}
+
+func (s readingStore) PutState(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	return io.Copy(io.Discard, rd)
+}
+
+func TestQuotaUsageDir(t *testing.T) {
+	store := readingStore{ptesting.NewMockBackend(map[string]string{"location": "mock:///?behavior=oneState"})}
+	require.NoError(t, store.Create(context.Background(), nil))
+
+	creds := &Credentials{
+		Version: CREDENTIALS_VERSION,
+		Clients: []Client{
+			{Name: "backup", Role: RoleAppendOnly, Token: HashToken("backup"), Quota: &Quota{Size: "10B"}},
+			{Name: "other", Role: RoleAppendOnly, Token: HashToken("other")},
+			{Name: "admin", Role: RoleAdmin, Token: HashToken("admin")},
+		},
+	}
+	require.NoError(t, creds.validate())
+	opts := &Options{Credentials: creds, UsageDir: t.TempDir()}
+	handler := func() http.Handler {
+		s, err := newServer(context.Background(), store, opts)
+		require.NoError(t, err)
+		return s.handler(opts)
+	}
+
+	h := handler()
+	res := doV2(t, h, "backup", "PUT", v2Path("packfiles", 0x10), "12345678")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	// without a limit, the bytes of unknown length are accounted once written
+	r := httptest.NewRequest("PUT", v2Path("states", 0x11), strings.NewReader("state"))
+	r.Header.Set("Authorization", "Bearer other")
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	// a restart doesn't forget what the clients wrote
+	h = handler()
+	res = doV2(t, h, "backup", "PUT", v2Path("packfiles", 0x12), "12345678")
+	require.Equal(t, http.StatusInsufficientStorage, res.StatusCode)
+
+	r = httptest.NewRequest("GET", "/usage", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var usage network.ResGetUsage
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&usage))
+	require.Empty(t, usage.Err)
+	for i := range usage.Clients {
+		usage.Clients[i].Since = time.Time{}
+	}
+	require.ElementsMatch(t, []network.ClientUsage{
+		{Name: "backup", Size: 8, Packfiles: 1, MaxSize: 10},
+		{Name: "other", Size: 5},
+	}, usage.Clients)
+
+	// only an admin resets the usage of a client, which may write again
+	res = doV2(t, h, "backup", "DELETE", "/v2/usage/backup", "")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res = doV2(t, h, "admin", "DELETE", "/v2/usage/nobody", "")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = doV2(t, h, "admin", "DELETE", "/v2/usage/backup", "")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	h = handler()
+	res = doV2(t, h, "backup", "PUT", v2Path("packfiles", 0x12), "12345678")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+}
+
+// sizelessStore fails to tell its size.
+type sizelessStore struct {
+	*ptesting.MockBackend
+}
+
+func (s sizelessStore) Size(ctx context.Context) (int64, error) {
+	return 0, errors.New("size unknown")
+}
+
+func TestUsageErrors(t *testing.T) {
+	store := sizelessStore{ptesting.NewMockBackend(map[string]string{"location": "mock:///?behavior=oneState"})}
+	require.NoError(t, store.Create(context.Background(), nil))
+
+	opts := &Options{}
+	s, err := newServer(context.Background(), store, opts)
+	require.NoError(t, err)
+	h := s.handler(opts)
+
+	// version 1 answers the error inside the response
+	r := httptest.NewRequest("GET", "/usage", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	var usage network.ResGetUsage
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&usage))
+	require.Equal(t, "size unknown", usage.Err)
+
+	// and version 2 as a status code
+	res := doV2(t, h, "", "GET", "/v2/usage", "")
+	require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
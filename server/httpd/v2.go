@@ -0,0 +1,245 @@
+package httpd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// Version 2 of the protocol streams the objects as the bodies of the
+// requests and responses, named by the hex of their MAC in the path, and
+// reports the errors with the status codes.
+
+// v2Error answers r with the status code matching err.
+func v2Error(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errOverwrite), errors.Is(err, errLockRelease):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func v2MAC(w http.ResponseWriter, r *http.Request) (objects.MAC, bool) {
+	var mac objects.MAC
+	raw, err := hex.DecodeString(r.PathValue("mac"))
+	if err != nil || len(raw) != len(mac) {
+		http.Error(w, fmt.Sprintf("invalid MAC %q", r.PathValue("mac")), http.StatusBadRequest)
+		return mac, false
+	}
+	copy(mac[:], raw)
+	return mac, true
+}
+
+func (s *server) v2Open(w http.ResponseWriter, r *http.Request) {
+	serializedConfig, err := s.store.Open(s.ctx)
+	if err != nil {
+		v2Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(serializedConfig)
+}
+
+// v2List returns the handler listing objects, answering their MACs one
+// after the other.
+func (s *server) v2List(list func(context.Context) ([]objects.MAC, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		macs, err := list(r.Context())
+		if err != nil {
+			v2Error(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		for _, mac := range macs {
+			if _, err := w.Write(mac[:]); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// countReader counts the bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// v2Put returns the handler writing an object, which append-only clients
+// may not overwrite, set being nil for the locks.  The states and
+// packfiles count against the quotas, and their length must be known to
+// check them.  Without a quota to check, the bytes read are accounted
+// once written.
+func (s *server) v2Put(set *objectSet, put func(context.Context, objects.MAC, io.Reader) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowed(w, r, false) {
+			return
+		}
+		mac, ok := v2MAC(w, r)
+		if !ok {
+			return
+		}
+
+		if set != nil {
+			write, err := s.appendOnly(r, set, mac, r.Body)
+			if err != nil {
+				v2Error(w, err)
+				return
+			}
+			if !write {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if r.ContentLength < 0 && s.limited(r) {
+				http.Error(w, "unknown length", http.StatusLengthRequired)
+				return
+			}
+			if err := s.reserve(r, max(r.ContentLength, 0), set == s.packfiles); err != nil {
+				v2Error(w, err)
+				return
+			}
+		} else if err := s.lockAllowed(r, mac, false); err != nil {
+			v2Error(w, err)
+			return
+		}
+
+		body := &countReader{r: r.Body}
+		if _, err := put(r.Context(), mac, body); err != nil {
+			if set != nil {
+				s.release(r, max(r.ContentLength, 0), set == s.packfiles)
+			}
+			v2Error(w, err)
+			return
+		}
+		if set != nil {
+			set.add(mac)
+			// without a length, nothing was reserved but the object,
+			// and the client isn't limited
+			if r.ContentLength < 0 {
+				if err := s.reserve(r, body.n, false); err != nil {
+					v2Error(w, err)
+					return
+				}
+			}
+		} else {
+			s.locks.taken(r, mac)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *server) v2Get(get func(context.Context, objects.MAC) (io.ReadCloser, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mac, ok := v2MAC(w, r)
+		if !ok {
+			return
+		}
+
+		rd, err := get(r.Context(), mac)
+		if err != nil {
+			v2Error(w, err)
+			return
+		}
+		defer rd.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.Copy(w, rd)
+	}
+}
+
+// parseRange returns the offset and length of a "bytes=first-last" range.
+// The other ranges are not supported, and are ignored as allowed.
+func parseRange(header string) (offset uint64, length uint32, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseUint(last, 10, 64)
+	if err != nil || end < start || end-start >= 1<<32 {
+		return 0, 0, false
+	}
+	return start, uint32(end - start + 1), true
+}
+
+// v2GetPackfile answers a packfile, or the blob of its Range.
+func (s *server) v2GetPackfile(w http.ResponseWriter, r *http.Request) {
+	mac, ok := v2MAC(w, r)
+	if !ok {
+		return
+	}
+
+	offset, length, ok := parseRange(r.Header.Get("Range"))
+	if !ok {
+		s.v2Get(s.store.GetPackfile)(w, r)
+		return
+	}
+
+	rd, err := s.store.GetPackfileBlob(r.Context(), mac, offset, length)
+	if err != nil {
+		v2Error(w, err)
+		return
+	}
+	defer rd.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+uint64(length)-1))
+	w.WriteHeader(http.StatusPartialContent)
+	io.Copy(w, rd)
+}
+
+func (s *server) v2Delete(set *objectSet, del func(context.Context, objects.MAC) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// locks are taken and released by the clients that may write,
+		// the append-only ones only release their own
+		if !s.allowed(w, r, set != nil) {
+			return
+		}
+		mac, ok := v2MAC(w, r)
+		if !ok {
+			return
+		}
+		if set == nil {
+			if err := s.lockAllowed(r, mac, true); err != nil {
+				v2Error(w, err)
+				return
+			}
+		}
+
+		if err := del(r.Context(), mac); err != nil {
+			v2Error(w, err)
+			return
+		}
+		if set != nil {
+			set.remove(mac)
+			s.deleted()
+		} else {
+			s.locks.released(mac)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
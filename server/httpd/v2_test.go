@@ -0,0 +1,127 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/stretchr/testify/require"
+)
+
+// doV2 sends body as token to h, and returns the response.
+func doV2(t *testing.T, h http.Handler, token, method, path, body string, header ...string) *http.Response {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestNegotiation(t *testing.T) {
+	h := newTestHandler(t, false)
+
+	// clients discover the protocols with the version 1 open
+	r := httptest.NewRequest("GET", "/", strings.NewReader(`{"Repository": ""}`))
+	r.Header.Set("Authorization", "Bearer restore")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resOpen network.ResOpen
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resOpen))
+	require.Contains(t, resOpen.Protocols, network.ProtocolV2)
+
+	res := doV2(t, h, "restore", "GET", "/v2/", "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestV2(t *testing.T) {
+	h := newTestHandler(t, false)
+	path := func(kind string, mac objects.MAC) string {
+		return "/v2/" + kind + "/" + hex.EncodeToString(mac[:])
+	}
+
+	res := doV2(t, h, "restore", "GET", "/v2/states", "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	data, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Len(t, data, 4*len(objects.MAC{}))
+	require.Equal(t, objects.MAC{0x02}, objects.MAC(data[32:64]))
+
+	res = doV2(t, h, "restore", "GET", path("packfiles", objects.MAC{0x04}), "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	data, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, "packfile data", string(data))
+
+	res = doV2(t, h, "restore", "GET", path("packfiles", objects.MAC{0x04}), "", "Range", "bytes=10-19")
+	require.Equal(t, http.StatusPartialContent, res.StatusCode)
+	require.Equal(t, "bytes 10-19/*", res.Header.Get("Content-Range"))
+
+	// unsupported ranges are ignored
+	res = doV2(t, h, "restore", "GET", path("packfiles", objects.MAC{0x04}), "", "Range", "bytes=-10")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = doV2(t, h, "restore", "GET", "/v2/packfiles/04", "")
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = doV2(t, h, "restore", "PUT", path("packfiles", objects.MAC{0x09}), "packfile")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = doV2(t, h, "backup", "PUT", path("packfiles", objects.MAC{0x09}), "packfile")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = doV2(t, h, "backup", "PUT", path("packfiles", objects.MAC{0x04}), "packfile data")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = doV2(t, h, "backup", "PUT", path("packfiles", objects.MAC{0x04}), "ransom")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res = doV2(t, h, "backup", "DELETE", path("states", objects.MAC{0x01}), "")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = doV2(t, h, "admin", "PUT", path("states", objects.MAC{0x01}), "state")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = doV2(t, h, "admin", "DELETE", path("states", objects.MAC{0x01}), "")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+}
+
+func TestParseRange(t *testing.T) {
+	offset, length, ok := parseRange("bytes=0-0")
+	require.True(t, ok)
+	require.Equal(t, uint64(0), offset)
+	require.Equal(t, uint32(1), length)
+
+	offset, length, ok = parseRange("bytes=100-199")
+	require.True(t, ok)
+	require.Equal(t, uint64(100), offset)
+	require.Equal(t, uint32(100), length)
+
+	for _, header := range []string{"", "bytes=10-", "bytes=-10", "bytes=10-5", "bytes=0-1,4-5", "items=0-1"} {
+		_, _, ok = parseRange(header)
+		require.False(t, ok, header)
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	long := bytes.Repeat([]byte("plakar"), 20000)
+
+	same, err := sameContent(bytes.NewReader(long), bytes.NewReader(long))
+	require.NoError(t, err)
+	require.True(t, same)
+
+	same, err = sameContent(bytes.NewReader(long), bytes.NewReader(long[:len(long)-1]))
+	require.NoError(t, err)
+	require.False(t, same)
+
+	same, err = sameContent(bytes.NewReader(nil), bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.True(t, same)
+}
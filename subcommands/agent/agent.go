@@ -32,6 +32,12 @@ func init() {
 			subcommands.BeforeRepositoryOpen|subcommands.AgentSupport|subcommands.IgnoreVersion, "agent", "stop")
 		subcommands.Register(func() subcommands.Subcommand { return &AgentStart{} },
 			subcommands.BeforeRepositoryOpen, "agent", "start")
+		subcommands.Register(func() subcommands.Subcommand { return &AgentJobs{} },
+			subcommands.BeforeRepositoryOpen|subcommands.AgentSupport, "agent", "jobs")
+		subcommands.Register(func() subcommands.Subcommand { return &AgentCancel{} },
+			subcommands.BeforeRepositoryOpen|subcommands.AgentSupport, "agent", "cancel")
+		subcommands.Register(func() subcommands.Subcommand { return &AgentAttach{} },
+			subcommands.BeforeRepositoryOpen|subcommands.AgentSupport, "agent", "attach")
 		subcommands.Register(func() subcommands.Subcommand { return &Agent{} },
 			subcommands.BeforeRepositoryOpen, "agent")
 	}
@@ -40,7 +46,7 @@ func init() {
 func (cmd *Agent) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("agent", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s start | stop\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s start | stop | jobs | cancel | attach\n", flags.Name())
 	}
 	flags.Parse(args)
 
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package agent
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+type AgentCancel struct {
+	subcommands.SubcommandBase
+	jobCommandBase
+
+	Job int64
+}
+
+func (cmd *AgentCancel) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("agent cancel", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s job\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var err error
+	cmd.Job, err = parseJobID(flags)
+	return err
+}
+
+func (cmd *AgentCancel) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	job, err := jobs.get(cmd.client, cmd.Job)
+	if err != nil {
+		return 1, err
+	}
+	job.Cancel()
+	ctx.GetLogger().Info("job %d cancelled", job.ID)
+	return 0, nil
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package agent
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/agent"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
+)
+
+// maxJobOutput caps the output of a job kept for the clients attaching to
+// it, the oldest output being dropped first.
+const maxJobOutput = 1 << 20
+
+// jobClient is a client of the agent: a remote one is known by the name of
+// its certificate.
+type jobClient struct {
+	remote bool
+	name   string
+}
+
+// allows tells whether the client may see and act on job.  The clients of
+// the local socket may on every job, the remote ones on their own only.
+func (client jobClient) allows(job *Job) bool {
+	return !client.remote || job.client == client
+}
+
+// jobCommand is implemented by the commands acting on jobs, which are told
+// the client asking for them by the agent.
+type jobCommand interface {
+	setClient(jobClient)
+}
+
+// jobCommandBase is embedded in the commands acting on jobs.
+type jobCommandBase struct {
+	client jobClient
+}
+
+func (cmd *jobCommandBase) setClient(client jobClient) {
+	cmd.client = client
+}
+
+// Job is a command run by the agent on behalf of a client.
+type Job struct {
+	ID         int64
+	Owner      string
+	Command    string
+	Repository string
+	StartTime  time.Time
+
+	ctx       *appcontext.AppContext
+	collector *reporting.Collector
+	client    jobClient
+
+	mu         sync.Mutex
+	output     []agent.Packet
+	outputSize int
+	clients    map[int]func(agent.Packet)
+	nextClient int
+
+	done   chan struct{}
+	status int
+	err    error
+}
+
+func newJob(ctx *appcontext.AppContext, owner, command, repository string) *Job {
+	return &Job{
+		Owner:      owner,
+		Command:    command,
+		Repository: repository,
+		StartTime:  time.Now(),
+		ctx:        ctx,
+		collector:  reporting.NewCollector(ctx.Events()),
+		clients:    make(map[int]func(agent.Packet)),
+		done:       make(chan struct{}),
+	}
+}
+
+// Attached tells whether a client follows the output of the job.
+func (job *Job) Attached() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return len(job.clients) != 0
+}
+
+// Progress returns the files, directories and bytes processed by the job
+// so far, along with the errors encountered.
+func (job *Job) Progress() (files, directories, size, errorCount uint64) {
+	return job.collector.Progress()
+}
+
+// Cancel interrupts the job.
+func (job *Job) Cancel() {
+	job.ctx.Cancel()
+}
+
+// write keeps an output packet of the job and passes it to the attached
+// clients.
+func (job *Job) write(pkt agent.Packet) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.output = append(job.output, pkt)
+	job.outputSize += len(pkt.Data)
+	for job.outputSize > maxJobOutput && len(job.output) > 1 {
+		job.outputSize -= len(job.output[0].Data)
+		job.output = job.output[1:]
+	}
+
+	for _, write := range job.clients {
+		write(pkt)
+	}
+}
+
+// attach replays the output kept so far to write, then passes it the
+// output of the job until the returned function is called.
+func (job *Job) attach(write func(agent.Packet)) (detach func()) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	for _, pkt := range job.output {
+		write(pkt)
+	}
+
+	id := job.nextClient
+	job.nextClient++
+	job.clients[id] = write
+	return func() {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		delete(job.clients, id)
+	}
+}
+
+// finish records the result of the job, the first one only.
+func (job *Job) finish(status int, err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	select {
+	case <-job.done:
+	default:
+		job.status, job.err = status, err
+		close(job.done)
+	}
+}
+
+// Wait waits for the job to complete and returns its result, unless ctx is
+// done first.
+func (job *Job) Wait(ctx *appcontext.AppContext) (int, error) {
+	select {
+	case <-job.done:
+		return job.status, job.err
+	case <-ctx.Done():
+		return 0, nil
+	}
+}
+
+// parseJobID returns the job given as the single argument of a command.
+func parseJobID(flags *flag.FlagSet) (int64, error) {
+	if flags.NArg() == 0 {
+		return 0, fmt.Errorf("no job specified")
+	}
+	if flags.NArg() > 1 {
+		return 0, fmt.Errorf("too many arguments")
+	}
+	id, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid job %q", flags.Arg(0))
+	}
+	return id, nil
+}
+
+// jobTable tracks the jobs running in the agent.
+type jobTable struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]*Job
+}
+
+var jobs = &jobTable{jobs: make(map[int64]*Job)}
+
+func (t *jobTable) add(job *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	job.ID = t.nextID
+	t.jobs[job.ID] = job
+}
+
+func (t *jobTable) remove(job *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.jobs, job.ID)
+}
+
+// get returns the job id, which client must be allowed to act on.
+func (t *jobTable) get(client jobClient, id int64) (*Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok || !client.allows(job) {
+		return nil, fmt.Errorf("no such job: %d", id)
+	}
+	return job, nil
+}
+
+// list returns the jobs, oldest first.
+func (t *jobTable) list() []*Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]*Job, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		ret = append(ret, job)
+	}
+	slices.SortFunc(ret, func(a, b *Job) int { return int(a.ID - b.ID) })
+	return ret
+}
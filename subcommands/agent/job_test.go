@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/agent"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobOutput(t *testing.T) {
+	ctx, _ := initContext(t, nil, nil)
+	job := newJob(appcontext.NewAppContextFrom(ctx), "alice", "plakar backup", "/var/backups")
+	defer job.ctx.Close()
+
+	job.write(agent.Packet{Type: "stdout", Data: []byte("one\n")})
+	require.False(t, job.Attached())
+
+	var got []string
+	detach := job.attach(func(pkt agent.Packet) {
+		got = append(got, pkt.Type+":"+string(pkt.Data))
+	})
+	require.True(t, job.Attached())
+	job.write(agent.Packet{Type: "stderr", Data: []byte("two\n")})
+	detach()
+	job.write(agent.Packet{Type: "stdout", Data: []byte("three\n")})
+
+	require.False(t, job.Attached())
+	require.Equal(t, []string{"stdout:one\n", "stderr:two\n"}, got)
+
+	big := bytes.Repeat([]byte("x"), maxJobOutput)
+	job.write(agent.Packet{Type: "stdout", Data: big})
+	require.Len(t, job.output, 1)
+	require.Equal(t, maxJobOutput, job.outputSize)
+
+	job.finish(2, fmt.Errorf("failed"))
+	job.finish(0, nil)
+	status, err := job.Wait(ctx)
+	require.Equal(t, 2, status)
+	require.EqualError(t, err, "failed")
+}
+
+func TestAgentJobsCommands(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	ctx, _ := initContext(t, bufOut, bufErr)
+
+	job := newJob(appcontext.NewAppContextFrom(ctx), "alice", "plakar backup /home", "/var/backups")
+	defer job.ctx.Close()
+	jobs.add(job)
+	defer jobs.remove(job)
+	id := fmt.Sprint(job.ID)
+
+	list := &AgentJobs{}
+	require.NoError(t, list.Parse(ctx, nil))
+	status, err := list.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.True(t, strings.HasPrefix(bufOut.String(), id+" "))
+	require.Contains(t, bufOut.String(), "detached")
+	require.Contains(t, bufOut.String(), "plakar backup /home")
+	require.Contains(t, bufOut.String(), "repository: /var/backups")
+
+	job.write(agent.Packet{Type: "stdout", Data: []byte("snapshot done\n")})
+	job.finish(0, nil)
+
+	bufOut.Reset()
+	attach := &AgentAttach{}
+	require.NoError(t, attach.Parse(ctx, []string{id}))
+	status, err = attach.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, "snapshot done\n", bufOut.String())
+
+	cancel := &AgentCancel{}
+	require.NoError(t, cancel.Parse(ctx, []string{id}))
+	status, err = cancel.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Error(t, job.ctx.Err())
+
+	cancel = &AgentCancel{}
+	require.Error(t, cancel.Parse(ctx, []string{"nope"}))
+	require.NoError(t, cancel.Parse(ctx, []string{"0"}))
+	_, err = cancel.Execute(ctx, nil)
+	require.EqualError(t, err, "no such job: 0")
+}
+
+func TestAgentJobsRemoteClients(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	ctx, _ := initContext(t, bufOut, bufErr)
+
+	alice := jobClient{remote: true, name: "alice"}
+	bob := jobClient{remote: true, name: "bob"}
+
+	job := newJob(appcontext.NewAppContextFrom(ctx), "alice (192.0.2.1:4242)", "plakar backup /home", "/var/backups")
+	defer job.ctx.Close()
+	job.client = alice
+	jobs.add(job)
+	defer jobs.remove(job)
+	id := fmt.Sprint(job.ID)
+
+	// other remote clients don't see the job
+	list := &AgentJobs{}
+	list.setClient(bob)
+	_, err := list.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, bufOut.String())
+
+	cancel := &AgentCancel{}
+	require.NoError(t, cancel.Parse(ctx, []string{id}))
+	cancel.setClient(bob)
+	_, err = cancel.Execute(ctx, nil)
+	require.EqualError(t, err, "no such job: "+id)
+	require.NoError(t, job.ctx.Err())
+
+	attach := &AgentAttach{}
+	require.NoError(t, attach.Parse(ctx, []string{id}))
+	attach.setClient(bob)
+	_, err = attach.Execute(ctx, nil)
+	require.EqualError(t, err, "no such job: "+id)
+
+	// the same client, or a local one, do
+	list.setClient(alice)
+	_, err = list.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Contains(t, bufOut.String(), "plakar backup /home")
+
+	cancel.setClient(jobClient{})
+	_, err = cancel.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Error(t, job.ctx.Err())
+}
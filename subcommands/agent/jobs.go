@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package agent
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
+)
+
+type AgentJobs struct {
+	subcommands.SubcommandBase
+	jobCommandBase
+}
+
+func (cmd *AgentJobs) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("agent jobs", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	return nil
+}
+
+func (cmd *AgentJobs) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	for _, job := range jobs.list() {
+		if !cmd.client.allows(job) {
+			continue
+		}
+		state := "detached"
+		if job.Attached() {
+			state = "attached"
+		}
+		fmt.Fprintf(ctx.Stdout, "%-4d %-8s %-25s %-20s %s\n", job.ID, state,
+			job.StartTime.Local().Format(time.RFC3339), job.Owner, job.Command)
+		if job.Repository != "" {
+			fmt.Fprintf(ctx.Stdout, "    repository: %s\n", job.Repository)
+		}
+
+		files, directories, size, errorCount := job.Progress()
+		fmt.Fprintf(ctx.Stdout, "    progress: %d files, %d directories, %s, %d errors in %s\n",
+			files, directories, humanize.IBytes(size), errorCount, time.Since(job.StartTime).Round(time.Second))
+	}
+	return 0, nil
+}
@@ -50,3 +50,8 @@ func daemonize(argv []string) error {
 func kill_self() error {
 	return syscall.Kill(os.Getpid(), syscall.SIGINT)
 }
+
+// reexec replaces the agent with a new run of executable.
+func reexec(executable string) error {
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
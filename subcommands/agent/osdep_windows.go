@@ -17,3 +17,7 @@ func daemonize(argv []string) error {
 func kill_self() error {
 	return errors.ErrUnsupported
 }
+
+func reexec(executable string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+func peerOwner(conn net.Conn) (string, error) {
+	return "", errors.ErrUnsupported
+}
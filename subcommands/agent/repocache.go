@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// repoCache keeps the repositories opened by the agent for the following
+// commands using them, which saves opening their store and rebuilding
+// their state.  At most size of them are kept, for ttl once idle.
+type repoCache struct {
+	ctx  *appcontext.AppContext
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cachedRepo
+}
+
+type cachedRepo struct {
+	key    string
+	store  storage.Store
+	repo   *repository.Repository
+	secret []byte
+	states []objects.MAC
+
+	// ctx is the context of repo.  Repositories keep the context they
+	// are opened with, so it is set to the one of the command using
+	// the repository, and back to the one of the cache once it is done.
+	ctx *kcontext.KContext
+
+	busy     bool
+	lastUsed time.Time
+}
+
+func newRepoCache(ctx *appcontext.AppContext, size int, ttl time.Duration) *repoCache {
+	return &repoCache{
+		ctx:     ctx,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*cachedRepo),
+	}
+}
+
+// repoCacheKey identifies a repository by the configuration of its store
+// and the secret it is opened with.
+func repoCacheKey(storeConfig map[string]string, secret []byte) string {
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(storeConfig)) {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(storeConfig[k]))
+		h.Write([]byte{0})
+	}
+	h.Write(secret)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedStates(states []objects.MAC) []objects.MAC {
+	states = slices.Clone(states)
+	slices.SortFunc(states, func(a, b objects.MAC) int { return slices.Compare(a[:], b[:]) })
+	return states
+}
+
+// get returns the cached repository for key, if any and not in use, for
+// ctx to use until release is called.  Its state is rebuilt if states were
+// added to or removed from the store since it was last used.
+func (c *repoCache) get(ctx *appcontext.AppContext, key string) (repo *repository.Repository, release func(), ok bool) {
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if !found || entry.busy {
+		c.mu.Unlock()
+		return nil, nil, false
+	}
+	entry.busy = true
+	c.mu.Unlock()
+
+	*entry.ctx = *ctx.GetInner()
+
+	states, err := entry.store.GetStates(ctx)
+	if err == nil {
+		states = sortedStates(states)
+		if !slices.Equal(states, entry.states) {
+			ctx.GetLogger().Trace("agent", "repository cache: %s changed, rebuilding its state", key[:8])
+			err = entry.repo.RebuildState()
+			entry.states = states
+		}
+	}
+	if err != nil {
+		ctx.GetLogger().Warn("failed to refresh cached repository: %v", err)
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+		c.close(entry)
+		return nil, nil, false
+	}
+
+	ctx.SetSecret(entry.secret)
+	return entry.repo, func() { c.release(entry) }, true
+}
+
+// open opens the repository for key in store with ctx, and caches it for
+// the following commands once release is called.  The store must have
+// been opened with the context of the cache, and is closed along with the
+// repository.
+func (c *repoCache) open(ctx *appcontext.AppContext, key string, store storage.Store, serializedConfig []byte) (*repository.Repository, func(), error) {
+	entry := &cachedRepo{
+		key:    key,
+		store:  store,
+		secret: ctx.GetSecret(),
+		busy:   true,
+	}
+	kctx := *ctx.GetInner()
+	entry.ctx = &kctx
+
+	var err error
+	entry.repo, err = repository.New(entry.ctx, entry.secret, store, serializedConfig)
+	if err != nil {
+		store.Close(c.ctx)
+		return nil, nil, err
+	}
+	states, err := store.GetStates(ctx)
+	if err != nil {
+		c.close(entry)
+		return nil, nil, err
+	}
+	entry.states = sortedStates(states)
+
+	c.mu.Lock()
+	if _, found := c.entries[key]; found || c.size == 0 {
+		// another command cached it in the meantime
+		c.mu.Unlock()
+		return entry.repo, func() { c.close(entry) }, nil
+	}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	return entry.repo, func() { c.release(entry) }, nil
+}
+
+func (c *repoCache) release(entry *cachedRepo) {
+	*entry.ctx = *c.ctx.GetInner()
+
+	c.mu.Lock()
+	entry.busy = false
+	entry.lastUsed = time.Now()
+	evicted := c.evict(c.size)
+	c.mu.Unlock()
+
+	for _, entry := range evicted {
+		c.close(entry)
+	}
+}
+
+// evict removes the least recently used idle entries until at most size
+// of them remain, and returns them.
+func (c *repoCache) evict(size int) []*cachedRepo {
+	var ret []*cachedRepo
+	for len(c.entries) > size {
+		var oldest *cachedRepo
+		for _, entry := range c.entries {
+			if !entry.busy && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldest = entry
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		delete(c.entries, oldest.key)
+		ret = append(ret, oldest)
+	}
+	return ret
+}
+
+// expire removes the entries idle for longer than the ttl, and returns
+// them.
+func (c *repoCache) expire(now time.Time) []*cachedRepo {
+	var ret []*cachedRepo
+	for key, entry := range c.entries {
+		if !entry.busy && now.Sub(entry.lastUsed) > c.ttl {
+			delete(c.entries, key)
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+func (c *repoCache) close(entry *cachedRepo) {
+	if err := entry.repo.Close(); err != nil {
+		c.ctx.GetLogger().Warn("failed to close cached repository: %v", err)
+	}
+	if err := entry.store.Close(c.ctx); err != nil {
+		c.ctx.GetLogger().Warn("failed to close cached store: %v", err)
+	}
+}
+
+// run expires the idle entries until the context of the cache is done.
+func (c *repoCache) run() {
+	ticker := time.NewTicker(max(c.ttl/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			expired := c.expire(now)
+			c.mu.Unlock()
+			for _, entry := range expired {
+				c.close(entry)
+			}
+		}
+	}
+}
+
+// Close closes the idle entries and stops caching.
+func (c *repoCache) Close() {
+	c.mu.Lock()
+	c.size = 0
+	evicted := c.evict(0)
+	c.mu.Unlock()
+
+	for _, entry := range evicted {
+		c.close(entry)
+	}
+	c.ctx.Close()
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoCache(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	location, err := repo.Location()
+	require.NoError(t, err)
+	storeConfig := map[string]string{"location": location}
+
+	cache := newRepoCache(appcontext.NewAppContextFrom(ctx), 1, time.Minute)
+	defer cache.Close()
+
+	open := func(ctx *appcontext.AppContext, key string) (*appcontext.AppContext, func()) {
+		store, serializedConfig, err := storage.Open(cache.ctx.GetInner(), storeConfig)
+		require.NoError(t, err)
+		_, release, err := cache.open(ctx, key, store, serializedConfig)
+		require.NoError(t, err)
+		return ctx, release
+	}
+
+	key := repoCacheKey(storeConfig, nil)
+	require.Equal(t, key, repoCacheKey(map[string]string{"location": location}, nil))
+	require.NotEqual(t, key, repoCacheKey(storeConfig, []byte("secret")))
+
+	ctx1 := appcontext.NewAppContextFrom(ctx)
+	_, _, ok := cache.get(ctx1, key)
+	require.False(t, ok)
+	_, release := open(ctx1, key)
+	release()
+
+	// a new snapshot adds a state the cached repository must pick up
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello"),
+	})
+	snap.Close()
+
+	ctx2 := appcontext.NewAppContextFrom(ctx)
+	cached, release, ok := cache.get(ctx2, key)
+	require.True(t, ok)
+	require.Equal(t, ctx2.Events(), cached.AppContext().Events())
+	require.Equal(t, 1, len(slices.Collect(cached.ListSnapshots())))
+
+	// the repository is not shared while in use
+	_, _, ok = cache.get(appcontext.NewAppContextFrom(ctx), key)
+	require.False(t, ok)
+	release()
+	require.Equal(t, cache.ctx.Events(), cached.AppContext().Events())
+
+	// a repository opened with another secret evicts the least recently
+	// used one
+	other := repoCacheKey(storeConfig, []byte("other"))
+	_, release = open(appcontext.NewAppContextFrom(ctx), other)
+	release()
+	_, _, ok = cache.get(appcontext.NewAppContextFrom(ctx), key)
+	require.False(t, ok)
+
+	cache.mu.Lock()
+	expired := cache.expire(time.Now().Add(2 * time.Minute))
+	cache.mu.Unlock()
+	require.Len(t, expired, 1)
+	for _, entry := range expired {
+		cache.close(entry)
+	}
+	require.Empty(t, cache.entries)
+}
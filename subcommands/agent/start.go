@@ -18,6 +18,7 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
@@ -37,6 +38,7 @@ import (
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands"
 	psync "github.com/PlakarKorp/plakar/subcommands/sync"
 	"github.com/PlakarKorp/plakar/task"
@@ -51,12 +53,31 @@ type AgentStart struct {
 	socketPath string
 	listener   net.Listener
 
-	teardown time.Duration
+	teardown  time.Duration
+	cacheSize int
+	cacheTTL  time.Duration
+
+	listenAddr string
+	tlsConfig  *tls.Config
+
+	// executable is the binary of the agent, which restarts when it is
+	// replaced by one speaking a newer protocol.
+	executable     string
+	executableInfo os.FileInfo
 }
 
+// remoteRequestTimeout bounds the time a remote client has to complete the
+// TLS handshake and send its command.
+const remoteRequestTimeout = 30 * time.Second
+
+// errRestart is returned by ListenAndServe once the jobs are drained before
+// restarting the agent.
+var errRestart = errors.New("agent restarting")
+
 func (cmd *AgentStart) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_foreground bool
 	var opt_logfile string
+	var opt_tlsCert, opt_tlsKey, opt_tlsCA string
 
 	_, envAgentLess := os.LookupEnv("PLAKAR_AGENTLESS")
 	if envAgentLess {
@@ -73,10 +94,30 @@ func (cmd *AgentStart) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 
 	flags.DurationVar(&cmd.teardown, "teardown", 5*time.Second, "delay before tearing down the agent")
+	flags.IntVar(&cmd.cacheSize, "cache-size", 8, "maximum number of repositories kept open")
+	flags.DurationVar(&cmd.cacheTTL, "cache-ttl", 5*time.Minute, "delay before closing an unused repository")
+	flags.StringVar(&cmd.listenAddr, "listen", "", "also listen for TLS clients on this address")
+	flags.StringVar(&opt_tlsCert, "tls-cert", "", "certificate of the agent, with -listen")
+	flags.StringVar(&opt_tlsKey, "tls-key", "", "key of the agent certificate, with -listen")
+	flags.StringVar(&opt_tlsCA, "tls-ca", "", "certificate authority of the clients, with -listen")
 	flags.Parse(args)
 	if flags.NArg() != 0 {
 		return fmt.Errorf("too many arguments")
 	}
+	if cmd.cacheSize < 0 {
+		return fmt.Errorf("invalid cache size: %d", cmd.cacheSize)
+	}
+
+	if cmd.listenAddr != "" {
+		if opt_tlsCert == "" || opt_tlsKey == "" || opt_tlsCA == "" {
+			return fmt.Errorf("-listen requires -tls-cert, -tls-key and -tls-ca")
+		}
+		var err error
+		cmd.tlsConfig, err = agent.ServerTLSConfig(opt_tlsCert, opt_tlsKey, opt_tlsCA)
+		if err != nil {
+			return err
+		}
+	}
 
 	if !opt_foreground && os.Getenv("REEXEC") == "" {
 		err := daemonize(os.Args)
@@ -131,13 +172,40 @@ func (cmd *AgentStart) Execute(ctx *appcontext.AppContext, repo *repository.Repo
 	// Safe to ignore here.
 	f.Close()
 
-	if err := cmd.ListenAndServe(ctx); err != nil {
+	if cmd.executable, err = os.Executable(); err == nil {
+		cmd.executableInfo, _ = os.Stat(cmd.executable)
+	}
+
+	// Keep retrying the reports that could not be sent so far.
+	reporter := reporting.NewReporter(ctx)
+	reporter.DeliverSpooled()
+
+	err = cmd.ListenAndServe(ctx)
+	reporter.StopAndWait()
+	if errors.Is(err, errRestart) {
+		ctx.GetLogger().Info("Restarting %s", cmd.executable)
+		err = reexec(cmd.executable)
+	}
+	if err != nil {
 		return 1, err
 	}
 	ctx.GetLogger().Info("Server gracefully stopped")
 	return 0, nil
 }
 
+// upgraded tells whether the binary of the agent was replaced since it
+// started.
+func (cmd *AgentStart) upgraded() bool {
+	if cmd.executableInfo == nil {
+		return false
+	}
+	info, err := os.Stat(cmd.executable)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(info, cmd.executableInfo) || !info.ModTime().Equal(cmd.executableInfo.ModTime())
+}
+
 func (cmd *AgentStart) ListenAndServe(ctx *appcontext.AppContext) error {
 	lock, err := agent.LockedFile(cmd.socketPath + ".agent-lock")
 	if err != nil {
@@ -158,56 +226,187 @@ func (cmd *AgentStart) ListenAndServe(ctx *appcontext.AppContext) error {
 		return fmt.Errorf("failed to bind the socket: %w", err)
 	}
 
+	listeners := []net.Listener{listener}
+	if cmd.listenAddr != "" {
+		tcpListener, err := tls.Listen("tcp", cmd.listenAddr, cmd.tlsConfig)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to listen on %s: %w", cmd.listenAddr, err)
+		}
+		ctx.GetLogger().Info("listening on %s", tcpListener.Addr())
+		listeners = append(listeners, tcpListener)
+	}
+
+	repos := newRepoCache(appcontext.NewAppContextFrom(ctx), cmd.cacheSize, cmd.cacheTTL)
+	defer repos.Close()
+	go repos.run()
+
+	// A client too recent for us asks for a restart, once the binary
+	// was upgraded: we stop accepting commands, so that clients start a
+	// new agent, and restart once our jobs are over.
+	restart := make(chan struct{})
+	var restartOnce sync.Once
+	var restarting atomic.Bool
+	requestRestart := func() bool {
+		if !cmd.upgraded() {
+			return false
+		}
+		restartOnce.Do(func() { close(restart) })
+		return true
+	}
+
 	cancelled := false
 	go func() {
-		<-ctx.Done()
-		cancelled = true
-		listener.Close()
+		select {
+		case <-ctx.Done():
+			cancelled = true
+		case <-restart:
+			ctx.GetLogger().Info("agent upgraded, draining %d jobs before restarting", len(jobs.list()))
+			restarting.Store(true)
+		}
+		for _, listener := range listeners {
+			listener.Close()
+		}
 	}()
 
 	var inflight atomic.Int64
 	var nextID atomic.Int64
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			if cancelled {
-				return ctx.Err()
-			}
+	var handlers sync.WaitGroup
+	serve := func(listener net.Listener) error {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if cancelled {
+					return ctx.Err()
+				}
 
-			if opErr, ok := err.(*net.OpError); ok && opErr.Err.Error() == "use of closed network connection" {
-				return nil
+				if opErr, ok := err.(*net.OpError); ok && opErr.Err.Error() == "use of closed network connection" {
+					return nil
+				}
+				// TODO: we should retry / wait and retry on
+				// some errors, not everything is fatal.
+				return err
 			}
-			// TODO: we should retry / wait and retry on
-			// some errors, not everything is fatal.
-			return err
-		}
-
-		inflight.Add(1)
 
-		go func() {
-			myid := nextID.Add(1)
-			defer func() {
-				n := inflight.Add(-1)
-				if n == 0 {
-					time.Sleep(cmd.teardown)
-					if nextID.Load() == myid && inflight.Load() == 0 {
-						listener.Close()
+			inflight.Add(1)
+			handlers.Add(1)
+
+			go func() {
+				myid := nextID.Add(1)
+				defer func() {
+					n := inflight.Add(-1)
+					// An agent reachable from other hosts
+					// can't be spawned again on demand.
+					if n == 0 && cmd.listenAddr == "" {
+						time.Sleep(cmd.teardown)
+						if nextID.Load() == myid && inflight.Load() == 0 {
+							listener.Close()
+						}
 					}
+				}()
+				defer handlers.Done()
+
+				if err := ctx.ReloadConfig(); err != nil {
+					ctx.GetLogger().Warn("could not load configuration: %v", err)
 				}
-			}()
 
-			if err := ctx.ReloadConfig(); err != nil {
-				ctx.GetLogger().Warn("could not load configuration: %v", err)
-			}
+				handleClient(ctx, conn, repos, requestRestart)
+			}()
+		}
+	}
 
-			handleClient(ctx, conn)
+	errc := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			errc <- serve(listener)
 		}()
 	}
+
+	// Stop serving as soon as one of the listeners is done.
+	err = <-errc
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for range len(listeners) - 1 {
+		<-errc
+	}
+
+	if restarting.Load() {
+		handlers.Wait()
+		return errRestart
+	}
+	return err
 }
 
-func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
+// connOwner returns who is connected to the other end of conn: the subject
+// of its certificate for remote clients.
+func connOwner(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return peerOwner(conn)
+	}
+	name, err := certName(tlsConn)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s (%s)", name, conn.RemoteAddr()), nil
+}
+
+// certName returns the common name of the certificate of a remote client.
+func certName(conn *tls.Conn) (string, error) {
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no client certificate")
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+// connClient returns the client of conn, as the jobs know it.
+func connClient(conn net.Conn) (jobClient, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return jobClient{}, nil
+	}
+	name, err := certName(tlsConn)
+	if err != nil {
+		return jobClient{}, err
+	}
+	return jobClient{remote: true, name: name}, nil
+}
+
+// isRemote tells whether conn comes from the TCP listener rather than the
+// local socket.
+func isRemote(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+// commandKey returns the key of storeConfig naming a command to run, if
+// any, which the remote clients may not give.
+func commandKey(storeConfig map[string]string) (string, bool) {
+	for key := range storeConfig {
+		if strings.HasSuffix(key, "_cmd") || strings.HasSuffix(key, "_command") {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// handleClient runs the command of the client on conn.  It calls restart
+// when the client speaks a protocol too recent for us, which returns
+// whether the agent will restart.
+func handleClient(ctx *appcontext.AppContext, conn net.Conn, repos *repoCache, restart func() bool) {
 	defer conn.Close()
 
+	// The remote peers must authenticate and send their command in time,
+	// not hold the connection idle.
+	if isRemote(conn) {
+		conn.SetDeadline(time.Now().Add(remoteRequestTimeout))
+	}
+
 	mu := sync.Mutex{}
 
 	var encodingErrorOccurred bool
@@ -218,14 +417,31 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 	defer clientContext.Close()
 
 	// handshake
-	var (
-		clientvers []byte
-		ourvers    = []byte(utils.GetVersion())
-	)
-	if err := decoder.Decode(&clientvers); err != nil {
+	var clienthello []byte
+	if err := decoder.Decode(&clienthello); err != nil {
 		return
 	}
-	if err := encoder.Encode(ourvers); err != nil {
+	reply := []byte(utils.GetVersion())
+	negotiated := &agent.Hello{}
+	if hello, err := agent.DecodeHello(clienthello); err != nil {
+		// the client predates the negotiation and only checks that
+		// our version matches its own.
+		ctx.GetLogger().Info("client %s predates the protocol negotiation", string(clienthello))
+	} else {
+		negotiated = agent.Negotiate(hello)
+		if negotiated.Incompatible() {
+			ctx.GetLogger().Warn("client %s speaks protocol %d to %d, we speak %d to %d",
+				hello.Version, hello.MinProtocol, hello.Protocol,
+				agent.MinProtocolVersion, agent.ProtocolVersion)
+			if hello.MinProtocol > agent.ProtocolVersion {
+				negotiated.Restarting = restart()
+			}
+		}
+		if reply, err = agent.EncodeHello(negotiated); err != nil {
+			return
+		}
+	}
+	if err := encoder.Encode(reply); err != nil {
 		return
 	}
 
@@ -246,18 +462,50 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		}
 	}
 
+	// Once detached, the client is gone and the output of its job is
+	// only kept for the clients attaching to it later.
+	var detached atomic.Bool
+	var job *Job
+	output := func(packet agent.Packet) {
+		if job != nil {
+			job.write(packet)
+		} else {
+			write(packet)
+		}
+	}
+
+	exit := func(status int, err error) {
+		if job != nil {
+			job.finish(status, err)
+		}
+		if detached.Load() {
+			return
+		}
+		// the events come before the exit
+		clientContext.Events().Send(agent.Flush{})
+		errStr := ""
+		if err != nil {
+			errStr = err.Error()
+		}
+		write(agent.Packet{
+			Type:     "exit",
+			ExitCode: status,
+			Err:      errStr,
+		})
+	}
+
 	stdinchan := make(chan agent.Packet, 1)
 	defer close(stdinchan)
 
 	processStdout := func(data string) {
-		write(agent.Packet{
+		output(agent.Packet{
 			Type: "stdout",
 			Data: []byte(data),
 		})
 	}
 
 	processStderr := func(data string) {
-		write(agent.Packet{
+		output(agent.Packet{
 			Type: "stderr",
 			Data: []byte(data),
 		})
@@ -282,6 +530,74 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		return
 	}
 
+	subcommand, _, _ := subcommands.Lookup(name)
+	if subcommand == nil {
+		ctx.GetLogger().Warn("unknown command received: %s", name)
+		fmt.Fprintf(clientContext.Stderr, "unknown command received %s\n", name)
+		return
+	}
+	if err := msgpack.Unmarshal(request, &subcommand); err != nil {
+		ctx.GetLogger().Warn("Failed to decode client request: %v", err)
+		fmt.Fprintf(clientContext.Stderr, "Failed to decode client request: %s\n", err)
+		return
+	}
+
+	// The remote clients may not run commands on this host, nor stop
+	// the agent, and only see their own jobs.
+	client, err := connClient(conn)
+	if err != nil {
+		ctx.GetLogger().Warn("failed to identify client %s: %v", conn.RemoteAddr(), err)
+		fmt.Fprintf(clientContext.Stderr, "%s\n", err)
+		return
+	}
+	if client.remote {
+		if subcommand.GetFlags()&subcommands.AgentSupport == 0 {
+			ctx.GetLogger().Warn("refused %s from remote client %s", strings.Join(name, " "), conn.RemoteAddr())
+			fmt.Fprintf(clientContext.Stderr, "%s can't run in the agent\n", strings.Join(name, " "))
+			return
+		}
+		if key, ok := commandKey(storeConfig); ok {
+			ctx.GetLogger().Warn("refused %s from remote client %s", key, conn.RemoteAddr())
+			fmt.Fprintf(clientContext.Stderr, "%s is not allowed from remote clients\n", key)
+			return
+		}
+		if runner, ok := subcommand.(subcommands.CommandRunner); ok && runner.RunsCommands() {
+			ctx.GetLogger().Warn("refused command hooks from remote client %s", conn.RemoteAddr())
+			fmt.Fprintf(clientContext.Stderr, "hooks are not allowed from remote clients\n")
+			return
+		}
+		if _, ok := subcommand.(*AgentStop); ok {
+			ctx.GetLogger().Warn("refused to stop for remote client %s", conn.RemoteAddr())
+			fmt.Fprintf(clientContext.Stderr, "the agent may only be stopped from its local socket\n")
+			return
+		}
+	}
+	if cmd, ok := subcommand.(jobCommand); ok {
+		cmd.setClient(client)
+	}
+	conn.SetDeadline(time.Time{})
+
+	// The commands controlling the agent itself are not jobs.
+	detach := func() {}
+	if name[0] != "agent" {
+		owner, err := connOwner(conn)
+		if err != nil {
+			owner = ctx.Username
+		}
+		command := subcommand.GetCommandLine()
+		if command == "" {
+			command = strings.Join(name, " ")
+		}
+		job = newJob(clientContext, owner, command, storeConfig["location"])
+		job.client = client
+		detach = job.attach(write)
+		jobs.add(job)
+		defer func() {
+			jobs.remove(job)
+			job.finish(1, fmt.Errorf("job aborted"))
+		}()
+	}
+
 	// Attempt another decode to detect client disconnection during processing
 	go func() {
 		for {
@@ -293,24 +609,23 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 				clientContext.Close()
 				return
 			}
-			if pkt.Type == "stdin" {
+			switch pkt.Type {
+			case "stdin":
 				stdinchan <- pkt
+			case "detach":
+				if job == nil {
+					processStderr("the agent commands can't be detached\n")
+					continue
+				}
+				detach()
+				detached.Store(true)
+				write(agent.Packet{Type: "detached", Job: job.ID})
+				ctx.GetLogger().Info("job %d detached", job.ID)
+				return
 			}
 		}
 	}()
 
-	subcommand, _, _ := subcommands.Lookup(name)
-	if subcommand == nil {
-		ctx.GetLogger().Warn("unknown command received: %s", name)
-		fmt.Fprintf(clientContext.Stderr, "unknown command received %s\n", name)
-		return
-	}
-	if err := msgpack.Unmarshal(request, &subcommand); err != nil {
-		ctx.GetLogger().Warn("Failed to decode client request: %v", err)
-		fmt.Fprintf(clientContext.Stderr, "Failed to decode client request: %s\n", err)
-		return
-	}
-
 	if subcommand.GetLogInfo() {
 		clientContext.GetLogger().EnableInfo()
 	}
@@ -320,8 +635,8 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 
 	ctx.GetLogger().Info("%s at %s", strings.Join(name, " "), storeConfig["location"])
 
-	var store storage.Store
 	var repo *repository.Repository
+	cacheKey := repoCacheKey(storeConfig, subcommand.GetRepositorySecret())
 
 	if subcommand.GetFlags()&subcommands.BeforeRepositoryOpen != 0 {
 		// nop
@@ -333,29 +648,34 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 			return
 		}
 		defer repo.Close()
+	} else if cached, release, ok := repos.get(clientContext, cacheKey); ok {
+		clientContext.GetLogger().Trace("agent", "using cached repository")
+		repo = cached
+		defer release()
 	} else {
 		var serializedConfig []byte
-		store, serializedConfig, err = storage.Open(clientContext.GetInner(), storeConfig)
+		store, serializedConfig, err := storage.Open(repos.ctx.GetInner(), storeConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to open storage: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "Failed to open storage: %s\n", err)
+			exit(1, fmt.Errorf("failed to open storage: %w", err))
 			return
 		}
-		defer store.Close(ctx)
-		err := setupSecret(clientContext, subcommand, storeConfig, serializedConfig)
+		err = setupSecret(clientContext, subcommand, storeConfig, serializedConfig)
 		if err != nil {
+			store.Close(ctx)
 			clientContext.GetLogger().Warn("Failed to setup secret: %v", err)
 			fmt.Fprintf(clientContext.Stderr, "Failed to stup secret: %s\n", err)
 			return
 		}
 
-		repo, err = repository.New(clientContext.GetInner(), clientContext.GetSecret(), store, serializedConfig)
+		var release func()
+		repo, release, err = repos.open(clientContext, cacheKey, store, serializedConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to open repository: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "Failed to open repository: %s\n", err)
+			exit(1, fmt.Errorf("failed to open repository: %w", err))
 			return
 		}
-		defer repo.Close()
+		defer release()
 	}
 
 	if synccmd, ok := subcommand.(*psync.Sync); ok {
@@ -366,17 +686,26 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		}
 	}
 
-	status, err := task.RunCommand(clientContext, subcommand, repo, "@agent")
-
-	errStr := ""
-	if err != nil {
-		errStr = err.Error()
+	if negotiated.Supports(agent.CapEvents) {
+		// Only the client running the command gets its events,
+		// not the ones attaching to its job.
+		evts := clientContext.Events().Listen()
+		go func() {
+			for event := range evts {
+				if detached.Load() || !agent.Forwarded(event) {
+					continue
+				}
+				packet, err := agent.EventPacket(event)
+				if err != nil {
+					continue
+				}
+				write(packet)
+			}
+		}()
 	}
-	write(agent.Packet{
-		Type:     "exit",
-		ExitCode: status,
-		Err:      errStr,
-	})
+
+	status, err := task.RunCommand(clientContext, subcommand, repo, "@agent")
+	exit(status, err)
 
 	clientContext.Close()
 }
@@ -0,0 +1,16 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandKey(t *testing.T) {
+	_, ok := commandKey(map[string]string{"location": "/var/backups", "passphrase": "secret"})
+	require.False(t, ok)
+
+	key, ok := commandKey(map[string]string{"location": "/var/backups", "passphrase_cmd": "pass show plakar"})
+	require.True(t, ok)
+	require.Equal(t, "passphrase_cmd", key)
+}
@@ -79,6 +79,8 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_ignore_file string
 	var opt_ignore ignoreFlags
 	var opt_tags tagFlags
+	var opt_hook_timeout time.Duration
+	var opt_hook_abort bool
 
 	excludes := []string{}
 
@@ -103,6 +105,14 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.Var(utils.NewOptsFlag(cmd.Opts), "o", "specify extra importer options")
 	flags.BoolVar(&cmd.DryRun, "scan", false, "do not actually perform a backup, just list the files")
 	flags.Var(locate.NewTimeFlag(&cmd.ForcedTimestamp), "force-timestamp", "force a timestamp")
+	flags.StringVar(&cmd.PreHook.Command, "pre-hook", "", "shell command to run before the backup")
+	flags.StringVar(&cmd.PostHook.Command, "post-hook", "", "shell command to run after the backup")
+	flags.DurationVar(&opt_hook_timeout, "hook-timeout", 0, "kill the hooks if they run longer than this duration")
+	flags.BoolVar(&opt_hook_abort, "hook-abort", false, "abort the backup if the pre-hook fails and fail it if the post-hook fails")
+	flags.DurationVar(&cmd.PreHook.Timeout, "pre-hook-timeout", 0, "kill the pre-hook if it runs longer than this duration, overrides -hook-timeout")
+	flags.DurationVar(&cmd.PostHook.Timeout, "post-hook-timeout", 0, "kill the post-hook if it runs longer than this duration, overrides -hook-timeout")
+	flags.BoolVar(&cmd.PreHook.AbortOnFailure, "pre-hook-abort", false, "abort the backup if the pre-hook fails, overrides -hook-abort")
+	flags.BoolVar(&cmd.PostHook.AbortOnFailure, "post-hook-abort", false, "fail the backup if the post-hook fails, overrides -hook-abort")
 	//flags.BoolVar(&opt_stdio, "stdio", false, "output one line per file to stdout instead of the default interactive output")
 	flags.Parse(args)
 
@@ -130,6 +140,22 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 		excludes = append(excludes, item)
 	}
 
+	// -hook-timeout and -hook-abort apply to the hooks lacking their own
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["pre-hook-timeout"] {
+		cmd.PreHook.Timeout = opt_hook_timeout
+	}
+	if !set["post-hook-timeout"] {
+		cmd.PostHook.Timeout = opt_hook_timeout
+	}
+	if !set["pre-hook-abort"] {
+		cmd.PreHook.AbortOnFailure = opt_hook_abort
+	}
+	if !set["post-hook-abort"] {
+		cmd.PostHook.AbortOnFailure = opt_hook_abort
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Excludes = excludes
 	cmd.Path = flags.Arg(0)
@@ -157,6 +183,8 @@ type Backup struct {
 	DryRun              bool
 	PackfileTempStorage string
 	ForcedTimestamp     time.Time
+	PreHook             Hook
+	PostHook            Hook
 }
 
 func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -164,7 +192,7 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 	return ret, err
 }
 
-func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
+func (cmd *Backup) doBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
 	opts := &snapshot.BackupOptions{
 		MaxConcurrency: cmd.Concurrency,
 		Name:           "default",
@@ -229,6 +257,8 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		cmd.PackfileTempStorage = ""
 	}
 
+	// The repository may outlive the command when the agent caches it.
+	wbytes := repo.WBytes()
 	snap, err := snapshot.Create(repo, repository.DefaultType, cmd.PackfileTempStorage)
 	if err != nil {
 		ctx.GetLogger().Error("%s", err)
@@ -292,7 +322,7 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		snap.Header.GetIndexShortID(),
 		humanize.IBytes(totalSize),
 		snap.Header.Duration,
-		humanize.IBytes(uint64(snap.Repository().WBytes())),
+		humanize.IBytes(uint64(snap.Repository().WBytes()-wbytes)),
 	)
 
 	totalErrors := uint64(0)
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integration-fs/importer"
 	bfs "github.com/PlakarKorp/integration-fs/storage"
 	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/hashing"
 	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/storage"
@@ -153,3 +157,88 @@ func TestExecuteCmdCreateDefaultWithIgnores(t *testing.T) {
 	output := bufOut.String()
 	require.NotContains(t, output, "/subdir")
 }
+
+func TestExecuteCmdCreateWithHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run through /bin/sh")
+	}
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	ctx.MaxConcurrency = 1
+	hookOutput := filepath.Join(t.TempDir(), "hook.out")
+	args := []string{
+		"-pre-hook", "echo $PLAKAR_HOOK > " + hookOutput,
+		"-post-hook", "echo $PLAKAR_HOOK $PLAKAR_BACKUP_STATUS $PLAKAR_SNAPSHOT_ID >> " + hookOutput,
+		"-hook-timeout", "10s",
+		tmpBackupDir,
+	}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(hookOutput)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("pre\npost success %x\n", snapshotID), string(data))
+}
+
+func TestExecuteCmdCreatePreHookAbort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run through /bin/sh")
+	}
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	ctx.MaxConcurrency = 1
+
+	// without -hook-abort the failure is only a warning
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, []string{"-pre-hook", "exit 1", tmpBackupDir})
+	require.NoError(t, err)
+
+	status, err, _, warning := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.ErrorContains(t, warning, "pre-hook failed")
+
+	subcommand = &Backup{}
+	err = subcommand.Parse(ctx, []string{"-pre-hook", "sleep 5", "-hook-timeout", "100ms", "-hook-abort", tmpBackupDir})
+	require.NoError(t, err)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.ErrorContains(t, err, "timed out")
+	require.Equal(t, 1, status)
+	require.Equal(t, objects.NilMac, snapshotID)
+}
+
+func TestParseHookOptions(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	_, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	subcommand := &Backup{}
+	require.False(t, subcommand.RunsCommands())
+
+	err := subcommand.Parse(ctx, []string{
+		"-pre-hook", "true", "-post-hook", "true",
+		"-hook-timeout", "1m", "-post-hook-timeout", "5s",
+		"-pre-hook-abort",
+		tmpBackupDir,
+	})
+	require.NoError(t, err)
+	require.True(t, subcommand.RunsCommands())
+	require.Equal(t, Hook{Command: "true", Timeout: time.Minute, AbortOnFailure: true}, subcommand.PreHook)
+	require.Equal(t, Hook{Command: "true", Timeout: 5 * time.Second}, subcommand.PostHook)
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// Hook is a shell command run before or after a backup, for instance to
+// quiesce a database or take a filesystem snapshot.
+type Hook struct {
+	Command string
+
+	// Timeout after which the command is killed, zero means no limit.
+	Timeout time.Duration
+
+	// A failing pre-hook aborts the backup, a failing post-hook makes
+	// it fail.  Otherwise the failure is only reported as a warning.
+	AbortOnFailure bool
+}
+
+func (h Hook) IsSet() bool {
+	return h.Command != ""
+}
+
+// RunsCommands tells whether the backup has hooks to run.
+func (cmd *Backup) RunsCommands() bool {
+	return cmd.PreHook.IsSet() || cmd.PostHook.IsSet()
+}
+
+// Run executes the hook through the shell with env appended to the
+// environment of the current process.
+func (h Hook) Run(ctx *appcontext.AppContext, env []string, stdout, stderr io.Writer) error {
+	var hookCtx context.Context = ctx
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		hookCtx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(hookCtx, "cmd", "/C", h.Command)
+	} else {
+		cmd = exec.CommandContext(hookCtx, "/bin/sh", "-c", h.Command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// don't wait on children still holding the output open once killed
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", h.Timeout)
+	}
+	return err
+}
+
+func (cmd *Backup) hookEnv(stage string) []string {
+	return []string{
+		"PLAKAR_HOOK=" + stage,
+		"PLAKAR_JOB=" + cmd.Job,
+		"PLAKAR_BACKUP_PATH=" + cmd.Path,
+	}
+}
+
+func (cmd *Backup) runHook(ctx *appcontext.AppContext, stage string, hook Hook, env []string) error {
+	stdout, stderr := io.Writer(ctx.Stdout), io.Writer(ctx.Stderr)
+	if cmd.Silent {
+		stdout, stderr = io.Discard, io.Discard
+	}
+
+	if err := hook.Run(ctx, append(cmd.hookEnv(stage), env...), stdout, stderr); err != nil {
+		return fmt.Errorf("%s-hook failed: %w", stage, err)
+	}
+	return nil
+}
+
+// DoBackup creates the snapshot, running the pre and post hooks around
+// it if any are set.
+func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
+	var hookWarning error
+
+	if cmd.PreHook.IsSet() {
+		if err := cmd.runHook(ctx, "pre", cmd.PreHook, nil); err != nil {
+			if cmd.PreHook.AbortOnFailure {
+				return 1, err, objects.MAC{}, nil
+			}
+			ctx.GetLogger().Warn("backup: %s", err)
+			hookWarning = err
+		}
+	}
+
+	status, err, snapshotID, warning := cmd.doBackup(ctx, repo)
+
+	if cmd.PostHook.IsSet() {
+		var env []string
+		switch {
+		case err != nil || status != 0:
+			env = append(env, "PLAKAR_BACKUP_STATUS=failure")
+			if err != nil {
+				env = append(env, "PLAKAR_BACKUP_ERROR="+err.Error())
+			}
+		case warning != nil:
+			env = append(env, "PLAKAR_BACKUP_STATUS=warning", "PLAKAR_BACKUP_ERROR="+warning.Error())
+		default:
+			env = append(env, "PLAKAR_BACKUP_STATUS=success")
+		}
+		if snapshotID != objects.NilMac {
+			env = append(env, fmt.Sprintf("PLAKAR_SNAPSHOT_ID=%x", snapshotID))
+		}
+
+		if hookErr := cmd.runHook(ctx, "post", cmd.PostHook, env); hookErr != nil {
+			if cmd.PostHook.AbortOnFailure && status == 0 {
+				return 1, hookErr, snapshotID, warning
+			}
+			ctx.GetLogger().Warn("backup: %s", hookErr)
+			if hookWarning == nil {
+				hookWarning = hookErr
+			}
+		}
+	}
+
+	if warning == nil {
+		warning = hookWarning
+	}
+	return status, err, snapshotID, warning
+}
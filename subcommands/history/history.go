@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package history
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &History{} },
+		subcommands.BeforeRepositoryOpen, "history")
+}
+
+type History struct {
+	subcommands.SubcommandBase
+
+	Filter history.Filter
+	Limit  int
+	JSON   bool
+}
+
+func (cmd *History) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.StringVar(&cmd.Filter.Job, "job", "", "only list the runs of this job")
+	flags.StringVar(&cmd.Filter.Type, "type", "", "only list the runs of this type of task")
+	flags.StringVar(&cmd.Filter.Status, "status", "", "only list the runs with this status: ok, warning or failure")
+	flags.Var(locate.NewTimeFlag(&cmd.Filter.Since), "since", "only list the runs started since this date")
+	flags.Var(locate.NewTimeFlag(&cmd.Filter.Before), "before", "only list the runs started before this date")
+	flags.IntVar(&cmd.Limit, "n", 0, "list at most this many runs")
+	flags.BoolVar(&cmd.JSON, "json", false, "output in JSON format")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	if cmd.Limit < 0 {
+		return fmt.Errorf("invalid number of runs: %d", cmd.Limit)
+	}
+	return cmd.Filter.Validate()
+}
+
+func (cmd *History) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	db, err := history.Open(history.Dir(ctx.CacheDir))
+	if err != nil {
+		return 1, fmt.Errorf("failed to open the history: %w", err)
+	}
+
+	entries, err := db.List(&cmd.Filter, 0, cmd.Limit)
+	if err != nil {
+		return 1, err
+	}
+
+	if cmd.JSON {
+		enc := json.NewEncoder(ctx.Stdout)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+
+	for _, entry := range entries {
+		snapshot := "-"
+		if entry.Snapshot != "" {
+			snapshot = entry.Snapshot[:8]
+		}
+		location := "-"
+		if entry.Repository != "" {
+			location = entry.Repository
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %s %s %s %s %s %s",
+			entry.StartTime.UTC().Format(time.RFC3339),
+			entry.Type, entry.Job, entry.Status,
+			entry.EndTime.Sub(entry.StartTime).Round(time.Second),
+			location, snapshot)
+		if entry.Error != "" {
+			fmt.Fprintf(ctx.Stdout, " %s", entry.Error)
+		}
+		fmt.Fprintln(ctx.Stdout)
+	}
+	return 0, nil
+}
@@ -56,6 +56,20 @@ type Maintenance struct {
 	repository    *repository.Repository
 	maintenanceID objects.MAC
 	cutoff        time.Time
+	stats         Stats
+}
+
+// Stats counts the packfiles and blobs a maintenance marked and removed.
+type Stats struct {
+	ColouredPackfiles int
+	OrphanedPackfiles int
+	RemovedBlobs      int
+	RemovedPackfiles  int
+}
+
+// Stats returns what the last Execute marked and removed.
+func (cmd *Maintenance) Stats() Stats {
+	return cmd.stats
 }
 
 // Builds the local cache of snapshot -> packfiles
@@ -206,6 +220,8 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	}
 
 	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", coloredPackfiles, orphanedPackfiles)
+	cmd.stats.ColouredPackfiles = coloredPackfiles
+	cmd.stats.OrphanedPackfiles = orphanedPackfiles
 
 	if coloredPackfiles > 0 {
 		if err := repoWriter.CommitTransaction(cmd.maintenanceID); err != nil {
@@ -263,6 +279,8 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 	}
 
 	fmt.Fprintf(ctx.Stdout, "maintenance: %d blobs and %d packfiles were removed\n", blobRemoved, len(toDelete))
+	cmd.stats.RemovedBlobs = blobRemoved
+	cmd.stats.RemovedPackfiles = len(toDelete)
 
 	if len(toDelete) > 0 {
 		if err := cmd.repository.PutCurrentState(); err != nil {
@@ -294,6 +312,7 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 	// 7. rebuild a new aggregate state with a new serial without the deleted packfiles
 
 	cmd.repository = repo
+	cmd.stats = Stats{}
 
 	// This need to be configurable per repo, but we don't have a mechanism yet (comes in a PR soon!)
 	duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
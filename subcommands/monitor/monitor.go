@@ -0,0 +1,256 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package monitor
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Monitor{} }, subcommands.AgentSupport, "monitor")
+}
+
+// Thresholds beyond which the snapshots of a job are reported.  Changes
+// are percentages of the previous snapshot, and zero disables a check.
+type Thresholds struct {
+	MaxAge      time.Duration
+	SizeChange  float64
+	FilesChange float64
+	DedupDrop   float64
+}
+
+func (t *Thresholds) Empty() bool {
+	return t.MaxAge == 0 && t.SizeChange == 0 && t.FilesChange == 0 && t.DedupDrop == 0
+}
+
+func (t *Thresholds) Validate() error {
+	if t.Empty() {
+		return fmt.Errorf("no threshold set")
+	}
+	if t.MaxAge < 0 || t.SizeChange < 0 || t.FilesChange < 0 || t.DedupDrop < 0 {
+		return fmt.Errorf("thresholds can't be negative")
+	}
+	if t.DedupDrop > 100 {
+		return fmt.Errorf("dedup drop can't exceed 100%%")
+	}
+	return nil
+}
+
+func (cmd *Monitor) Parse(ctx *appcontext.AppContext, args []string) error {
+	var jobs string
+
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&jobs, "job", "", "comma-separated list of the jobs to monitor, all by default")
+	flags.DurationVar(&cmd.Thresholds.MaxAge, "max-age", 0, "maximum age of the last snapshot of a job")
+	flags.Float64Var(&cmd.Thresholds.SizeChange, "size-change", 0, "maximum change in size, in percent")
+	flags.Float64Var(&cmd.Thresholds.FilesChange, "files-change", 0, "maximum change in number of files, in percent")
+	flags.Float64Var(&cmd.Thresholds.DedupDrop, "dedup-drop", 0, "maximum drop of the dedup ratio, in percent")
+	flags.BoolVar(&cmd.Silent, "silent", false, "suppress ALL output")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	if jobs != "" {
+		cmd.Jobs = strings.Split(jobs, ",")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	return cmd.Thresholds.Validate()
+}
+
+type Monitor struct {
+	subcommands.SubcommandBase
+
+	Jobs       []string
+	Thresholds Thresholds
+	Silent     bool
+
+	jobs      int
+	anomalies []reporting.ReportAnomaly
+}
+
+// Result returns the number of jobs monitored by the last run and the
+// anomalies found.
+func (cmd *Monitor) Result() (int, []reporting.ReportAnomaly) {
+	return cmd.jobs, cmd.anomalies
+}
+
+func (cmd *Monitor) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cmd.jobs, cmd.anomalies = 0, nil
+
+	snapshotIDs, err := locate.LocateSnapshotIDs(repo, locate.NewDefaultLocateOptions())
+	if err != nil {
+		return 1, err
+	}
+
+	jobs := make(map[string][]*header.Header)
+	for _, job := range cmd.Jobs {
+		jobs[job] = nil
+	}
+	for _, snapshotID := range snapshotIDs {
+		hdr, _, err := snapshot.GetSnapshot(repo, snapshotID)
+		if err != nil {
+			return 1, err
+		}
+		if _, ok := jobs[hdr.Job]; !ok && len(cmd.Jobs) != 0 {
+			continue
+		}
+		jobs[hdr.Job] = append(jobs[hdr.Job], hdr)
+	}
+
+	var dedup map[string]float64
+	if cmd.Thresholds.DedupDrop != 0 {
+		dedup, err = dedupRatios(ctx)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	now := time.Now()
+	names := make([]string, 0, len(jobs))
+	for job := range jobs {
+		names = append(names, job)
+	}
+	slices.Sort(names)
+	for _, job := range names {
+		cmd.anomalies = append(cmd.anomalies, cmd.Thresholds.check(job, jobs[job], dedup, now)...)
+	}
+	cmd.jobs = len(jobs)
+
+	if !cmd.Silent {
+		for _, anomaly := range cmd.anomalies {
+			fmt.Fprintf(ctx.Stdout, "%s: %s\n", anomaly.Job, anomaly.Message)
+		}
+	}
+	ctx.GetLogger().Info("monitor: %d jobs, %d anomalies", cmd.jobs, len(cmd.anomalies))
+	return 0, nil
+}
+
+// dedupRatios returns the dedup ratio of the backups recorded in the
+// history of this host, by snapshot.
+func dedupRatios(ctx *appcontext.AppContext) (map[string]float64, error) {
+	db, err := history.Open(history.Dir(ctx.CacheDir))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := db.List(&history.Filter{Type: "backup"}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]float64)
+	for _, entry := range entries {
+		if entry.Snapshot != "" && entry.Backup != nil && entry.Backup.DedupRatio != 0 {
+			ret[entry.Snapshot] = entry.Backup.DedupRatio
+		}
+	}
+	return ret, nil
+}
+
+func summarize(hdr *header.Header) (files, size uint64) {
+	for i := range hdr.Sources {
+		summary := &hdr.Sources[i].Summary
+		files += summary.Directory.Files + summary.Below.Files
+		size += summary.Directory.Size + summary.Below.Size
+	}
+	return files, size
+}
+
+// change returns the change from prev to cur in percent, and false if it
+// can't be computed.
+func change(prev, cur float64) (float64, bool) {
+	if prev == 0 {
+		return 0, false
+	}
+	return (cur - prev) / prev * 100, true
+}
+
+// check compares the last snapshot of job with the previous one, headers
+// being in any order.
+func (t *Thresholds) check(job string, headers []*header.Header, dedup map[string]float64, now time.Time) []reporting.ReportAnomaly {
+	if len(headers) == 0 {
+		return []reporting.ReportAnomaly{{
+			Job:     job,
+			Type:    "missing",
+			Message: "no snapshot",
+		}}
+	}
+
+	slices.SortFunc(headers, func(a, b *header.Header) int { return b.Timestamp.Compare(a.Timestamp) })
+	last := headers[0]
+	anomaly := func(typ, format string, args ...any) reporting.ReportAnomaly {
+		return reporting.ReportAnomaly{
+			Job:      job,
+			Snapshot: fmt.Sprintf("%x", last.Identifier),
+			Type:     typ,
+			Message:  fmt.Sprintf(format, args...),
+		}
+	}
+
+	var ret []reporting.ReportAnomaly
+	if age := now.Sub(last.Timestamp); t.MaxAge != 0 && age > t.MaxAge {
+		ret = append(ret, anomaly("stale", "last snapshot %x is %s old, more than %s",
+			last.Identifier[:4], age.Round(time.Minute), t.MaxAge))
+	}
+	if len(headers) == 1 {
+		return ret
+	}
+	prev := headers[1]
+
+	files, size := summarize(last)
+	prevFiles, prevSize := summarize(prev)
+	if pct, ok := change(float64(prevSize), float64(size)); ok && t.SizeChange != 0 && math.Abs(pct) > t.SizeChange {
+		ret = append(ret, anomaly("size", "size went from %s to %s (%+.0f%%)",
+			humanize.IBytes(prevSize), humanize.IBytes(size), pct))
+	}
+	if pct, ok := change(float64(prevFiles), float64(files)); ok && t.FilesChange != 0 && math.Abs(pct) > t.FilesChange {
+		ret = append(ret, anomaly("files", "files went from %d to %d (%+.0f%%)",
+			prevFiles, files, pct))
+	}
+
+	if t.DedupDrop != 0 {
+		ratio, ok := dedup[fmt.Sprintf("%x", last.Identifier)]
+		prevRatio, prevOk := dedup[fmt.Sprintf("%x", prev.Identifier)]
+		if pct, changed := change(prevRatio, ratio); ok && prevOk && changed && -pct > t.DedupDrop {
+			ret = append(ret, anomaly("dedup", "dedup ratio went from %.2f to %.2f (%+.0f%%)",
+				prevRatio, ratio, pct))
+		}
+	}
+	return ret
+}
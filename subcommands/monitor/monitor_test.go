@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	_ "github.com/PlakarKorp/integration-fs/exporter"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func newHeader(id byte, timestamp time.Time, files, size uint64) *header.Header {
+	return &header.Header{
+		Identifier: [32]byte{id},
+		Timestamp:  timestamp,
+		Sources: []header.Source{{
+			Summary: vfs.Summary{
+				Directory: vfs.Directory{Files: files, Size: size},
+			},
+		}},
+	}
+}
+
+func TestThresholdsCheck(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	prev := newHeader(1, now.Add(-48*time.Hour), 100, 1000)
+	last := newHeader(2, now.Add(-24*time.Hour), 150, 500)
+	dedup := map[string]float64{
+		fmt.Sprintf("%x", prev.Identifier): 4,
+		fmt.Sprintf("%x", last.Identifier): 1,
+	}
+
+	thresholds := Thresholds{
+		MaxAge:      12 * time.Hour,
+		SizeChange:  20,
+		FilesChange: 20,
+		DedupDrop:   50,
+	}
+	anomalies := thresholds.check("job", []*header.Header{last, prev}, dedup, now)
+
+	var types []string
+	for _, anomaly := range anomalies {
+		require.Equal(t, "job", anomaly.Job)
+		require.Equal(t, fmt.Sprintf("%x", last.Identifier), anomaly.Snapshot)
+		types = append(types, anomaly.Type)
+	}
+	require.Equal(t, []string{"stale", "size", "files", "dedup"}, types)
+
+	thresholds = Thresholds{
+		MaxAge:      48 * time.Hour,
+		SizeChange:  60,
+		FilesChange: 60,
+		DedupDrop:   80,
+	}
+	require.Empty(t, thresholds.check("job", []*header.Header{prev, last}, dedup, now))
+
+	anomalies = thresholds.check("job", nil, dedup, now)
+	require.Len(t, anomalies, 1)
+	require.Equal(t, "missing", anomalies[0].Type)
+}
+
+func TestThresholdsValidate(t *testing.T) {
+	require.Error(t, (&Thresholds{}).Validate())
+	require.Error(t, (&Thresholds{SizeChange: -1}).Validate())
+	require.Error(t, (&Thresholds{DedupDrop: 101}).Validate())
+	require.NoError(t, (&Thresholds{MaxAge: time.Hour}).Validate())
+}
+
+func TestExecuteCmdMonitor(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	snap.Close()
+
+	subcommand := &Monitor{}
+	err := subcommand.Parse(ctx, []string{"-job", "nightly", "-max-age", "24h"})
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	jobs, anomalies := subcommand.Result()
+	require.Equal(t, 1, jobs)
+	require.Len(t, anomalies, 1)
+	require.Equal(t, "nightly", anomalies[0].Job)
+	require.Equal(t, "missing", anomalies[0].Type)
+	require.Contains(t, bufOut.String(), "nightly: no snapshot")
+}
@@ -42,6 +42,16 @@ type Prune struct {
 	LocateOptions *locate.LocateOptions
 
 	Apply bool
+
+	plan []PlanEntry
+}
+
+// PlanEntry is the fate of a snapshot under the policy.
+type PlanEntry struct {
+	ID        objects.MAC
+	Timestamp time.Time
+	Action    string // "keep" or "delete"
+	Reason    string
 }
 
 func init() {
@@ -123,6 +133,20 @@ type planEntry struct {
 	action string // "keep" or "delete"
 }
 
+func (e *planEntry) describe() string {
+	r := e.reason
+	if r.Rule == "" {
+		return "reason=" + r.Note
+	}
+	return fmt.Sprintf("match=%s:%s rank=%d cap=%d", r.Rule, r.Bucket, r.Rank, r.Cap)
+}
+
+// Plan returns the snapshots kept and deleted by the last Execute, or that
+// would have been without Apply, newest first.
+func (cmd *Prune) Plan() []PlanEntry {
+	return cmd.plan
+}
+
 func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	_, reasons, err := locate.Match(repo, cmd.LocateOptions)
 	if err != nil {
@@ -168,21 +192,32 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		entries = append(entries, entry)
 	}
 
-	if !cmd.Apply {
-		// Sort newest-first; unknown timestamps (IsZero) go last
-		sort.SliceStable(entries, func(i, j int) bool {
-			ti, tj := entries[i].ts, entries[j].ts
-			if ti.IsZero() && tj.IsZero() {
-				return entries[i].key < entries[j].key // stable tiebreak
-			}
-			if ti.IsZero() {
-				return false
-			}
-			if tj.IsZero() {
-				return true
-			}
-			return ti.After(tj)
+	// Sort newest-first; unknown timestamps (IsZero) go last
+	sort.SliceStable(entries, func(i, j int) bool {
+		ti, tj := entries[i].ts, entries[j].ts
+		if ti.IsZero() && tj.IsZero() {
+			return entries[i].key < entries[j].key // stable tiebreak
+		}
+		if ti.IsZero() {
+			return false
+		}
+		if tj.IsZero() {
+			return true
+		}
+		return ti.After(tj)
+	})
+
+	cmd.plan = make([]PlanEntry, 0, len(entries))
+	for _, e := range entries {
+		cmd.plan = append(cmd.plan, PlanEntry{
+			ID:        e.id,
+			Timestamp: e.ts,
+			Action:    e.action,
+			Reason:    e.describe(),
 		})
+	}
+
+	if !cmd.Apply {
 		fmt.Fprintf(ctx.Stdout, "prune: would keep %d and delete %d snapshot(s), run with -apply to proceed\n", len(reasons)-len(toDelete), len(toDelete))
 		l := 0
 		for _, e := range entries {
@@ -192,13 +227,7 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			for len(e.prefix) < l {
 				e.prefix += " "
 			}
-			r := e.reason
-			if r.Rule == "" {
-				fmt.Fprintf(ctx.Stdout, "%-8s %s  reason=%s\n", e.action, e.prefix, e.reason.Note)
-			} else {
-				fmt.Fprintf(ctx.Stdout, "%-8s %s  match=%s:%s rank=%d cap=%d\n",
-					e.action, e.prefix, r.Rule, r.Bucket, r.Rank, r.Cap)
-			}
+			fmt.Fprintf(ctx.Stdout, "%-8s %s  %s\n", e.action, e.prefix, e.describe())
 		}
 		return 0, nil
 	}
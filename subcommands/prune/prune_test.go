@@ -103,3 +103,29 @@ func TestPrune_Apply_PerMinuteCap(t *testing.T) {
 	short2 := hex.EncodeToString(snap2.Header.GetIndexShortID())
 	require.NotContains(t, out, fmt.Sprintf("info: prune: removal of %s completed successfully", short2))
 }
+
+func TestPrune_Plan(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bufErr)
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	err := cmd.Parse(ctx, []string{"--per-minute=1"})
+	require.NoError(t, err)
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// newest first, the older snapshot being the one pruned
+	plan := cmd.Plan()
+	require.Len(t, plan, 2)
+	require.Equal(t, snap2.Header.Identifier, plan[0].ID)
+	require.Equal(t, "keep", plan[0].Action)
+	require.Equal(t, snap1.Header.Identifier, plan[1].ID)
+	require.Equal(t, "delete", plan[1].Action)
+	require.Contains(t, plan[1].Reason, "match=minute:")
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package report
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+type ReportList struct {
+	subcommands.SubcommandBase
+
+	Pending bool
+	Dead    bool
+}
+
+func (cmd *ReportList) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report ls", flag.ExitOnError)
+	flags.BoolVar(&cmd.Pending, "pending", false, "only list the reports waiting to be sent")
+	flags.BoolVar(&cmd.Dead, "dead", false, "only list the reports in the dead-letter queue")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	return nil
+}
+
+// deliveryState summarizes where a report stands with its emitters.
+func deliveryState(sr *reporting.SpooledReport) string {
+	var states []string
+	if len(sr.Pending) != 0 {
+		states = append(states, "pending:"+emitterNames(sr.Pending))
+	}
+	if len(sr.Dead) != 0 {
+		states = append(states, "dead:"+emitterNames(sr.Dead))
+	}
+	if len(states) == 0 {
+		return "sent"
+	}
+	return strings.Join(states, " ")
+}
+
+func emitterNames(deliveries []*reporting.Delivery) string {
+	names := make([]string, 0, len(deliveries))
+	for _, d := range deliveries {
+		names = append(names, d.Emitter)
+	}
+	return strings.Join(names, ",")
+}
+
+func (cmd *ReportList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	spool, err := openSpool(ctx)
+	if err != nil {
+		return 1, err
+	}
+
+	reports, err := spool.List()
+	if err != nil {
+		return 1, err
+	}
+	for _, sr := range reports {
+		if cmd.Pending && len(sr.Pending) == 0 {
+			continue
+		}
+		if cmd.Dead && len(sr.Dead) == 0 {
+			continue
+		}
+
+		kind, name, status := "-", "-", "-"
+		if task := sr.Report.Task; task != nil {
+			kind, name, status = task.Type, task.Name, string(task.Status)
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %s %s %s %s %s\n",
+			sr.ID,
+			sr.Report.Timestamp.UTC().Format(time.RFC3339),
+			kind, name, status,
+			deliveryState(sr))
+	}
+	return 0, nil
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package report
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &ReportList{} },
+		subcommands.BeforeRepositoryOpen, "report", "ls")
+	subcommands.Register(func() subcommands.Subcommand { return &ReportShow{} },
+		subcommands.BeforeRepositoryOpen, "report", "show")
+	subcommands.Register(func() subcommands.Subcommand { return &ReportResend{} },
+		subcommands.BeforeRepositoryOpen, "report", "resend")
+	subcommands.Register(func() subcommands.Subcommand { return &Report{} },
+		subcommands.BeforeRepositoryOpen, "report")
+}
+
+type Report struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *Report) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s ls | show | resend\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	return fmt.Errorf("no action specified")
+}
+
+func (cmd *Report) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	return 1, fmt.Errorf("no action specified")
+}
+
+func openSpool(ctx *appcontext.AppContext) (*reporting.Spool, error) {
+	spool, err := reporting.OpenSpool(reporting.SpoolDir(ctx.CacheDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the report spool: %w", err)
+	}
+	return spool, nil
+}
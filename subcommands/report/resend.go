@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package report
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+type ReportResend struct {
+	subcommands.SubcommandBase
+
+	Emitters []string
+	IDs      []string
+}
+
+func (cmd *ReportResend) Parse(ctx *appcontext.AppContext, args []string) error {
+	var emitters string
+
+	flags := flag.NewFlagSet("report resend", flag.ExitOnError)
+	flags.StringVar(&emitters, "emitter", "", "comma-separated list of emitters to send to rather than those that failed")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] report...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no report specified")
+	}
+	if emitters != "" {
+		cmd.Emitters = strings.Split(emitters, ",")
+	}
+	cmd.IDs = flags.Args()
+	return nil
+}
+
+func (cmd *ReportResend) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	spool, err := openSpool(ctx)
+	if err != nil {
+		return 1, err
+	}
+
+	for _, prefix := range cmd.IDs {
+		id, err := spool.Lookup(prefix)
+		if err != nil {
+			return 1, err
+		}
+		if err := spool.Resend(id, cmd.Emitters); err != nil {
+			return 1, fmt.Errorf("failed to queue report %s: %w", id, err)
+		}
+	}
+
+	// deliver them now rather than waiting for the agent or scheduler
+	reporter := reporting.NewReporter(ctx)
+	reporter.DeliverSpooled()
+	reporter.StopAndWait()
+
+	return 0, nil
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package report
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+type ReportShow struct {
+	subcommands.SubcommandBase
+
+	IDs []string
+}
+
+func (cmd *ReportShow) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report show", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s report...\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no report specified")
+	}
+	cmd.IDs = flags.Args()
+	return nil
+}
+
+func (cmd *ReportShow) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	spool, err := openSpool(ctx)
+	if err != nil {
+		return 1, err
+	}
+
+	enc := json.NewEncoder(ctx.Stdout)
+	enc.SetIndent("", "  ")
+	for _, id := range cmd.IDs {
+		sr, err := spool.Get(id)
+		if err != nil {
+			return 1, err
+		}
+		if err := enc.Encode(sr); err != nil {
+			return 1, fmt.Errorf("failed to encode report: %w", err)
+		}
+	}
+	return 0, nil
+}
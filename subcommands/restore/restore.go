@@ -48,6 +48,10 @@ type Restore struct {
 	Quiet       bool
 	Silent      bool
 	Snapshots   []string
+
+	// LocateOptions, when set, select the snapshots instead of the
+	// filters.
+	LocateOptions *locate.LocateOptions
 }
 
 func init() {
@@ -97,22 +101,31 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	return nil
 }
 
+// locateOptions returns the options selecting the snapshots to restore.
+func (cmd *Restore) locateOptions() *locate.LocateOptions {
+	if cmd.LocateOptions != nil {
+		locateOptions := *cmd.LocateOptions
+		return &locateOptions
+	}
+
+	locateOptions := locate.NewDefaultLocateOptions()
+	locateOptions.Filters.Latest = true
+	locateOptions.Filters.Name = cmd.OptName
+	locateOptions.Filters.Category = cmd.OptCategory
+	locateOptions.Filters.Environment = cmd.OptEnvironment
+	locateOptions.Filters.Perimeter = cmd.OptPerimeter
+	locateOptions.Filters.Job = cmd.OptJob
+	locateOptions.Filters.Tags = []string{cmd.OptTag}
+	return locateOptions
+}
+
 func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	if !cmd.Silent {
 		go eventsProcessorStdio(ctx, cmd.Quiet)
 	}
 	var snapshots []string
 	if len(cmd.Snapshots) == 0 {
-		locateOptions := locate.NewDefaultLocateOptions()
-		locateOptions.Filters.Latest = true
-
-		locateOptions.Filters.Name = cmd.OptName
-		locateOptions.Filters.Category = cmd.OptCategory
-		locateOptions.Filters.Environment = cmd.OptEnvironment
-		locateOptions.Filters.Perimeter = cmd.OptPerimeter
-		locateOptions.Filters.Job = cmd.OptJob
-		locateOptions.Filters.Tags = []string{cmd.OptTag}
-
+		locateOptions := cmd.locateOptions()
 		snapshotIDs, err := locate.LocateSnapshotIDs(repo, locateOptions)
 		if err != nil {
 			return 1, fmt.Errorf("ls: could not fetch snapshots list: %w", err)
@@ -124,14 +137,7 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 		for _, snapshotPath := range cmd.Snapshots {
 			prefix, path := locate.ParseSnapshotPath(snapshotPath)
 
-			locateOptions := locate.NewDefaultLocateOptions()
-			locateOptions.Filters.Latest = true
-			locateOptions.Filters.Name = cmd.OptName
-			locateOptions.Filters.Category = cmd.OptCategory
-			locateOptions.Filters.Environment = cmd.OptEnvironment
-			locateOptions.Filters.Perimeter = cmd.OptPerimeter
-			locateOptions.Filters.Job = cmd.OptJob
-			locateOptions.Filters.Tags = []string{cmd.OptTag}
+			locateOptions := cmd.locateOptions()
 			locateOptions.Filters.IDs = []string{prefix}
 
 			snapshotIDs, err := locate.LocateSnapshotIDs(repo, locateOptions)
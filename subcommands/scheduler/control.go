@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func connect(socketPath string) (*scheduler.Client, error) {
+	cl, err := scheduler.NewClient(socketPath, false)
+	if err != nil {
+		if err == scheduler.ErrWrongVersion {
+			return nil, fmt.Errorf("scheduler is running with a different version of plakar: %w", err)
+		}
+		return nil, fmt.Errorf("failed to connect to scheduler: %w", err)
+	}
+	return cl, nil
+}
+
+type SchedulerList struct {
+	subcommands.SubcommandBase
+	socketPath string
+}
+
+func (cmd *SchedulerList) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler list", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	cmd.socketPath = filepath.Join(ctx.CacheDir, "scheduler.sock")
+	return nil
+}
+
+func (cmd *SchedulerList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cl, err := connect(cmd.socketPath)
+	if err != nil {
+		return 1, err
+	}
+	defer cl.Close()
+
+	tasks, err := cl.ListTasks()
+	if err != nil {
+		return 1, err
+	}
+
+	for _, task := range tasks {
+		lastRun, nextRun := "never", "never"
+		if !task.LastRun.IsZero() {
+			lastRun = task.LastRun.Local().Format(time.RFC3339)
+		}
+		if !task.NextRun.IsZero() {
+			nextRun = task.NextRun.Local().Format(time.RFC3339)
+		}
+		if task.Paused {
+			nextRun = "paused"
+		}
+
+		result := task.LastResult
+		if result == "" {
+			result = "-"
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%-30s %-8s %-25s %-8s %s\n",
+			task.ID, task.State, lastRun, result, nextRun)
+		if task.QueuedOn != "" {
+			fmt.Fprintf(ctx.Stdout, "    waiting: %s\n", task.QueuedOn)
+		}
+		if task.LastError != "" {
+			fmt.Fprintf(ctx.Stdout, "    error: %s\n", task.LastError)
+		}
+	}
+	return 0, nil
+}
+
+type SchedulerReload struct {
+	subcommands.SubcommandBase
+	socketPath string
+}
+
+func (cmd *SchedulerReload) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler reload", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	cmd.socketPath = filepath.Join(ctx.CacheDir, "scheduler.sock")
+	return nil
+}
+
+func (cmd *SchedulerReload) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cl, err := connect(cmd.socketPath)
+	if err != nil {
+		return 1, err
+	}
+	defer cl.Close()
+
+	diff, err := cl.Reload()
+	if err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(ctx.Stdout, "%s\n", diff)
+	return 0, nil
+}
+
+// SchedulerTask implements the verbs acting on a single task: run, pause
+// and resume.
+type SchedulerTask struct {
+	subcommands.SubcommandBase
+	socketPath string
+	verb       string
+	name       string
+}
+
+func (cmd *SchedulerTask) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler "+cmd.verb, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s task\n", flags.Name())
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no task specified")
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	cmd.name = flags.Arg(0)
+	cmd.socketPath = filepath.Join(ctx.CacheDir, "scheduler.sock")
+	return nil
+}
+
+func (cmd *SchedulerTask) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cl, err := connect(cmd.socketPath)
+	if err != nil {
+		return 1, err
+	}
+	defer cl.Close()
+
+	switch cmd.verb {
+	case "run":
+		return cl.RunTask(cmd.name)
+	case "pause":
+		return cl.PauseTask(cmd.name)
+	case "resume":
+		return cl.ResumeTask(cmd.name)
+	}
+	return 1, fmt.Errorf("unknown action: %s", cmd.verb)
+}
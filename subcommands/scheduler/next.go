@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+type SchedulerNext struct {
+	subcommands.SubcommandBase
+	config *scheduler.Configuration
+	count  int
+}
+
+func (cmd *SchedulerNext) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_tasks string
+
+	flags := flag.NewFlagSet("scheduler next", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opt_tasks, "tasks", "", "tasks configuration file")
+	flags.IntVar(&cmd.count, "n", 1, "number of fire times to display per task")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if opt_tasks == "" {
+		return fmt.Errorf("no tasks configuration file provided")
+	}
+	if cmd.count <= 0 {
+		return fmt.Errorf("invalid -n value %d", cmd.count)
+	}
+
+	configBytes, err := loadConfigBytes(opt_tasks)
+	if err != nil {
+		return err
+	}
+	cmd.config, err = scheduler.ParseConfigBytes(configBytes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cmd *SchedulerNext) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	ledger, err := scheduler.LoadLedger(scheduler.LedgerPath(ctx.CacheDir))
+	if err != nil {
+		ctx.GetLogger().Warn("could not load the scheduler ledger: %s", err)
+	}
+
+	now := time.Now()
+	for _, entry := range cmd.config.Schedules() {
+		next := entry.NextRun(ledger, now)
+		for range cmd.count {
+			if next.IsZero() {
+				break
+			}
+			fmt.Fprintf(ctx.Stdout, "%s %s (%s)\n", next.Format(time.RFC3339),
+				entry.ID, entry.Schedule)
+			next = entry.Schedule.Next(next)
+		}
+	}
+	return 0, nil
+}
@@ -5,6 +5,7 @@ package scheduler
 import (
 	"log/syslog"
 	"os"
+	"os/signal"
 	"syscall"
 
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -51,3 +52,8 @@ func daemonize(argv []string) error {
 func stop() error {
 	return syscall.Kill(os.Getpid(), syscall.SIGINT)
 }
+
+// notifyReload relays SIGHUP, which asks for the tasks to be reloaded.
+func notifyReload(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGHUP)
+}
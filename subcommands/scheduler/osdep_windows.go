@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"errors"
+	"os"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 )
@@ -17,3 +18,6 @@ func daemonize(argv []string) error {
 func stop() error {
 	return errors.ErrUnsupported
 }
+
+func notifyReload(ch chan<- os.Signal) {
+}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/fsnotify/fsnotify"
+)
+
+// editors tend to write files in several steps, wait for things to settle
+// before reloading.
+const reloadDelay = 500 * time.Millisecond
+
+// reloadTasks reads the tasks configuration again and applies it to the
+// running scheduler.  An invalid configuration is reported and leaves the
+// scheduler untouched.
+func reloadTasks(ctx *appcontext.AppContext) (scheduler.ConfigDiff, error) {
+	schedulerContextSingleton.mtx.Lock()
+	location := schedulerContextSingleton.tasksLocation
+	schedulerContextSingleton.mtx.Unlock()
+
+	configBytes, err := loadConfigBytes(location)
+	if err != nil {
+		ctx.GetLogger().Error("reload: %s", err)
+		return scheduler.ConfigDiff{}, err
+	}
+
+	diff, err := configureTasks(configBytes)
+	if err != nil {
+		ctx.GetLogger().Error("reload: invalid configuration, keeping the current one: %s", err)
+		return diff, err
+	}
+
+	ctx.GetLogger().Info("reload: %s", diff)
+	return diff, nil
+}
+
+// watchReload reloads the tasks on SIGHUP and, if they come from a local
+// file, whenever that file changes.
+func watchReload(ctx *appcontext.AppContext, location string) {
+	signals := make(chan os.Signal, 1)
+	notifyReload(signals)
+
+	var changes <-chan struct{}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		ch, err := watchFile(ctx, location)
+		if err != nil {
+			ctx.GetLogger().Warn("could not watch %s for changes: %s", location, err)
+		}
+		changes = ch
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			reloadTasks(ctx)
+		case <-changes:
+			reloadTasks(ctx)
+		}
+	}
+}
+
+// watchFile notifies of the changes made to the file at path.  The
+// directory is watched rather than the file, as editors often replace
+// files instead of writing to them.
+func watchFile(ctx *appcontext.AppContext, path string) (<-chan struct{}, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+
+		var settle <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Name == path && ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					settle = time.After(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				ctx.GetLogger().Warn("watching %s: %s", path, err)
+			case <-settle:
+				settle = nil
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFile(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+	defer ctx.Cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tasks.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("agent: {}\n"), 0644))
+
+	changes, err := watchFile(ctx, path)
+	require.NoError(t, err)
+
+	// other files of the directory are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0644))
+	select {
+	case <-changes:
+		t.Fatal("unexpected change notification")
+	case <-time.After(2 * reloadDelay):
+	}
+
+	// replaced the way editors do
+	tmp := filepath.Join(dir, ".tasks.yaml.swp")
+	require.NoError(t, os.WriteFile(tmp, []byte("agent:\n  tasks: []\n"), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not notified")
+	}
+}
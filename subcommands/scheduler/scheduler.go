@@ -19,6 +19,11 @@ package scheduler
 import (
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -30,6 +35,18 @@ func init() {
 		subcommands.BeforeRepositoryOpen, "scheduler", "start")
 	subcommands.Register(func() subcommands.Subcommand { return &SchedulerStop{} },
 		subcommands.BeforeRepositoryOpen, "scheduler", "stop")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerNext{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "next")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerReload{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "reload")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerList{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "list")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerTask{verb: "run"} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "run")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerTask{verb: "pause"} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "pause")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerTask{verb: "resume"} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "resume")
 	subcommands.Register(func() subcommands.Subcommand { return &Scheduler{} },
 		subcommands.BeforeRepositoryOpen, "scheduler")
 }
@@ -41,7 +58,7 @@ type Scheduler struct {
 func (cmd *Scheduler) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("scheduler", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s start | stop\n",
+		fmt.Fprintf(flags.Output(), "Usage: %s start | stop | next | reload | list | run | pause | resume\n",
 			flags.Name())
 	}
 	flags.Parse(args)
@@ -55,3 +72,39 @@ func (cmd *Scheduler) Parse(ctx *appcontext.AppContext, args []string) error {
 func (cmd *Scheduler) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	return 1, fmt.Errorf("no action specified")
 }
+
+// loadConfigBytes reads the tasks configuration from either a local file or
+// an http(s) URL.
+func loadConfigBytes(location string) ([]byte, error) {
+	var rd io.Reader
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download configuration file from %q: %w", location, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("failed to download configuration file from %q: status code %d", location, resp.StatusCode)
+		}
+
+		rd = resp.Body
+	} else {
+		absolutePath, err := filepath.Abs(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for configuration file: %w", err)
+		}
+		fp, err := os.Open(absolutePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open configuration file %q: %w", absolutePath, err)
+		}
+		defer fp.Close()
+		rd = fp
+	}
+
+	configBytes, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	return configBytes, nil
+}
@@ -21,15 +21,15 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/metrics"
 	"github.com/PlakarKorp/plakar/scheduler"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
@@ -53,6 +53,7 @@ func (cmd *SchedulerStart) Parse(ctx *appcontext.AppContext, args []string) erro
 	flags.BoolVar(&opt_foreground, "foreground", false, "run in foreground")
 	flags.StringVar(&opt_logfile, "log", "", "log file")
 	flags.StringVar(&opt_tasks, "tasks", "", "tasks configuration file")
+	flags.StringVar(&cmd.metricsAddr, "metrics", "", "serve metrics at /metrics on this address")
 	flags.Parse(args)
 	if flags.NArg() != 0 {
 		return fmt.Errorf("too many arguments")
@@ -62,41 +63,16 @@ func (cmd *SchedulerStart) Parse(ctx *appcontext.AppContext, args []string) erro
 		return fmt.Errorf("no tasks configuration file provided")
 	}
 
-	var rd io.Reader
-	if strings.HasPrefix(opt_tasks, "http://") || strings.HasPrefix(opt_tasks, "https://") {
-		resp, err := http.Get(opt_tasks)
-		if err != nil {
-			return fmt.Errorf("failed to download configuration file from %q: %w", opt_tasks, err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("failed to download configuration file from %q: status code %d", opt_tasks, resp.StatusCode)
-		}
-
-		rd = resp.Body
-	} else {
-		absolutePath, err := filepath.Abs(opt_tasks)
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path for configuration file: %w", err)
-		}
-		fp, err := os.Open(absolutePath)
-		if err != nil {
-			return fmt.Errorf("failed to open configuration file %q: %w", absolutePath, err)
-		}
-		defer fp.Close()
-		rd = fp
-	}
-
-	configBytes, err := io.ReadAll(rd)
+	configBytes, err := loadConfigBytes(opt_tasks)
 	if err != nil {
-		return fmt.Errorf("failed to read configuration file: %w", err)
+		return err
 	}
 	_, err = scheduler.ParseConfigBytes(configBytes)
 	if err != nil {
 		return err
 	}
 	cmd.schedConfigBytes = configBytes
+	cmd.tasksLocation = opt_tasks
 
 	if !opt_foreground && os.Getenv("REEXEC") == "" {
 		err := daemonize(os.Args)
@@ -134,24 +110,51 @@ type SchedulerContext struct {
 	agentCtx        *appcontext.AppContext
 	schedulerCtx    *appcontext.AppContext
 	schedulerConfig *scheduler.Configuration
+	scheduler       *scheduler.Scheduler
 	schedulerState  schedulerState
+	tasksLocation   string
 	mtx             sync.Mutex
 }
 
 type SchedulerStart struct {
 	subcommands.SubcommandBase
 	socketPath       string
+	tasksLocation    string
 	schedConfigBytes []byte
+	metricsAddr      string
 }
 
 func (cmd *SchedulerStart) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	schedulerContextSingleton = &SchedulerContext{
-		agentCtx: ctx,
+		agentCtx:      ctx,
+		tasksLocation: cmd.tasksLocation,
+	}
+
+	// fail before starting the tasks if the metrics can't be served
+	if cmd.metricsAddr != "" {
+		db, err := history.Open(history.Dir(ctx.CacheDir))
+		if err != nil {
+			return 1, fmt.Errorf("failed to open the history: %w", err)
+		}
+		listener, err := net.Listen("tcp", cmd.metricsAddr)
+		if err != nil {
+			return 1, fmt.Errorf("failed to bind the metrics address: %w", err)
+		}
+		go func() {
+			err := metrics.Serve(ctx, listener,
+				metrics.NewHistoryCollector(db),
+				metrics.NewSchedulerCollector(schedulerTasks))
+			if err != nil {
+				ctx.GetLogger().Error("failed to serve the metrics: %s", err)
+			}
+		}()
 	}
 
 	configureTasks(cmd.schedConfigBytes)
 	startTasks()
 
+	go watchReload(ctx, cmd.tasksLocation)
+
 	if err := cmd.ListenAndServe(ctx); err != nil {
 		return 1, err
 	}
@@ -213,7 +216,7 @@ func (cmd *SchedulerStart) ListenAndServe(ctx *appcontext.AppContext) error {
 	}
 }
 
-func handleClient(_ *appcontext.AppContext, conn net.Conn) {
+func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 	defer conn.Close()
 
 	encoder := msgpack.NewEncoder(conn)
@@ -245,6 +248,29 @@ func handleClient(_ *appcontext.AppContext, conn net.Conn) {
 		} else {
 			response.ExitCode = 0
 		}
+	case "list":
+		sched, err := runningScheduler()
+		if err == nil {
+			response.Payload, err = msgpack.Marshal(sched.Tasks())
+		}
+		if err != nil {
+			response.ExitCode = 1
+			response.Err = err.Error()
+		}
+	case "reload":
+		diff, err := reloadTasks(ctx)
+		if err == nil {
+			response.Payload, err = msgpack.Marshal(diff)
+		}
+		if err != nil {
+			response.ExitCode = 1
+			response.Err = err.Error()
+		}
+	case "run", "pause", "resume":
+		if err := handleTaskRequest(request); err != nil {
+			response.ExitCode = 1
+			response.Err = err.Error()
+		}
 	default:
 		response.ExitCode = 1
 		response.Err = fmt.Sprintf("unknown command: %s", request.Type)
@@ -255,6 +281,47 @@ func handleClient(_ *appcontext.AppContext, conn net.Conn) {
 	}
 }
 
+func handleTaskRequest(request scheduler.Request) error {
+	var payload scheduler.TaskRequest
+	if err := msgpack.Unmarshal(request.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	sched, err := runningScheduler()
+	if err != nil {
+		return err
+	}
+
+	switch request.Type {
+	case "run":
+		return sched.RunTask(payload.Name)
+	case "pause":
+		return sched.PauseTask(payload.Name)
+	default:
+		return sched.ResumeTask(payload.Name)
+	}
+}
+
+func runningScheduler() (*scheduler.Scheduler, error) {
+	schedulerContextSingleton.mtx.Lock()
+	defer schedulerContextSingleton.mtx.Unlock()
+
+	if schedulerContextSingleton.scheduler == nil {
+		return nil, fmt.Errorf("agent scheduler is not running")
+	}
+	return schedulerContextSingleton.scheduler, nil
+}
+
+// schedulerTasks returns the status of the tasks of the running scheduler,
+// if any.
+func schedulerTasks() []scheduler.TaskStatus {
+	sched, err := runningScheduler()
+	if err != nil {
+		return nil
+	}
+	return sched.Tasks()
+}
+
 func startTasks() (int, error) {
 	schedulerContextSingleton.mtx.Lock()
 	defer schedulerContextSingleton.mtx.Unlock()
@@ -269,7 +336,8 @@ func startTasks() (int, error) {
 
 	// this needs to execute in the agent context, not the client context
 	schedulerContextSingleton.schedulerCtx = appcontext.NewAppContextFrom(schedulerContextSingleton.agentCtx)
-	go scheduler.NewScheduler(schedulerContextSingleton.schedulerCtx, schedulerContextSingleton.schedulerConfig).Run()
+	schedulerContextSingleton.scheduler = scheduler.NewScheduler(schedulerContextSingleton.schedulerCtx, schedulerContextSingleton.schedulerConfig)
+	go schedulerContextSingleton.scheduler.Run()
 
 	schedulerContextSingleton.schedulerState = AGENT_SCHEDULER_RUNNING
 
@@ -280,21 +348,20 @@ func terminate() (int, error) {
 	return 0, stop()
 }
 
-func configureTasks(schedConfigBytes []byte) (int, error) {
+func configureTasks(schedConfigBytes []byte) (scheduler.ConfigDiff, error) {
 	schedConfig, err := scheduler.ParseConfigBytes(schedConfigBytes)
 	if err != nil {
-		return 1, err
+		return scheduler.ConfigDiff{}, err
 	}
 
 	schedulerContextSingleton.mtx.Lock()
 	defer schedulerContextSingleton.mtx.Unlock()
 
-	if schedulerContextSingleton.schedulerCtx != nil {
-		schedulerContextSingleton.schedulerCtx.Cancel()
-		schedulerContextSingleton.schedulerCtx = appcontext.NewAppContextFrom(schedulerContextSingleton.agentCtx)
-		go scheduler.NewScheduler(schedulerContextSingleton.schedulerCtx, schedConfig).Run()
+	var diff scheduler.ConfigDiff
+	if schedulerContextSingleton.scheduler != nil {
+		diff = schedulerContextSingleton.scheduler.Reload(schedConfig)
 	}
 
 	schedulerContextSingleton.schedulerConfig = schedConfig
-	return 0, nil
+	return diff, nil
 }
@@ -7,7 +7,6 @@ import (
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
-	"github.com/PlakarKorp/plakar/scheduler"
 	"github.com/PlakarKorp/plakar/subcommands"
 )
 
@@ -31,12 +30,9 @@ func (cmd *SchedulerStop) Parse(ctx *appcontext.AppContext, args []string) error
 }
 
 func (cmd *SchedulerStop) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	cl, err := scheduler.NewClient(cmd.socketPath, false)
+	cl, err := connect(cmd.socketPath)
 	if err != nil {
-		if err == scheduler.ErrWrongVersion {
-			return 1, fmt.Errorf("scheduler is running with a different version of plakar: %w", err)
-		}
-		return 1, fmt.Errorf("failed to connect to scheduler: %w", err)
+		return 1, err
 	}
 	defer cl.Close()
 
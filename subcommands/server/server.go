@@ -17,8 +17,11 @@
 package server
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
+	"path/filepath"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -32,6 +35,9 @@ func init() {
 
 func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_allowdelete bool
+	var opt_tlsCert, opt_tlsKey, opt_tlsCA, opt_credentials string
+	var opt_stores bool
+	var opt_root, opt_credentialsDir string
 	flags := flag.NewFlagSet("server", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
@@ -41,29 +47,126 @@ func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.StringVar(&cmd.ListenAddr, "listen", "localhost:9876", "address to listen on")
 	flags.BoolVar(&opt_allowdelete, "allow-delete", false, "enable delete operations")
+	flags.StringVar(&opt_tlsCert, "tls-cert", "", "certificate of the server, enables TLS")
+	flags.StringVar(&opt_tlsKey, "tls-key", "", "key of the server certificate")
+	flags.StringVar(&opt_tlsCA, "tls-ca", "", "certificate authority of the clients, requires client certificates")
+	flags.StringVar(&opt_credentials, "credentials", "", "file of the clients allowed to use the server")
+	flags.BoolVar(&opt_stores, "stores", false, "serve all the configured stores")
+	flags.StringVar(&opt_root, "root", "", "serve the stores in this directory, and let clients create new ones")
+	flags.StringVar(&opt_credentialsDir, "credentials-dir", "", "directory of the credentials of each store, with -stores or -root")
 	flags.Parse(args)
 
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if (opt_tlsCert == "") != (opt_tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be used together")
+	}
+	if opt_tlsCA != "" && opt_tlsCert == "" {
+		return fmt.Errorf("-tls-ca requires -tls-cert and -tls-key")
+	}
+	if opt_tlsCert != "" {
+		var err error
+		cmd.TLSConfig, err = httpd.TLSConfig(opt_tlsCert, opt_tlsKey, opt_tlsCA)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opt_credentials != "" {
+		var err error
+		cmd.Credentials, err = httpd.LoadCredentials(opt_credentials)
+		if err != nil {
+			return fmt.Errorf("failed to load the credentials: %w", err)
+		}
+	}
+
+	// the tokens and passwords would be sent in clear
+	if (opt_credentials != "" || opt_credentialsDir != "") && cmd.TLSConfig == nil && !isLoopback(cmd.ListenAddr) {
+		return fmt.Errorf("credentials require -tls-cert and -tls-key unless listening on the loopback")
+	}
+
 	noDelete := true
 	if opt_allowdelete {
 		noDelete = false
 	}
 
+	if opt_credentialsDir != "" && !opt_stores && opt_root == "" {
+		return fmt.Errorf("-credentials-dir requires -stores or -root")
+	}
+	// anyone could create stores otherwise
+	if opt_root != "" && opt_credentials == "" && opt_credentialsDir == "" && opt_tlsCA == "" {
+		return fmt.Errorf("-root requires -credentials, -credentials-dir or -tls-ca")
+	}
+	if opt_stores || opt_root != "" {
+		cmd.Hosting = &httpd.Hosting{
+			Root:           opt_root,
+			CredentialsDir: opt_credentialsDir,
+		}
+		if opt_stores {
+			cmd.Hosting.Stores = ctx.Config.Repositories
+		}
+		if opt_root != "" {
+			root, err := filepath.Abs(opt_root)
+			if err != nil {
+				return err
+			}
+			cmd.Hosting.Root = root
+		}
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.NoDelete = noDelete
 
 	return nil
 }
 
+// isLoopback tells whether addr only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 type Server struct {
 	subcommands.SubcommandBase
 
-	ListenAddr string
-	NoDelete   bool
+	ListenAddr  string
+	NoDelete    bool
+	TLSConfig   *tls.Config
+	Credentials *httpd.Credentials
+
+	// Hosting is set to serve many stores rather than the repository.
+	Hosting *httpd.Hosting
 }
 
 func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	ctx.GetLogger().Info("listening on http://%s", cmd.ListenAddr)
-	err := httpd.Server(ctx, repo, cmd.ListenAddr, cmd.NoDelete)
+	opts := &httpd.Options{
+		NoDelete:    cmd.NoDelete,
+		TLSConfig:   cmd.TLSConfig,
+		Credentials: cmd.Credentials,
+		UsageDir:    httpd.UsageDir(ctx.CacheDir),
+	}
+
+	scheme := "http"
+	if cmd.TLSConfig != nil {
+		scheme = "https"
+	}
+	ctx.GetLogger().Info("listening on %s://%s", scheme, cmd.ListenAddr)
+
+	var err error
+	if cmd.Hosting != nil {
+		err = httpd.Host(ctx.GetInner(), cmd.ListenAddr, opts, cmd.Hosting)
+	} else {
+		err = httpd.Server(ctx, repo, cmd.ListenAddr, opts)
+	}
 	if err != nil {
 		return 1, err
 	}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -15,7 +16,9 @@ import (
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/kloset/versioning"
+	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/network"
+	"github.com/PlakarKorp/plakar/server/httpd"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -84,3 +87,34 @@ func TestExecuteCmdServerDefault(t *testing.T) {
 	// we dont test all the field from configuration
 	require.Equal(t, versioning.FromString(storage.VERSION), configInstance.Version)
 }
+
+func TestParseCredentialsWithoutTLS(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	creds := filepath.Join(t.TempDir(), "credentials.yml")
+	require.NoError(t, os.WriteFile(creds, []byte(`version: v1.0.0
+clients:
+  - name: alice
+    role: admin
+    token: `+httpd.HashToken("alice")+`
+`), 0600))
+
+	// the token would be sent in clear
+	err := (&Server{}).Parse(ctx, []string{"-listen", ":9876", "-credentials", creds})
+	require.ErrorContains(t, err, "credentials require -tls-cert")
+
+	require.NoError(t, (&Server{}).Parse(ctx, []string{"-listen", "localhost:9876", "-credentials", creds}))
+	require.NoError(t, (&Server{}).Parse(ctx, []string{"-listen", "[::1]:9876", "-credentials", creds}))
+}
+
+func TestParseRootWithoutCredentials(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	// anyone could create stores
+	err := (&Server{}).Parse(ctx, []string{"-listen", "localhost:9876", "-root", t.TempDir()})
+	require.ErrorContains(t, err, "-root requires")
+
+	require.NoError(t, (&Server{}).Parse(ctx, []string{"-listen", "localhost:9876", "-root", t.TempDir(), "-credentials-dir", t.TempDir()}))
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -35,6 +36,15 @@ type Subcommand interface {
 	SetLogInfo(bool)
 	GetLogTraces() string
 	SetLogTraces(string)
+
+	GetAttempts() []reporting.ReportAttempt
+	SetAttempts([]reporting.ReportAttempt)
+}
+
+// CommandRunner is implemented by the subcommands that may run shell
+// commands of their own, which the agent only accepts from local clients.
+type CommandRunner interface {
+	RunsCommands() bool
 }
 
 type SubcommandBase struct {
@@ -46,6 +56,10 @@ type SubcommandBase struct {
 	// XXX - rework that post-release
 	LogInfo   bool
 	LogTraces string
+
+	// Attempts are the failed attempts of the scheduled task the command
+	// is run for, when it is retried.
+	Attempts []reporting.ReportAttempt
 }
 
 func (cmd *SubcommandBase) setFlags(flags CommandFlags) {
@@ -88,6 +102,14 @@ func (cmd *SubcommandBase) SetLogTraces(traces string) {
 	cmd.LogTraces = traces
 }
 
+func (cmd *SubcommandBase) GetAttempts() []reporting.ReportAttempt {
+	return cmd.Attempts
+}
+
+func (cmd *SubcommandBase) SetAttempts(attempts []reporting.ReportAttempt) {
+	cmd.Attempts = attempts
+}
+
 func (cmd *SubcommandBase) GetRepositorySecret() []byte {
 	return cmd.RepositorySecret
 }
@@ -42,6 +42,15 @@ type Sync struct {
 	PackfileTempStorage string
 
 	SrcLocateOptions *locate.LocateOptions
+
+	synchronized int
+	failed       int
+}
+
+// Stats returns the number of snapshots the last Execute synchronized and
+// failed to.
+func (cmd *Sync) Stats() (synchronized, failed int) {
+	return cmd.synchronized, cmd.failed
 }
 
 func init() {
@@ -155,6 +164,8 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 }
 
 func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cmd.synchronized, cmd.failed = 0, 0
+
 	storeConfig, err := ctx.Config.GetRepository(cmd.PeerRepositoryLocation)
 	if err != nil {
 		return 1, fmt.Errorf("peer store: %w", err)
@@ -259,8 +270,10 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		if err != nil {
 			ctx.GetLogger().Error("failed to synchronize snapshot %x from store %s: %s",
 				snapshotID[:4], srcLocation, err)
+			cmd.failed++
 		} else {
 			srcSynced++
+			cmd.synchronized++
 		}
 	}
 
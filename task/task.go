@@ -1,19 +1,123 @@
 package task
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/history"
 	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/monitor"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
-	"github.com/PlakarKorp/plakar/subcommands/sync"
+	psync "github.com/PlakarKorp/plakar/subcommands/sync"
 )
 
+// jobName returns the job a command works on, defaulting to the name of
+// its task.
+func jobName(cmd subcommands.Subcommand, taskName string) string {
+	job := ""
+	switch cmd := cmd.(type) {
+	case *backup.Backup:
+		job = cmd.Job
+	case *check.Check:
+		if cmd.LocateOptions != nil {
+			job = cmd.LocateOptions.Filters.Job
+		}
+	case *psync.Sync:
+		if cmd.SrcLocateOptions != nil {
+			job = cmd.SrcLocateOptions.Filters.Job
+		}
+	case *rm.Rm:
+		if cmd.LocateOptions != nil {
+			job = cmd.LocateOptions.Filters.Job
+		}
+	case *prune.Prune:
+		if cmd.LocateOptions != nil {
+			job = cmd.LocateOptions.Filters.Job
+		}
+	case *restore.Restore:
+		job = cmd.OptJob
+	case *monitor.Monitor:
+		job = strings.Join(cmd.Jobs, ",")
+	}
+	if job == "" {
+		return taskName
+	}
+	return job
+}
+
+// RepositoryStatsInterval is how often the size and snapshots of a
+// repository are computed, after the commands changing it, as that reads
+// its whole state.
+const RepositoryStatsInterval = 15 * time.Minute
+
+var statsMu sync.Mutex
+
+// statsPath returns the file recording when the stats of each repository
+// were last computed, shared by all the commands using cacheDir.
+func statsPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "repository-stats.json")
+}
+
+// changesRepository tells whether cmd writes to the repository, dry runs
+// excluded.
+func changesRepository(cmd subcommands.Subcommand) bool {
+	switch cmd := cmd.(type) {
+	case *backup.Backup:
+		return !cmd.DryRun
+	case *rm.Rm:
+		return cmd.Apply
+	case *prune.Prune:
+		return cmd.Apply
+	case *maintenance.Maintenance:
+		return true
+	}
+	return false
+}
+
+// statsDue tells whether the stats of the repository at location are to
+// be computed again, and if so resets its timer in cacheDir.
+func statsDue(cacheDir, location string) bool {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	path := statsPath(cacheDir)
+	last := make(map[string]time.Time)
+	if data, err := os.ReadFile(path); err == nil {
+		// a damaged file only makes the stats computed again
+		json.Unmarshal(data, &last)
+	}
+
+	now := time.Now()
+	if t, ok := last[location]; ok && now.Sub(t) < RepositoryStatsInterval {
+		return false
+	}
+	last[location] = now.Round(0)
+
+	data, err := json.Marshal(last)
+	if err != nil {
+		return true
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err == nil {
+		os.Rename(tmp, path)
+	}
+	return true
+}
+
 func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *repository.Repository, taskName string) (int, error) {
 	location := ""
 	var err error
@@ -36,22 +140,38 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 		taskKind = "check"
 	case *restore.Restore:
 		taskKind = "restore"
-	case *sync.Sync:
+	case *psync.Sync:
 		taskKind = "sync"
 	case *rm.Rm:
 		taskKind = "rm"
 	case *maintenance.Maintenance:
 		taskKind = "maintenance"
+	case *prune.Prune:
+		taskKind = "prune"
+	case *monitor.Monitor:
+		taskKind = "monitor"
 	default:
 		report.SetIgnore()
 	}
 
 	report.TaskStart(taskKind, taskName)
+	report.WithAttempts(cmd.GetAttempts())
 	if repo != nil {
 		report.WithRepositoryName(location)
 		report.WithRepository(repo)
 	}
 
+	var collector *reporting.Collector
+	switch cmd.(type) {
+	case *backup.Backup, *check.Check, *psync.Sync:
+		collector = reporting.NewCollector(ctx.Events())
+	}
+
+	var wbytes int64
+	if repo != nil {
+		wbytes = repo.WBytes()
+	}
+
 	var status int
 	var snapshotID objects.MAC
 	var warning error
@@ -65,6 +185,38 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 		status, err = cmd.Execute(ctx, repo)
 	}
 
+	switch cmd := cmd.(type) {
+	case *backup.Backup:
+		report.WithBackup(collector, uint64(repo.WBytes()-wbytes))
+	case *check.Check:
+		report.WithCheck(collector)
+	case *psync.Sync:
+		synchronized, failed := cmd.Stats()
+		report.WithSync(collector, synchronized, failed)
+	case *maintenance.Maintenance:
+		stats := cmd.Stats()
+		report.WithMaintenance(stats.ColouredPackfiles, stats.OrphanedPackfiles, stats.RemovedBlobs, stats.RemovedPackfiles)
+	case *prune.Prune:
+		for _, entry := range cmd.Plan() {
+			report.WithPruneSnapshot(!cmd.Apply, entry.ID, entry.Timestamp, entry.Action, entry.Reason)
+		}
+	case *monitor.Monitor:
+		jobs, anomalies := cmd.Result()
+		report.WithMonitor(jobs, anomalies)
+		if len(anomalies) != 0 {
+			warning = fmt.Errorf("%d anomalies found", len(anomalies))
+		}
+	}
+
+	if repo != nil && err == nil && changesRepository(cmd) && statsDue(ctx.CacheDir, location) {
+		report.WithRepositoryStats()
+	}
+
+	// the scheduler retried the task, this is the last attempt so far
+	if len(report.Task.Attempts) != 0 {
+		report.TaskAttempt(report.Task.StartTime, err)
+	}
+
 	if status == 0 {
 		if warning != nil {
 			report.TaskWarning("warning: %s", warning)
@@ -72,7 +224,13 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 			report.TaskDone()
 		}
 	} else if err != nil {
-		report.TaskFailed(0, "error: %s", err)
+		report.TaskFailed(reporting.TaskErrorCode(status), "error: %s", err)
+	}
+
+	if taskKind != "" {
+		if err := history.Record(ctx.CacheDir, report, jobName(cmd, taskName)); err != nil {
+			ctx.GetLogger().Warn("failed to record the task in the history: %s", err)
+		}
 	}
 
 	reporter.StopAndWait()
@@ -0,0 +1,27 @@
+package task
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsDue(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	require.True(t, statsDue(cacheDir, "fs:///var/backups"))
+	require.False(t, statsDue(cacheDir, "fs:///var/backups"))
+	require.True(t, statsDue(cacheDir, "fs:///srv/backups"))
+
+	// another command computed them long ago
+	data, err := json.Marshal(map[string]time.Time{
+		"fs:///var/backups": time.Now().Add(-2 * RepositoryStatsInterval),
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(statsPath(cacheDir), data, 0600))
+	require.True(t, statsDue(cacheDir, "fs:///var/backups"))
+	require.False(t, statsDue(cacheDir, "fs:///var/backups"))
+}